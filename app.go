@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/go-redis/redis/v7"
@@ -17,12 +18,57 @@ import (
 
 // HailingApp app
 type HailingApp struct {
-	bot         *linebot.Client
-	rdb         *redis.Client
-	pdb         *sql.DB
+	messenger     Messenger
+	sessions      SessionStore
+	sessionTTL    time.Duration
+	pdb           *sql.DB
+	users         UserStore
+	trips         TripStore
+	locations     LocationStore
+	subscriptions SubscriptionStore
+	places        PlaceStore
+	// geocoder names pins which aren't at any location
+	geocoder ReverseGeocoder
+	// routing finds routes for travel time & trip validation
+	routing RoutingProvider
+	// travelPlanners are the options riders compare, see TravelOption
+	travelPlanners []TravelPlanner
+	// staticMap draws route maps of trip cards, which are removed after
+	// routeMapTTL
+	staticMap   *StaticMap
+	routeMapTTL time.Duration
+	// suggestions ranks places for "to" & "from" by the rider's trips
+	suggestions *SuggestionEngine
 	appBaseURL  string
 	downloadDir string
 	i18nBundle  *i18n.Bundle
+	// bookingHorizon is how far ahead riders can book
+	bookingHorizon time.Duration
+	// reminders are pushed reminderLeadTime before pickup time,
+	// checked every reminderInterval
+	reminderLeadTime time.Duration
+	reminderInterval time.Duration
+	// trips of recurring rides are created subscriptionLeadTime ahead
+	subscriptionLeadTime time.Duration
+	// trip ends within locationRadius (m) count for the location's
+	// popularity, which is recomputed every popularityInterval and
+	// halves every popularityHalfLife
+	locationRadius     float64
+	popularityInterval time.Duration
+	popularityHalfLife time.Duration
+	// shared pins are snapped to the nearest location within
+	// pickupSnapRadius (m)
+	pickupSnapRadius float64
+	// a trip is rejected when pickup is within minTripDistance (m) of the
+	// destination, the car route is longer than maxRouteLength (m), or the
+	// route goes more than routeZoneTolerance (m) outside the service area
+	minTripDistance    float64
+	maxRouteLength     float64
+	routeZoneTolerance float64
+	// a trip takes 1 to maxPassengers riders
+	maxPassengers int
+	// channelSecret verifies signature of LINE webhook requests
+	channelSecret string
 }
 
 // NewHailingApp function
@@ -58,27 +104,99 @@ func NewHailingApp(channelSecret, channelToken, appBaseURL string) (*HailingApp,
 		}
 	}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: redisPassword,
-		DB:       redisDB,
-	})
+	sessionPrefix := os.Getenv("SESSION_PREFIX")
+	if sessionPrefix == "" {
+		sessionPrefix = "hailing:"
+	}
+	sessionTTL := envDuration("SESSION_TTL", 10*time.Minute)
+	var sessions SessionStore
+	switch os.Getenv("SESSION_BACKEND") {
+	case "memory":
+		sessions = NewMemorySessionStore(sessionPrefix)
+	default:
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     redisAddr,
+			Password: redisPassword,
+			DB:       redisDB,
+		})
+		sessions = NewRedisSessionStore(rdb, sessionPrefix)
+	}
 
 	psqlDB, err := sql.Open("postgres", postgresURI)
+	var users UserStore
+	var trips TripStore
+	var locations LocationStore
+	var subscriptions SubscriptionStore
+	var places PlaceStore
+	switch os.Getenv("STORE_BACKEND") {
+	case "memory":
+		// everything is gone after restart, only good for demo
+		mem := NewMemoryStore()
+		mem.SeedLocations()
+		users, trips, locations, subscriptions, places = mem, mem, mem, mem, mem
+	default:
+		pg := NewPostgresStore(psqlDB)
+		users, trips, locations, subscriptions, places = pg, pg, pg, pg, pg
+	}
+
+	// words, service area & fares files are checked before anything depends on them
+	Vocabulary()
+	CurrentServiceArea()
+	CurrentFares()
 
 	bundle := i18n.NewBundle(language.English)
 	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
 	bundle.MustLoadMessageFile("active.th.toml")
 	bundle.MustLoadMessageFile("active.ja.toml")
 
-	return &HailingApp{
-		bot:         bot,
-		rdb:         rdb,
-		pdb:         psqlDB,
-		appBaseURL:  appBaseURL,
-		downloadDir: downloadDir,
-		i18nBundle:  bundle,
-	}, nil
+	app := &HailingApp{
+		messenger:     NewLineMessenger(bot),
+		sessions:      sessions,
+		sessionTTL:    sessionTTL,
+		pdb:           psqlDB,
+		users:         users,
+		trips:         trips,
+		locations:     locations,
+		subscriptions: subscriptions,
+		places:        places,
+		geocoder:      NewReverseGeocoder(locations),
+		routing:       NewRoutingProvider(sessions),
+		suggestions:   NewSuggestionEngine(),
+		appBaseURL:    appBaseURL,
+		downloadDir:   downloadDir,
+		staticMap:     NewStaticMap(),
+		routeMapTTL:   envDuration("MAP_TTL", 72*time.Hour),
+		i18nBundle:    bundle,
+
+		bookingHorizon:   envDuration("BOOKING_HORIZON", 24*time.Hour),
+		reminderLeadTime: envDuration("REMINDER_LEAD_TIME", time.Hour),
+		reminderInterval: envDuration("REMINDER_INTERVAL", time.Minute),
+
+		subscriptionLeadTime: envDuration("SUBSCRIPTION_LEAD_TIME", 3*time.Hour),
+
+		locationRadius:     envFloat("LOCATION_RADIUS", 150),
+		popularityInterval: envDuration("POPULARITY_INTERVAL", time.Hour),
+		popularityHalfLife: envDuration("POPULARITY_HALF_LIFE", 30*24*time.Hour),
+		pickupSnapRadius:   envFloat("PICKUP_SNAP_RADIUS", 200),
+
+		minTripDistance:    envFloat("MIN_TRIP_DISTANCE", 200),
+		maxRouteLength:     envFloat("MAX_ROUTE_LENGTH", 20000),
+		routeZoneTolerance: envFloat("ROUTE_ZONE_TOLERANCE", 300),
+		maxPassengers:      envInt("MAX_PASSENGERS", 4),
+
+		channelSecret: channelSecret,
+	}
+	app.travelPlanners = NewTravelPlanners(app)
+	return app, nil
+}
+
+// envDuration reads duration e.g. "72h" from env or returns fallback
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
 }
 
 // Localizer returns both user and localizer which is helpful for all i18n text
@@ -91,3 +209,21 @@ func (app *HailingApp) Localizer(lineUserID string) (*User, *i18n.Localizer, err
 	localizer := i18n.NewLocalizer(app.i18nBundle, lang)
 	return user, localizer, nil
 }
+
+// envInt reads a positive integer from env or returns fallback
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// envFloat reads a positive number from env or returns fallback
+func envFloat(key string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || f <= 0 {
+		return fallback
+	}
+	return f
+}
@@ -37,7 +37,7 @@ import (
 
 // Callback function for linebot
 func (app *HailingApp) Callback(w http.ResponseWriter, r *http.Request) {
-	events, err := app.bot.ParseRequest(r)
+	events, err := linebot.ParseRequest(app.channelSecret, r)
 	if err != nil {
 		if err == linebot.ErrInvalidSignature {
 			w.WriteHeader(400)
@@ -84,6 +84,14 @@ func (app *HailingApp) extractReplyFromPostback(event *linebot.Event) error {
 		reply = Reply{Text: "last-step-confirmation"}
 	case "cancel":
 		reply = Reply{Text: "cancel"}
+	case "travel":
+		// option taken on the travel options carousel
+		if len(postbackType) != 2 {
+			return app.UnhandledCase(event.ReplyToken)
+		}
+		return app.TravelOptionHandler(event.ReplyToken, lineUserID, postbackType[1])
+	case "edit":
+		reply = Reply{Text: data} // i.e. edit:to
 	case "from":
 		msg := fmt.Sprintf("%v", event.Postback.Params)
 		reply = Reply{Text: msg}
@@ -99,6 +107,20 @@ func (app *HailingApp) extractReplyFromPostback(event *linebot.Event) error {
 			log.Println(err)
 		}
 		reply = Reply{Text: "modify-pickup-time", Datetime: t}
+	case "when", "when-change":
+		// pickup time from words which the rider confirms
+		if len(postbackType) != 2 {
+			return app.UnhandledCase(event.ReplyToken)
+		}
+		sec, err := strconv.ParseInt(postbackType[1], 10, 64)
+		if err != nil {
+			log.Println(err)
+			return app.UnhandledCase(event.ReplyToken)
+		}
+		reply = Reply{Text: "datetime", Datetime: time.Unix(sec, 0)}
+		if postbackType[0] == "when-change" {
+			reply.Text = "modify-pickup-time"
+		}
 	case "location-options":
 		reply = Reply{Text: "location-options"}
 	case "location":
@@ -120,12 +142,28 @@ func (app *HailingApp) extractReplyFromPostback(event *linebot.Event) error {
 			Text:   locationItem.Name,
 			Coords: locationItem.Place.Coordinates,
 		}
+	case "place":
+		// rider's saved place
+		if len(postbackType) < 2 {
+			return app.UnhandledCase(event.ReplyToken)
+		}
+		placeReply, err := app.SavedPlaceReply(lineUserID, postbackType[1])
+		if err != nil {
+			return app.replyText(event.ReplyToken, err.Error())
+		}
+		reply = placeReply
 	case "star-feedback":
 		if len(postbackType) != 3 {
 			log.Printf("[PostbackExtractor] star-feedback unhandled case : data: %v\n", data)
 			return app.UnhandledCase(event.ReplyToken)
 		}
 		return app.FeedbackHandler(event.ReplyToken, lineUserID, postbackType[1], postbackType[2])
+	case "reminder":
+		if len(postbackType) != 3 {
+			log.Printf("[PostbackExtractor] reminder unhandled case : data: %v\n", data)
+			return app.UnhandledCase(event.ReplyToken)
+		}
+		return app.ReminderHandler(event.ReplyToken, lineUserID, postbackType[1], postbackType[2])
 	case "datetime":
 		layout := "2006-01-02T15:04-07:00"
 		str := fmt.Sprintf("%v+07:00", event.Postback.Params.Datetime)
@@ -159,6 +197,7 @@ func (app *HailingApp) extractReplyFromMessage(event *linebot.Event) error {
 	case *linebot.LocationMessage:
 		loc := event.Message.(*linebot.LocationMessage)
 		reply.Coords = [2]float64{loc.Longitude, loc.Latitude}
+		app.rememberPin(lineUserID, reply.Coords)
 	case *linebot.StickerMessage:
 		sticker := event.Message.(*linebot.StickerMessage)
 		reply.Text = sticker.StickerID
@@ -181,26 +220,26 @@ func (app *HailingApp) extractReplyFromMessage(event *linebot.Event) error {
 // UnhandledCase return greeting and some initial suggestion to the service
 func (app *HailingApp) UnhandledCase(replyToken string) error {
 
-	if _, err := app.bot.ReplyMessage(
+	return app.messenger.Reply(
 		replyToken,
-		linebot.NewTextMessage("Hi there, do you need a ride?"),
-		linebot.NewTextMessage("Try \"status\" to get started").WithQuickReplies(
-			linebot.NewQuickReplyItems(
-				linebot.NewQuickReplyButton(
-					app.appBaseURL+"/static/quick/pin.png",
-					linebot.NewMessageAction("Help", "help"),
-				),
-			),
-		),
-	).Do(); err != nil {
-		return err
-	}
-	return nil
+		TextMessage{Text: "Hi there, do you need a ride?"},
+		TextMessage{
+			Text: "Try \"status\" to get started",
+			QuickReplies: []QuickReplyButton{
+				{
+					Image: app.appBaseURL + "/static/quick/pin.png",
+					Label: "Help",
+					Text:  "help",
+				},
+			},
+		},
+	)
 }
 
 func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, reply Reply) error {
 	var record *ReservationRecord
 	var err error
+	var changing bool // rider changes the confirmed trip
 	msgs := []string{"", ""}
 
 	if strings.Contains(reply.Text, "[LIFF]") {
@@ -225,22 +264,39 @@ func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, repl
 			},
 		})
 		log.Printf("[handleNextStep] location-option\n")
-		if _, err := app.bot.ReplyMessage(
+		if err := app.messenger.Reply(
 			replyToken,
-			app.LocationOptionFlex(user.Language, localizer),
-			linebot.NewTextMessage(askLocation).
-				WithQuickReplies(linebot.NewQuickReplyItems(
-					linebot.NewQuickReplyButton(
-						app.appBaseURL+"/static/quick/pin.png",
-						linebot.NewLocationAction("Send location")),
-				)),
-		).Do(); err != nil {
+			app.LocationOptionFlex(user, localizer),
+			TextMessage{
+				Text: askLocation,
+				QuickReplies: []QuickReplyButton{
+					{
+						Image: app.appBaseURL + "/static/quick/pin.png",
+						Label: "Send location",
+						Type:  ActionLocation,
+					},
+				},
+			},
+		); err != nil {
 			log.Printf("[handleNextStep] location-option err: %v", err)
 			return err
 		}
 		return nil
 	}
 
+	// free text may say more than the answer to the current question,
+	// e.g. "I need a taxi to citi resort now"
+	var utter Utterance
+	if reply.Coords == [2]float64{0, 0} && reply.Datetime.IsZero() && !strings.HasPrefix(reply.Text, "location:") {
+		utter = ParseUtterance(reply.Text, Vocabulary().Words())
+		if utter.Intent != IntentNone {
+			log.Printf("[handleNextStep] intent of %q: %v", reply.Text, utter)
+		}
+	}
+	if utter.Intent == IntentHelp {
+		return app.replyMessage(replyToken, app.HelpMessageFlex(localizer))
+	}
+
 	// change pickup time
 	if reply.Text == "modify-pickup-time" {
 		nothingChanged := localizer.MustLocalize(&i18n.LocalizeConfig{
@@ -251,23 +307,39 @@ func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, repl
 		})
 		// TODO: deal with this case -- which I am not sure how yet.
 		record, err = app.ProcessReservationStep(lineUserID, reply)
+		if choice := app.ChoiceFor(err, user.Language, localizer); choice != nil {
+			return app.replyMessage(replyToken, choice)
+		}
 		if err != nil {
 			// this supposes to ask the same question again.
 			// log.Printf("[handleNextStep] reply incorrectly: %v", err)
 			// TODO: since it's "done" state, we need to return Message here
-			if _, err := app.bot.ReplyMessage(
-				replyToken,
-				linebot.NewTextMessage(nothingChanged),
-				linebot.NewTextMessage(fmt.Sprintf("%v", err)),
-			).Do(); err != nil {
-				return err
-			}
-			return nil
+			return app.replyText(replyToken, nothingChanged, fmt.Sprintf("%v", err))
 		}
 	}
 
+	// go back to edit an answer on confirmation step
+	if strings.HasPrefix(reply.Text, "edit:") {
+		state := ReservationState(strings.TrimPrefix(reply.Text, "edit:"))
+		record, err = app.EditRecord(lineUserID, state)
+		if _, ok := err.(*TransitionError); ok {
+			log.Printf("[handleNextStep] edit %v: %v", state, err)
+			nothingToEdit := localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "NothingToEdit",
+					Other: "There is nothing to edit at this point",
+				},
+			})
+			return app.replyText(replyToken, nothingToEdit)
+		}
+		if err != nil {
+			return app.replyText(replyToken, fmt.Sprintf("%v", err))
+		}
+		return app.replyQuestion(replyToken, localizer, record)
+	}
+
 	// cancel process
-	if IsThisIn(reply.Text, WordsToCancel) {
+	if Vocabulary().Words().IsKeyword(reply.Text, KeywordCancel) || utter.Intent == IntentCancel {
 		return app.CancelHandler(replyToken, lineUserID)
 	}
 
@@ -302,8 +374,44 @@ func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, repl
 		},
 	})
 
+	// change pickup time in words, e.g. "change time to +30min"
+	if utter.Intent == IntentChangeTime {
+		record, err = app.FindRecord(lineUserID)
+		if err != nil {
+			return app.replyMessage(
+				replyToken,
+				TextMessage{Text: fmt.Sprintf("%v", err)},
+				ConfirmDialog(initLine, yes, "init"),
+			)
+		}
+		if record.Waiting == StatePickup {
+			if utter.When == "" {
+				// the card has the time picker
+				return app.replyMessage(replyToken, app.RecordConfirmFlex(record, confirm, localizer))
+			}
+			now := time.Now()
+			at, ok := ParseTime(utter.When, now)
+			if !ok {
+				return app.replyMessage(replyToken, app.RecordConfirmFlex(record, confirm, localizer))
+			}
+			if err := checkPickupTime(at, now, app.bookingHorizon); err != nil {
+				return app.replyText(replyToken, fmt.Sprintf("%v", err))
+			}
+			return app.replyMessage(replyToken, app.PickupTimeConfirmDialog(at, "when-change", localizer))
+		}
+		if utter.When == "" {
+			if record.Waiting == StateFinal {
+				record, err = app.EditRecord(lineUserID, StateWhen)
+				if err != nil {
+					return app.replyText(replyToken, fmt.Sprintf("%v", err))
+				}
+			}
+			return app.replyQuestion(replyToken, localizer, record)
+		}
+	}
+
 	// status process
-	if IsThisIn(reply.Text, WordsToAskForStatus) {
+	if Vocabulary().Words().IsKeyword(reply.Text, KeywordStatus) || utter.Intent == IntentStatus {
 		record, err = app.FindRecord(lineUserID)
 		if err != nil {
 			// log.Printf("[handleNextStep] err status: %v\n", err)
@@ -311,27 +419,18 @@ func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, repl
 			// (1) what is wrong
 			// (2) wanna start reservation record?
 			errMsg := fmt.Sprintf("%v", err)
-			if _, err2 := app.bot.ReplyMessage(
+			return app.replyMessage(
 				replyToken,
-				linebot.NewTextMessage(fmt.Sprintf("%v", errMsg)),
+				TextMessage{Text: errMsg},
 				ConfirmDialog(initLine, yes, "init"),
-			).Do(); err2 != nil {
-				return err2
-			}
-			return nil
+			)
 		}
 		// check if it's done or not
 		done, _ := record.IsComplete()
 		if done {
 			// log.Printf("[handleNextStep] status query: %s \n   >> record: %v", record.State, record)
 			// msg := fmt.Sprintf("Your reservation detail is here [%v]", record)
-			if _, err := app.bot.ReplyMessage(
-				replyToken,
-				record.RecordConfirmFlex(confirm, localizer),
-			).Do(); err != nil {
-				return err
-			}
-			return nil
+			return app.replyMessage(replyToken, app.RecordConfirmFlex(record, confirm, localizer))
 		}
 		// if it's not done, let this go through regular process
 		msgs[0] = rideIncompleted
@@ -339,59 +438,209 @@ func (app *HailingApp) handleNextStep(replyToken string, lineUserID string, repl
 	}
 
 	// initial state
-	if IsThisIn(reply.Text, WordsToInit) {
+	if Vocabulary().Words().IsKeyword(reply.Text, KeywordInit) {
 		// log.Printf("[handleNextStep] init (user: %v)\n", lineUserID)
 		record, err = app.FindOrCreateRecord(lineUserID)
 		if err != nil {
 			return err
 		}
 		// log.Printf("[handleNextStep] init:record => %v \n", record)
+		if record.IsConfirmed {
+			return app.replyMessage(
+				replyToken,
+				TextMessage{Text: OneTripAtATimeText(record, localizer)},
+				app.RecordConfirmFlex(record, confirm, localizer),
+			)
+		}
+	} else if utter.Intent == IntentBook || utter.Intent == IntentChangeTime {
+		// take whatever the message says, then ask for the rest
+		record, err = app.FillRecord(lineUserID, utter)
+		if choice := app.ChoiceFor(err, user.Language, localizer); choice != nil {
+			return app.replyMessage(replyToken, choice)
+		}
+		if record == nil {
+			return err
+		}
+		if record.IsConfirmed && utter.Intent == IntentBook {
+			return app.replyMessage(
+				replyToken,
+				TextMessage{Text: OneTripAtATimeText(record, localizer)},
+				app.RecordConfirmFlex(record, confirm, localizer),
+			)
+		}
+		if err != nil {
+			msgs[0] = ErrorText(err, localizer)
+		}
 	} else {
 		// if found --> Process
 		// NOT --> Ask wanna start?
 		record, err = app.FindRecord(lineUserID)
 		if err != nil {
-			if _, err2 := app.bot.ReplyMessage(
+			return app.replyMessage(
 				replyToken,
-				linebot.NewTextMessage(fmt.Sprintf("%v", err)),
+				TextMessage{Text: fmt.Sprintf("%v", err)},
 				ConfirmDialog(initLine, yes, "init"),
-			).Do(); err2 != nil {
-				return err2
-			}
-			return nil
+			)
 		}
+		changing = record.IsConfirmed && record.Waiting != StatePickup
 		record, err = app.ProcessReservationStep(lineUserID, reply)
+		if choice := app.ChoiceFor(err, user.Language, localizer); choice != nil {
+			return app.replyMessage(replyToken, choice)
+		}
 		if err != nil {
 			// this supposes to ask the same question again.
 			// log.Printf("[handleNextStep] reply incorrectly: %v", err)
 			// msgs[0] = fmt.Sprintf("Error, try again")
-			msgs[0] = fmt.Sprintf("%v", err)
+			msgs[0] = ErrorText(err, localizer)
+		} else if reply.Coords != [2]float64{0, 0} {
+			if zone := CurrentServiceArea().ZoneAt(reply.Coords); zone != nil && len(CurrentServiceArea().Zones) > 1 {
+				msgs[0] = localizer.MustLocalize(&i18n.LocalizeConfig{
+					DefaultMessage: &i18n.Message{
+						ID:    "InZone",
+						Other: "You're in {{.Zone}}.",
+					},
+					TemplateData: map[string]string{"Zone": zone.Name},
+				})
+			}
+			if point := app.SnapPin(reply.Coords); point != nil {
+				msgs[1] = localizer.MustLocalize(&i18n.LocalizeConfig{
+					DefaultMessage: &i18n.Message{
+						ID:    "MeetAtPickupPoint",
+						Other: "Meet your driver at {{.Name}}, {{.Distance}} walk from your pin.",
+					},
+					TemplateData: map[string]string{
+						"Name":     point.Name,
+						"Distance": DistanceText(point.Walk),
+					},
+				})
+			}
 		}
 	}
 
 	// log.Printf("[handleNextStep] %v\n   PrevReply = %v", record, reply)
-	if record.State == "done" {
+	if record.State == StateDone {
 		// this need special care
-		if _, err := app.bot.ReplyMessage(
-			replyToken,
-			linebot.NewTextMessage(rideCompleted),
-			record.RecordConfirmFlex(confirm, localizer),
-		).Do(); err != nil {
-			return err
+		doneText := rideCompleted
+		if changing && err != nil {
+			doneText = msgs[0]
+		} else if changing {
+			doneText = localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "TripUpdated",
+					Other: "Your trip has been updated.",
+				},
+			})
 		}
-		return nil
+		return app.replyMessage(
+			replyToken,
+			TextMessage{Text: doneText},
+			app.RecordConfirmFlex(record, confirm, localizer),
+		)
 	}
 	return app.replyQuestion(replyToken, localizer, record, msgs...)
 }
 
+// ChoiceFor returns what to ask when err means the rider has to pick or
+// confirm an answer, or nil
+func (app *HailingApp) ChoiceFor(err error, lang string, localizer *i18n.Localizer) Message {
+	var ambiguous *AmbiguousPlaceError
+	if errors.As(err, &ambiguous) {
+		return app.PlacePickerFlex(ambiguous.Candidates, lang, localizer)
+	}
+	var pickupTime *TimeConfirmationError
+	if errors.As(err, &pickupTime) {
+		return app.PickupTimeConfirmDialog(pickupTime.At, "when", localizer)
+	}
+	return nil
+}
+
+// ErrorText explains err to the rider in their language
+func ErrorText(err error, localizer *i18n.Localizer) string {
+	var outside *OutsideServiceAreaError
+	if errors.As(err, &outside) && outside.Nearest != "" {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "OutsideServiceAreaNear",
+				Other: "Sorry, that's outside our service area, {{.Distance}} away from {{.Zone}}.",
+			},
+			TemplateData: map[string]string{
+				"Distance": DistanceText(outside.Distance),
+				"Zone":     outside.Nearest,
+			},
+		})
+	}
+	if outside != nil {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "OutsideServiceArea",
+				Other: "Sorry, that's outside our service area.",
+			},
+		})
+	}
+	var closed *ZoneClosedError
+	if errors.As(err, &closed) {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "ZoneClosed",
+				Other: "{{.Zone}} is only served {{.Hours}}.",
+			},
+			TemplateData: map[string]string{
+				"Zone":  closed.Zone,
+				"Hours": closed.Hours,
+			},
+		})
+	}
+	var passengers *PassengersError
+	if errors.As(err, &passengers) {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "PassengersOutOfRange",
+				Other: "Please tell me a number of passengers from 1 to {{.Max}}.",
+			},
+			TemplateData: map[string]int{"Max": passengers.Max},
+		})
+	}
+	var trip *TripError
+	if errors.As(err, &trip) {
+		switch trip.Reason {
+		case TripTooClose:
+			return localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "TripTooClose",
+					Other: "Pickup is only {{.Distance}} from your destination. Where should we pick you up?",
+				},
+				TemplateData: map[string]string{"Distance": DistanceText(trip.Meters)},
+			})
+		case TripTooLong:
+			return localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "TripTooLong",
+					Other: "Sorry, the route is {{.Distance}}; we only go up to {{.Limit}}.",
+				},
+				TemplateData: map[string]string{
+					"Distance": DistanceText(trip.Meters),
+					"Limit":    DistanceText(trip.Limit),
+				},
+			})
+		}
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "RouteOutsideServiceArea",
+				Other: "Sorry, the way there leaves our service area.",
+			},
+		})
+	}
+	return err.Error()
+}
+
 func (app *HailingApp) replyQuestion(replyToken string, localizer *i18n.Localizer, record *ReservationRecord, msgs ...string) error {
 	// question := record.QuestionToAsk(localizer)
 	question := app.QuestionToAsk(record, localizer)
 	if question.YesInput {
-		return app.replyFinalStep(replyToken, localizer, record)
+		return app.replyFinalStep(replyToken, localizer, record, msgs...)
 	}
-	if question.Text == "When?" {
-		return app.replyTravelTimeOptionsAndWhen(replyToken, record, question)
+	if record.Waiting == StateWhen {
+		return app.replyTravelTimeOptionsAndWhen(replyToken, record, question, msgs...)
 	}
 	// regular question flow
 	if err := app.replyBack(replyToken, question, msgs...); err != nil {
@@ -400,152 +649,106 @@ func (app *HailingApp) replyQuestion(replyToken string, localizer *i18n.Localize
 	return nil
 }
 
-func (app *HailingApp) replyTravelTimeOptionsAndWhen(replyToken string, record *ReservationRecord, question Question) error {
-	items := make([]*linebot.QuickReplyButton, len(question.Buttons))
-	ind := 0
+func (app *HailingApp) replyTravelTimeOptionsAndWhen(replyToken string, record *ReservationRecord, question Question, messages ...string) error {
+	items := make([]QuickReplyButton, len(question.Buttons))
 	for i := 0; i < len(question.Buttons); i++ {
 		btn := question.Buttons[i]
-		qrBtn := linebot.NewQuickReplyButton(
-			app.appBaseURL+"/static/quick/pin.png",
-			linebot.NewMessageAction(btn.Label, btn.Text),
-		)
-		items[i] = qrBtn
-		ind++
+		btn.Image = app.appBaseURL + "/static/quick/pin.png"
+		items[i] = btn
 	}
-	replyItems := linebot.NewQuickReplyItems()
-	replyItems.Items = items
 
-	sendingMsgs := []linebot.SendingMessage{}
+	sendingMsgs := nonEmptyTextMessages(messages)
 	// don't give anything since for duration w/traffic requires time obviously
 	// sendingMsgs = append(sendingMsgs, app.EstimatedTravelTimeFlex(record))
 	// ask question
-	sendingMsgs = append(sendingMsgs, linebot.NewTextMessage(question.Text).WithQuickReplies(replyItems))
+	sendingMsgs = append(sendingMsgs, TextMessage{Text: question.Text, QuickReplies: items})
 
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		sendingMsgs...,
-	).Do(); err != nil {
-		return err
-	}
-	return nil
+	return app.messenger.Reply(replyToken, sendingMsgs...)
 }
 
-func (app *HailingApp) replyFinalStep(replyToken string, localizer *i18n.Localizer, record *ReservationRecord) error {
+func (app *HailingApp) replyFinalStep(replyToken string, localizer *i18n.Localizer, record *ReservationRecord, messages ...string) error {
 	confirmText := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "Confirm",
 			Other: "Confirm",
 		},
 	})
-	btnAction := linebot.NewPostbackAction(confirmText, "confirm", "", "")
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		// linebot.NewTextMessage(optionTxt),
-		app.EstimatedTravelTimeFlex(record, btnAction, localizer),
-	).Do(); err != nil {
-		return err
+	btnAction := CardButton{
+		Label:  confirmText,
+		Action: ActionPostback,
+		Data:   "confirm",
+		Style:  ButtonPrimary,
+		Color:  "#679AF0",
 	}
-	return nil
+	replies := append(nonEmptyTextMessages(messages), app.EstimatedTravelTimeFlex(record, btnAction, localizer))
+	if options := app.TravelOptionsCarousel(record, localizer); options != nil {
+		replies = append(replies, options)
+	}
+	return app.messenger.Reply(replyToken, replies...)
 }
 
 func (app *HailingApp) replyBack(replyToken string, question Question, messages ...string) error {
 
-	replyItems := linebot.NewQuickReplyItems()
-	itemTotal := len(question.Buttons)
-	if question.LocationInput {
-		// disable send location
-		// itemTotal = itemTotal + 2
-		// 1. send location, 2. more options
-		itemTotal++
-	}
-	if question.DatetimeInput {
-		itemTotal++
-	}
-	items := make([]*linebot.QuickReplyButton, itemTotal)
-	ind := 0
+	items := []QuickReplyButton{}
 	for i := 0; i < len(question.Buttons); i++ {
 		btn := question.Buttons[i]
-		var qrBtn *linebot.QuickReplyButton
-		if btn.Type == "postback" {
-			qrBtn = linebot.NewQuickReplyButton(
-				app.appBaseURL+"/static/quick/pin.png",
-				linebot.NewPostbackAction(btn.Label, btn.Data, "", btn.Label),
-			)
-		} else {
-			qrBtn = linebot.NewQuickReplyButton(
-				app.appBaseURL+"/static/quick/pin.png",
-				linebot.NewMessageAction(btn.Label, btn.Text),
-			)
+		btn.Image = app.appBaseURL + "/static/quick/pin.png"
+		if btn.Type == ActionPostback {
+			btn.Text = btn.Label
 		}
-		items[i] = qrBtn
-		ind++
+		items = append(items, btn)
 	}
 	if question.LocationInput == true {
 		// pickup from map
-		// items[ind] = linebot.NewQuickReplyButton(
-		// 	app.appBaseURL+"/static/quick/pin.png",
-		// 	linebot.NewLocationAction("Send location"),
-		// )
-		// ind++
+		// items = append(items, QuickReplyButton{
+		// 	Image: app.appBaseURL + "/static/quick/pin.png",
+		// 	Label: "Send location",
+		// 	Type:  ActionLocation,
+		// })
 		// more options
-		items[ind] = linebot.NewQuickReplyButton(
-			"",
-			linebot.NewPostbackAction("More options", "location-options", "", ""),
-		)
-		ind++
+		items = append(items, QuickReplyButton{
+			Label: "More options",
+			Type:  ActionPostback,
+			Data:  "location-options",
+		})
 	}
 
 	if question.DatetimeInput == true {
-		items[ind] = linebot.NewQuickReplyButton(
-			"",
-			linebot.NewDatetimePickerAction("Pick date & time", "DATETIME", "datetime", "", "", ""))
-	}
-	replyItems.Items = items
-	sendingMsgs := []linebot.SendingMessage{}
-	for i := 0; i < len(messages); i++ {
-		if messages[i] != "" {
-			sendingMsgs = append(sendingMsgs, linebot.NewTextMessage(messages[i]))
-		}
+		items = append(items, QuickReplyButton{
+			Label: "Pick date & time",
+			Type:  ActionDatetime,
+			Data:  "DATETIME",
+		})
 	}
+	sendingMsgs := nonEmptyTextMessages(messages)
 	// ask question
-	sendingMsgs = append(sendingMsgs, linebot.NewTextMessage(question.Text).WithQuickReplies(replyItems))
+	sendingMsgs = append(sendingMsgs, TextMessage{Text: question.Text, QuickReplies: items})
 
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		sendingMsgs...,
-	).Do(); err != nil {
-		return err
+	return app.messenger.Reply(replyToken, sendingMsgs...)
+}
+
+// nonEmptyTextMessages returns text messages of texts which aren't empty
+func nonEmptyTextMessages(texts []string) []Message {
+	msgs := []Message{}
+	for _, text := range texts {
+		if text != "" {
+			msgs = append(msgs, TextMessage{Text: text})
+		}
 	}
-	return nil
+	return msgs
 }
 
 func (app *HailingApp) replyText(replyToken string, text ...string) error {
-	msgs := make([]linebot.SendingMessage, len(text))
-	for i := 0; i < len(text); i++ {
-		msgs[i] = linebot.NewTextMessage(text[i])
-	}
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		msgs...,
-	).Do(); err != nil {
-		return err
-	}
-	return nil
+	return app.messenger.Reply(replyToken, NewTextMessages(text...)...)
 }
 
-func (app *HailingApp) replyMessage(replyToken string, messages ...linebot.SendingMessage) error {
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		messages...,
-	).Do(); err != nil {
-		return err
-	}
-	return nil
+func (app *HailingApp) replyMessage(replyToken string, messages ...Message) error {
+	return app.messenger.Reply(replyToken, messages...)
 }
 
 // LocationOptionFlex to send location options
-func (app *HailingApp) LocationOptionFlex(lang string, localizer *i18n.Localizer) linebot.SendingMessage {
-	locs, err := app.GetLocations(lang, 10)
+func (app *HailingApp) LocationOptionFlex(user *User, localizer *i18n.Localizer) Message {
+	locs, err := app.GetLocations(user.Language, 10)
 	if err != nil {
 		log.Printf("[LocationOptionFlex] db failed: %v\n", err)
 		return nil
@@ -564,107 +767,54 @@ func (app *HailingApp) LocationOptionFlex(lang string, localizer *i18n.Localizer
 		},
 	})
 
-	items := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   picker,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeMd,
-		}}
+	items := []CardBlock{}
+	// rider's own places come first
+	for _, place := range app.SavedPlaces(user.ID) {
+		items = append(items, ButtonBlock(PostbackButton(
+			"📍 "+place.Name,
+			fmt.Sprintf("place:%d", place.ID),
+		)))
+	}
 	for _, location := range locs {
-		items = append(items, &linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				location.Name,
-				fmt.Sprintf("location:%s:%d", location.Name, location.ID),
-				"", ""),
-		})
+		items = append(items, ButtonBlock(PostbackButton(
+			location.Name,
+			fmt.Sprintf("location:%s:%d", location.Name, location.ID),
+		)))
 	}
 
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: items,
-		},
+	return RichCard{
+		AltText: altText,
+		Title:   picker,
+		Body:    items,
 	}
-	return linebot.NewFlexMessage(altText, contents)
 }
 
-// ConfirmDialog returns sendingMessage to ask if user want to start the process
-func ConfirmDialog(message string, postbackLabel string, postbackData string) linebot.SendingMessage {
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   message,
-					Weight: linebot.FlexTextWeightTypeBold,
-					Size:   linebot.FlexTextSizeTypeXl,
-				},
-			},
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.BoxComponent{
-					Type:   linebot.FlexComponentTypeBox,
-					Layout: linebot.FlexBoxLayoutTypeVertical,
-					Contents: []linebot.FlexComponent{
-						&linebot.ButtonComponent{
-							Height: linebot.FlexButtonHeightTypeMd,
-							Style:  linebot.FlexButtonStyleTypePrimary,
-							Action: linebot.NewPostbackAction(postbackLabel, postbackData, "", ""),
-						},
-					},
-				},
-			},
+// ConfirmDialog returns a message to ask if user want to start the process
+func ConfirmDialog(message string, postbackLabel string, postbackData string) Message {
+	return ConfirmCard{
+		AltText: "Start reservation",
+		Text:    message,
+		Button: CardButton{
+			Label:  postbackLabel,
+			Action: ActionPostback,
+			Data:   postbackData,
+			Style:  ButtonPrimary,
 		},
 	}
-	return linebot.NewFlexMessage("Start reservation", contents)
 }
 
-func (app *HailingApp) travelOption(icon string, distance string, duration string) *linebot.BoxComponent {
-	flex0 := 0
-	primaryColor := "#000000"
-	secondaryColor := "#AAAAAA"
+func (app *HailingApp) travelOption(icon string, distance string, duration string) CardBlock {
 	log.Printf("icon --> %s/static/%s-100x100.png", app.appBaseURL, icon)
-	return &linebot.BoxComponent{
-		Layout: linebot.FlexBoxLayoutTypeBaseline,
-		Contents: []linebot.FlexComponent{
-			&linebot.IconComponent{
-				URL:  fmt.Sprintf("%s/static/%s-100x100.png", app.appBaseURL, icon),
-				Size: linebot.FlexIconSizeType3xl,
-			},
-			&linebot.TextComponent{
-				Type:    linebot.FlexComponentTypeText,
-				Text:    distance,
-				Flex:    &flex0,
-				Margin:  linebot.FlexComponentMarginTypeSm,
-				Weight:  linebot.FlexTextWeightTypeRegular,
-				Color:   secondaryColor,
-				Wrap:    true,
-				Gravity: linebot.FlexComponentGravityTypeCenter,
-			},
-			&linebot.TextComponent{
-				Type:  linebot.FlexComponentTypeText,
-				Text:  duration,
-				Size:  linebot.FlexTextSizeTypeXl,
-				Align: linebot.FlexComponentAlignTypeEnd,
-				Color: primaryColor,
-			},
-		},
+	return CardBlock{
+		Kind:  BlockOption,
+		Icon:  fmt.Sprintf("%s/static/%s-100x100.png", app.appBaseURL, icon),
+		Label: distance,
+		Text:  duration,
 	}
 }
 
 // StarFeedbackFlex lets user rating the service
-func (app *HailingApp) StarFeedbackFlex(tripID int, localizer *i18n.Localizer) linebot.SendingMessage {
+func (app *HailingApp) StarFeedbackFlex(tripID int, localizer *i18n.Localizer) Message {
 	trip, err := app.GetTripRecordByID(tripID)
 	if err != nil {
 		return nil
@@ -701,8 +851,6 @@ func (app *HailingApp) StarFeedbackFlex(tripID int, localizer *i18n.Localizer) l
 		},
 	})
 
-	flexLabel := 3
-	flexDesc := 7
 	duration := trip.DroppedOffAt.Sub(*trip.PickedUpAt)
 
 	travelMin := localizer.MustLocalize(&i18n.LocalizeConfig{
@@ -715,153 +863,31 @@ func (app *HailingApp) StarFeedbackFlex(tripID int, localizer *i18n.Localizer) l
 		},
 	})
 
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   title,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeLg,
-		},
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   question,
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeMd,
-		},
-
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   pickup,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   trip.From,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-					Wrap:   true,
-				},
-			},
-		},
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   to,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-					Wrap:   true,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   trip.To,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-			},
-		},
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   durationLabel,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   travelMin,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-			},
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				"⭐️",
-				fmt.Sprintf("star-feedback:%d:1", tripID), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				"⭐️⭐️",
-				fmt.Sprintf("star-feedback:%d:2", tripID), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				"⭐️⭐️⭐️",
-				fmt.Sprintf("star-feedback:%d:3", tripID), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				"⭐️⭐️⭐️⭐️",
-				fmt.Sprintf("star-feedback:%d:4", tripID), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeSm,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				"⭐️⭐️⭐️⭐️⭐️",
-				fmt.Sprintf("star-feedback:%d:5", tripID), "", ""),
-		},
+	elements := []CardBlock{
+		TextBlock(question),
+		FieldBlock(pickup, trip.From),
+		FieldBlock(to, trip.To),
+		FieldBlock(durationLabel, travelMin),
 	}
-
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.SpacerComponent{
-					Type: linebot.FlexComponentTypeSeparator,
-					Size: linebot.FlexSpacerSizeTypeSm,
-				},
-			},
-		},
+	stars := ""
+	for i := 1; i <= 5; i++ {
+		stars = stars + "⭐️"
+		elements = append(elements, ButtonBlock(PostbackButton(
+			stars,
+			fmt.Sprintf("star-feedback:%d:%d", tripID, i),
+		)))
 	}
 
-	return linebot.NewFlexMessage("StarFeedback", contents)
+	return RichCard{
+		AltText: "StarFeedback",
+		Title:   title,
+		Body:    elements,
+	}
 }
 
 // EstimatedTravelTimeFlex shows alternative travel time, but continue asking
-// 		if customer want to use the service, when?
-func (app *HailingApp) EstimatedTravelTimeFlex(record *ReservationRecord, btnAction linebot.TemplateAction, localizer *i18n.Localizer) linebot.SendingMessage {
-	// secondaryColor := "#AAAAAA"
-
+// if customer want to use the service, when?
+func (app *HailingApp) EstimatedTravelTimeFlex(record *ReservationRecord, btnAction CardButton, localizer *i18n.Localizer) Message {
 	confirm := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "ReservationConfirmation",
@@ -869,16 +895,7 @@ func (app *HailingApp) EstimatedTravelTimeFlex(record *ReservationRecord, btnAct
 		},
 	})
 
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   confirm,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeXl,
-			Wrap:   true,
-		},
-	}
-	elements = append(elements, RecordInformationFlexArray(record, localizer)...)
+	elements := RecordInformationFlexArray(record, localizer, StateFrom, StateNumOfPassengers, StateTo, StateWhen)
 	estTimeElements, err := app.TravelTimeFlexArray(record, localizer)
 	if err == nil {
 		elements = append(elements, estTimeElements...)
@@ -888,13 +905,7 @@ func (app *HailingApp) EstimatedTravelTimeFlex(record *ReservationRecord, btnAct
 
 	// question
 	// question := "If you'd like to continue with our services, when do you want us to pick you up?"
-	// elements = append(elements, &linebot.TextComponent{
-	// 	Type:  linebot.FlexComponentTypeText,
-	// 	Text:  question,
-	// 	Size:  linebot.FlexTextSizeTypeMd,
-	// 	Wrap:  true,
-	// 	Color: secondaryColor,
-	// })
+	// elements = append(elements, TextBlock(question))
 
 	walkInstead := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
@@ -903,114 +914,50 @@ func (app *HailingApp) EstimatedTravelTimeFlex(record *ReservationRecord, btnAct
 		},
 	})
 
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.SpacerComponent{
-					Type: linebot.FlexComponentTypeSeparator,
-					Size: linebot.FlexSpacerSizeTypeSm,
-				},
-				&linebot.ButtonComponent{
-					Height: linebot.FlexButtonHeightTypeMd,
-					Style:  linebot.FlexButtonStyleTypePrimary,
-					Color:  "#679AF0",
-					Action: btnAction,
-				},
-				&linebot.ButtonComponent{
-					Height: linebot.FlexButtonHeightTypeSm,
-					Style:  linebot.FlexButtonStyleTypeLink,
-					Action: linebot.NewPostbackAction(
-						walkInstead,
-						"cancel", "cancel", ""),
-				},
-			},
+	return RichCard{
+		AltText: "EstTravelTime",
+		Title:   confirm,
+		Body:    elements,
+		Buttons: []CardButton{
+			btnAction,
+			PostbackButton(walkInstead, "travel:walk"),
 		},
 	}
-
-	return linebot.NewFlexMessage("EstTravelTime", contents)
 }
 
-// TravelTimeFlexArray returns estimated travel time in flex component array
-func (app *HailingApp) TravelTimeFlexArray(record *ReservationRecord, localizer *i18n.Localizer) ([]linebot.FlexComponent, error) {
+// TravelTimeFlexArray returns estimated travel time in card blocks
+func (app *HailingApp) TravelTimeFlexArray(record *ReservationRecord, localizer *i18n.Localizer) ([]CardBlock, error) {
 	title := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "EstTravelTime",
 			Other: "Estimated travel time",
 		},
 	})
-	// NOTE: alternative options..
-	walkRoute, err := GetTravelTime("walk", *record)
+	// other ways to go are on TravelOptionsCarousel
+	carSource := "google"
+	carRoute, err := app.CarRoute(*record)
 	if err != nil {
 		msg := fmt.Sprintf("err: %v", err)
 		return nil, errors.New(msg)
 	}
-	carSource := "google"
-	carRoute, err := GetGoogleTravelTime(*record)
-	if err != nil {
-		// Still need to report Error
-		log.Printf("[TravelTimeFlexArray] Err: %v", err)
-		// Try our own GetTravelTime if we have a problem with Google
-		carRoute, err = GetTravelTime("car", *record)
+	if carRoute.Source == "osrm" {
 		carSource = "osrm"
-		if err != nil {
-			msg := fmt.Sprintf("err: %v", err)
-			return nil, errors.New(msg)
-		}
-		// msg := fmt.Sprintf("err: %v", err)
-		// return nil, errors.New(msg)
 	}
 
-	// save polyline from Google's travel time to record
-	record.Polyline = carRoute.Geometry
-	err = app.SaveRecordToRedis(record)
+	// save polyline from Google's travel time & the fare to record
+	app.applyRoute(record, carRoute)
+	err = app.SaveRecord(record)
 	if err != nil {
 		msg := fmt.Sprintf("err: %v", err)
 		return nil, errors.New(msg)
 	}
 
-	log.Printf("[EstTravelTimeFlex] Walk: %v", walkRoute)
 	log.Printf("[EstTravelTimeFlex] Car: %v", carRoute)
 
-	elements := []linebot.FlexComponent{}
+	elements := []CardBlock{}
 	// title
-	elements = append(elements, &linebot.TextComponent{
-		Type:   linebot.FlexComponentTypeText,
-		Text:   title,
-		Weight: linebot.FlexTextWeightTypeRegular,
-		Size:   linebot.FlexTextSizeTypeLg,
-		Margin: linebot.FlexComponentMarginTypeMd,
-	})
+	elements = append(elements, CardBlock{Kind: BlockHeading, Text: title})
 
-	// travel options :: walk
-	if walkRoute.Duration > 0 {
-		travelLength := localizer.MustLocalize(&i18n.LocalizeConfig{
-			DefaultMessage: &i18n.Message{
-				ID:    "TravelMeter",
-				Other: "{{.Meter}} m",
-			},
-			TemplateData: map[string]string{
-				"Meter": fmt.Sprintf("%.0f", walkRoute.Distance),
-			},
-		})
-		travelMin := localizer.MustLocalize(&i18n.LocalizeConfig{
-			DefaultMessage: &i18n.Message{
-				ID:    "TravelMinute",
-				Other: "{{.Min}} min",
-			},
-			TemplateData: map[string]string{
-				"Min": fmt.Sprintf("%.0f", walkRoute.Duration/60),
-			},
-		})
-		elements = append(elements, app.travelOption("walk", travelLength, travelMin))
-	}
 	// travel options :: car
 	if carRoute.Duration > 0 && carSource == "google" {
 		travelLength := localizer.MustLocalize(&i18n.LocalizeConfig{
@@ -1065,15 +1012,48 @@ func (app *HailingApp) TravelTimeFlexArray(record *ReservationRecord, localizer
 		})
 		elements = append(elements, app.travelOption("taxi", travelLength, travelMin))
 	}
+	if record.Fare > 0 {
+		elements = append(elements, FareFieldBlock(record.Fare, localizer))
+	}
 	return elements, nil
 }
 
-// RecordInformationFlexArray returns array of record information
-func RecordInformationFlexArray(record *ReservationRecord, localizer *i18n.Localizer) []linebot.FlexComponent {
-	flexLabel := 2
-	flexDesc := 8
-	bkk, _ := time.LoadLocation("Asia/Bangkok")
-	bkkReservedTime := record.ReservedAt.In(bkk)
+// FareFieldBlock shows the estimated fare in the currency of CurrentFares
+func FareFieldBlock(fare float64, localizer *i18n.Localizer) CardBlock {
+	label := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "EstFare",
+			Other: "Estimated fare",
+		},
+	})
+	return FieldBlock(label, FareText(fare, CurrentFares().Currency))
+}
+
+// OneTripAtATimeText tells the rider who asks for another ride that the
+// trip of record has to be cancelled first
+func OneTripAtATimeText(record *ReservationRecord, localizer *i18n.Localizer) string {
+	return localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "OneTripAtATime",
+			Other: "You already have a ride at {{.Time}}. Please cancel it before booking another one.",
+		},
+		TemplateData: map[string]string{"Time": PickupTimeText(record.ReservedAt)},
+	})
+}
+
+// PickupTimeText shows time in Bangkok, with date if it's not today
+func PickupTimeText(t time.Time) string {
+	local := t.In(bangkok)
+	if local.Format("2006-01-02") == time.Now().In(bangkok).Format("2006-01-02") {
+		return local.Format(time.Kitchen)
+	}
+	return local.Format("Mon 2 Jan " + time.Kitchen)
+}
+
+// RecordInformationFlexArray returns array of record information.
+// Fields of editable states get an "edit" postback and passengers get their own line.
+func RecordInformationFlexArray(record *ReservationRecord, localizer *i18n.Localizer, editable ...ReservationState) []CardBlock {
+	pickupTime := PickupTimeText(record.ReservedAt)
 
 	pickup := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
@@ -1094,84 +1074,47 @@ func RecordInformationFlexArray(record *ReservationRecord, localizer *i18n.Local
 		},
 	})
 
-	flexComponents := []linebot.FlexComponent{
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   pickup,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   fmt.Sprintf("%v (%d 👤)", record.From, record.NumOfPassengers),
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-					Wrap:   true,
-				},
-			},
-		},
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   to,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   record.To,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-					Wrap:   true,
-				},
-			},
+	if len(editable) == 0 {
+		return []CardBlock{
+			FieldBlock(pickup, fmt.Sprintf("%v (%d 👤)", record.From, record.NumOfPassengers)),
+			FieldBlock(to, record.To),
+			FieldBlock(timeLabel, pickupTime),
+		}
+	}
+
+	passengers := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Passengers",
+			Other: "Passengers",
 		},
-		&linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeBaseline,
-			Spacing: linebot.FlexComponentSpacingTypeXs,
-			Margin:  linebot.FlexComponentMarginTypeXl,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   timeLabel,
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexLabel,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-				&linebot.TextComponent{
-					Type:   linebot.FlexComponentTypeText,
-					Text:   bkkReservedTime.Format(time.Kitchen),
-					Weight: linebot.FlexTextWeightTypeRegular,
-					Flex:   &flexDesc,
-					Size:   linebot.FlexTextSizeTypeSm,
-				},
-			},
+	})
+	edit := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Edit",
+			Other: "Edit",
 		},
+	})
+	field := func(state ReservationState, label string, value string) CardBlock {
+		for _, s := range editable {
+			if s == state {
+				btn := PostbackButton(edit, "edit:"+string(state))
+				btn.Text = edit
+				return EditableFieldBlock(label, value, btn)
+			}
+		}
+		return FieldBlock(label, value)
+	}
+	return []CardBlock{
+		field(StateFrom, pickup, record.From),
+		field(StateNumOfPassengers, passengers, fmt.Sprintf("%d 👤", record.NumOfPassengers)),
+		field(StateTo, to, record.To),
+		field(StateWhen, timeLabel, pickupTime),
 	}
-	return flexComponents
 }
 
-// RecordConfirmFlex to return information in form of FLEX
-func (record *ReservationRecord) RecordConfirmFlex(title string, localizer *i18n.Localizer, customButtons ...linebot.ButtonComponent) linebot.SendingMessage {
-	flexBtnLeft := 3
-	flexBtnRight := 6
-
+// RecordConfirmFlex to return information in form of a card, with the
+// route map on top
+func (app *HailingApp) RecordConfirmFlex(record *ReservationRecord, title string, localizer *i18n.Localizer, customButtons ...CardButton) Message {
 	pickupTimeChange := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "ChangePickupTime",
@@ -1185,68 +1128,69 @@ func (record *ReservationRecord) RecordConfirmFlex(title string, localizer *i18n
 		},
 	})
 
-	var successButton linebot.ButtonComponent
+	// places & passengers can be changed until a driver is assigned,
+	// pickup time has its own button
+	body := RecordInformationFlexArray(record, localizer, StateFrom, StateNumOfPassengers, StateTo)
+	if record.TravelTime > 0 {
+		estTravelTime := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "EstTravelTime",
+				Other: "Estimated travel time",
+			},
+		})
+		travelMin := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "TravelMinute",
+				Other: "{{.Min}} min",
+			},
+			TemplateData: map[string]string{
+				"Min": fmt.Sprintf("%.0f", record.TravelTime/60),
+			},
+		})
+		body = append(body, FieldBlock(estTravelTime, travelMin))
+	}
+	if record.Fare > 0 {
+		body = append(body, FareFieldBlock(record.Fare, localizer))
+	}
+	if record.TripID != -1 {
+		repeat := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "RepeatEveryWeekday",
+				Other: "Repeat every weekday",
+			},
+		})
+		body = append(body, ButtonBlock(PostbackButton(repeat, "/repeat:weekdays")))
+	}
+
+	var successButton CardButton
 	if len(customButtons) == 0 {
-		successButton = linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeSecondary,
-			Flex:   &flexBtnRight,
-			Action: linebot.NewDatetimePickerAction(
-				pickupTimeChange, "datetime-change", "datetime",
-				"", "", ""),
+		successButton = CardButton{
+			Label:  pickupTimeChange,
+			Action: ActionDatetime,
+			Data:   "datetime-change",
+			Style:  ButtonSecondary,
 		}
 	} else {
 		successButton = customButtons[0]
 	}
 
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   title,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeXl,
-		},
-	}
-	elements = append(elements, RecordInformationFlexArray(record, localizer)...)
-
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				// &linebot.ButtonComponent{
-				// 	Height: linebot.FlexButtonHeightTypeMd,
-				// 	Style:  linebot.FlexButtonStyleTypeLink,
-				// 	Action: linebot.NewPostbackAction("Call driver", "call", "", ""),
-				// },
-				&linebot.BoxComponent{
-					Type:   linebot.FlexComponentTypeBox,
-					Layout: linebot.FlexBoxLayoutTypeHorizontal,
-					Contents: []linebot.FlexComponent{
-						&linebot.ButtonComponent{
-							Height: linebot.FlexButtonHeightTypeMd,
-							Style:  linebot.FlexButtonStyleTypeLink,
-							Flex:   &flexBtnLeft,
-							Action: linebot.NewPostbackAction(cancel, "cancel", "", ""),
-						},
-						&successButton,
-					},
-				},
-			},
-		},
+	return RichCard{
+		AltText: "Record confirmation",
+		Hero:    app.RouteMapURL(record),
+		Title:   title,
+		Body:    body,
+		Buttons: []CardButton{
+			// PostbackButton("Call driver", "call"),
+			PostbackButton(cancel, "cancel"),
+			successButton,
+		},
+		InlineButtons: true,
 	}
-	return linebot.NewFlexMessage("Record confirmation", contents)
 }
 
 // PushNotification handle pushing messages to our user
-func (app *HailingApp) PushNotification(lineUserID string, messages ...linebot.SendingMessage) error {
-	_, err := app.bot.PushMessage(lineUserID, messages...).Do()
+func (app *HailingApp) PushNotification(lineUserID string, messages ...Message) error {
+	err := app.messenger.Push(lineUserID, messages...)
 	if err != nil {
 		// Do something when some bad happened
 		log.Printf("[PushNoti] %v", lineUserID)
@@ -1254,8 +1198,8 @@ func (app *HailingApp) PushNotification(lineUserID string, messages ...linebot.S
 	return nil
 }
 
-// LanguageOptionFlex push Flex message for language options
-func (app *HailingApp) LanguageOptionFlex(localizer *i18n.Localizer) linebot.SendingMessage {
+// LanguageOptionFlex returns a card for language options
+func (app *HailingApp) LanguageOptionFlex(localizer *i18n.Localizer) Message {
 	title := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "LanguagePickerTitle",
@@ -1287,72 +1231,26 @@ func (app *HailingApp) LanguageOptionFlex(localizer *i18n.Localizer) linebot.Sen
 		},
 	})
 
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   title,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeLg,
-		},
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   question,
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeMd,
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				en,
-				fmt.Sprintf("/set:language:en"), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				ja,
-				fmt.Sprintf("/set:language:ja"), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				th,
-				fmt.Sprintf("/set:language:th"), "", ""),
-		},
-	}
-
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.SpacerComponent{
-					Type: linebot.FlexComponentTypeSeparator,
-					Size: linebot.FlexSpacerSizeTypeSm,
-				},
-			},
-		},
-	}
 	altText := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "Confirmation",
 			Other: "Language",
 		},
 	})
-	return linebot.NewFlexMessage(altText, contents)
+	return RichCard{
+		AltText: altText,
+		Title:   title,
+		Body: []CardBlock{
+			TextBlock(question),
+			ButtonBlock(PostbackButton(en, "/set:language:en")),
+			ButtonBlock(PostbackButton(ja, "/set:language:ja")),
+			ButtonBlock(PostbackButton(th, "/set:language:th")),
+		},
+	}
 }
 
-// CancellationFeedback push Flex message for cancellation feedback
-func (app *HailingApp) CancellationFeedback(localizer *i18n.Localizer, tripID int) linebot.SendingMessage {
+// CancellationFeedback returns a card for cancellation feedback
+func (app *HailingApp) CancellationFeedback(localizer *i18n.Localizer, tripID int) Message {
 	title := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "CancellationQuestionTitle",
@@ -1386,73 +1284,26 @@ func (app *HailingApp) CancellationFeedback(localizer *i18n.Localizer, tripID in
 
 	postbackFormat := "/set:cancel-reason:%d:%s"
 
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   title,
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeMd,
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				ans1,
-				fmt.Sprintf(postbackFormat, tripID, "wait-too-long"), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				ans2,
-				fmt.Sprintf(postbackFormat, tripID, "no-longer-need"), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				ans3,
-				fmt.Sprintf(postbackFormat, tripID, "take-another-mod"), "", ""),
-		},
-		&linebot.ButtonComponent{
-			Height: linebot.FlexButtonHeightTypeMd,
-			Style:  linebot.FlexButtonStyleTypeLink,
-			Action: linebot.NewPostbackAction(
-				ans4,
-				fmt.Sprintf(postbackFormat, tripID, "walk"), "", ""),
-		},
-	}
-
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.SpacerComponent{
-					Type: linebot.FlexComponentTypeSeparator,
-					Size: linebot.FlexSpacerSizeTypeSm,
-				},
-			},
-		},
-	}
 	altText := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "ReservationCancelled",
 			Other: "Your reservation cancelled.",
 		},
 	})
-	return linebot.NewFlexMessage(altText, contents)
+	return RichCard{
+		AltText: altText,
+		Body: []CardBlock{
+			TextBlock(title),
+			ButtonBlock(PostbackButton(ans1, fmt.Sprintf(postbackFormat, tripID, "wait-too-long"))),
+			ButtonBlock(PostbackButton(ans2, fmt.Sprintf(postbackFormat, tripID, "no-longer-need"))),
+			ButtonBlock(PostbackButton(ans3, fmt.Sprintf(postbackFormat, tripID, "take-another-mod"))),
+			ButtonBlock(PostbackButton(ans4, fmt.Sprintf(postbackFormat, tripID, "walk"))),
+		},
+	}
 }
 
-// HelpMessageFlex push Flex message for language options
-func (app *HailingApp) HelpMessageFlex(localizer *i18n.Localizer) linebot.SendingMessage {
+// HelpMessageFlex returns a card listing available commands
+func (app *HailingApp) HelpMessageFlex(localizer *i18n.Localizer) Message {
 	title := localizer.MustLocalize(&i18n.LocalizeConfig{
 		DefaultMessage: &i18n.Message{
 			ID:    "Help",
@@ -1465,59 +1316,13 @@ func (app *HailingApp) HelpMessageFlex(localizer *i18n.Localizer) linebot.Sendin
 			Other: "List of available commands",
 		},
 	})
-	elements := []linebot.FlexComponent{
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   title,
-			Weight: linebot.FlexTextWeightTypeBold,
-			Size:   linebot.FlexTextSizeTypeLg,
-		},
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   listOfCommands,
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeMd,
-		},
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   "/help - this command",
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeSm,
-		},
-		&linebot.TextComponent{
-			Type:   linebot.FlexComponentTypeText,
-			Text:   "/lang - call language UI picker",
-			Wrap:   true,
-			Weight: linebot.FlexTextWeightTypeRegular,
-			Size:   linebot.FlexTextSizeTypeSm,
-		},
-	}
-
-	contents := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Body: &linebot.BoxComponent{
-			Type:     linebot.FlexComponentTypeBox,
-			Layout:   linebot.FlexBoxLayoutTypeVertical,
-			Contents: elements,
-		},
-		Footer: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.SpacerComponent{
-					Type: linebot.FlexComponentTypeSeparator,
-					Size: linebot.FlexSpacerSizeTypeSm,
-				},
-			},
+	return RichCard{
+		AltText: title,
+		Title:   title,
+		Body: []CardBlock{
+			TextBlock(listOfCommands),
+			{Kind: BlockText, Text: "/help - this command", Small: true},
+			{Kind: BlockText, Text: "/lang - call language UI picker", Small: true},
 		},
 	}
-	altText := localizer.MustLocalize(&i18n.LocalizeConfig{
-		DefaultMessage: &i18n.Message{
-			ID:    "Help",
-			Other: "Help",
-		},
-	})
-	return linebot.NewFlexMessage(altText, contents)
 }
@@ -7,7 +7,6 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/line/line-bot-sdk-go/linebot"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
@@ -25,7 +24,7 @@ func (app *HailingApp) BotCommandHandler(replyToken string, lineUserID string, r
 	}
 	cmd1 := strings.TrimPrefix(cmds[0], "/")
 	cmd1 = strings.ToLower(cmd1)
-	msgs := []linebot.SendingMessage{}
+	msgs := []Message{}
 	log.Printf("[BotCmd] %v", cmds)
 
 	switch cmd1 {
@@ -54,6 +53,10 @@ func (app *HailingApp) BotCommandHandler(replyToken string, lineUserID string, r
 	case "lang":
 		langFlex := app.LanguageOptionFlex(localizer)
 		msgs = append(msgs, langFlex)
+	case "places":
+		return app.PlacesHandler(replyToken, lineUserID, cmds[1:])
+	case "repeat":
+		return app.RecurringRideHandler(replyToken, lineUserID, cmds[1:])
 	case "help":
 		helpFlex := app.HelpMessageFlex(localizer)
 		msgs = append(msgs, helpFlex)
@@ -66,16 +69,10 @@ func (app *HailingApp) BotCommandHandler(replyToken string, lineUserID string, r
 				Other: "Command unavailable",
 			},
 		})
-		txtMsg := linebot.NewTextMessage(msgCommandUnavailable)
+		txtMsg := TextMessage{Text: msgCommandUnavailable}
 		msgs = append(msgs, txtMsg)
 	}
-	if _, err := app.bot.ReplyMessage(
-		replyToken,
-		msgs...,
-	).Do(); err != nil {
-		return err
-	}
-	return nil
+	return app.messenger.Reply(replyToken, msgs...)
 }
 
 // HelpHandler shows the available command
@@ -101,10 +98,7 @@ func (app *HailingApp) LanguageHandler(replyToken string, lineUserID string, lan
 	})
 
 	if user.Language == lang {
-		if _, err := app.bot.ReplyMessage(
-			replyToken,
-			linebot.NewTextMessage(msgLanguageTheSame),
-		).Do(); err != nil {
+		if err := app.replyText(replyToken, msgLanguageTheSame); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReservationConversation(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	tests := []struct {
+		name  string
+		steps []fakeLineStep
+	}{
+		{
+			name: "call the cab to confirm",
+			steps: []fakeLineStep{
+				{Name: "init", Text: "call the cab", Expect: "Where to?"},
+				{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+				{Name: "from", Text: "Citi Resort", Expect: "When?"},
+				{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+				{Name: "passengers", Text: "2", Expect: "EstTravelTime"},
+				{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+				{Name: "status", Text: "status", Expect: "Record confirmation"},
+				{Name: "cancel", Text: "cancel", Expect: "Your reservation cancelled."},
+			},
+		},
+		{
+			name: "edit destination before confirming",
+			steps: []fakeLineStep{
+				{Name: "init", Text: "call the cab", Expect: "Where to?"},
+				{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+				{Name: "from", Text: "Citi Resort", Expect: "When?"},
+				{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+				{Name: "passengers", Text: "2", Expect: "EstTravelTime"},
+				{Name: "edit", Postback: "edit:to", Expect: "Where to?"},
+				{Name: "to-again", Text: "Condo A", Expect: "EstTravelTime"},
+				{Name: "edit-passengers", Postback: "edit:num_of_passengers", Expect: "How many passengers?"},
+				{Name: "passengers-again", Text: "3", Expect: "EstTravelTime"},
+				{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+				{Name: "edit-time-after-confirm", Postback: "edit:when", Expect: "nothing to edit"},
+				{Name: "cancel", Text: "cancel", Expect: "Your reservation cancelled."},
+			},
+		},
+		{
+			name: "wrong answer asks again",
+			steps: []fakeLineStep{
+				{Name: "init", Text: "call the cab", Expect: "Where to?"},
+				{Name: "to", Text: "nowhere", Expect: "Where to?"},
+				{Name: "to-again", Text: "Citi Resort", Expect: "Pickup location?"},
+				{Name: "from", Text: "BTS Phromphong", Expect: "When?"},
+				{Name: "when", Text: "yesterday", Expect: "When?"},
+				{Name: "reset", Text: "reset", Expect: ""},
+			},
+		},
+		{
+			name: "no reservation yet",
+			steps: []fakeLineStep{
+				{Name: "status", Text: "status", Expect: "Start reservation"},
+				{Name: "random", Text: "hello", Expect: "Start reservation"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lineUserID := "U" + uuid.New().String()
+			runConversation(t, fl, app, lineUserID, tc.steps)
+		})
+	}
+}
+
+func TestChangeConfirmedTrip(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	lineUserID := "U" + uuid.New().String()
+
+	runConversation(t, fl, app, lineUserID, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+		{Name: "too-many", Text: "5", Expect: "from 1 to 4"},
+		{Name: "passengers", Text: "2", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+		{Name: "edit-to", Postback: "edit:to", Expect: "Where to?"},
+		{Name: "to-again", Text: "Condo A", Expect: "Your trip has been updated."},
+		{Name: "edit-passengers", Postback: "edit:num_of_passengers", Expect: "How many passengers?"},
+		{Name: "zero", Text: "0", Expect: "from 1 to 4"},
+		{Name: "negative", Text: "-2", Expect: "from 1 to 4"},
+		{Name: "huge", Text: "99999999999999999999", Expect: "from 1 to 4"},
+		{Name: "passengers-again", Text: "3", Expect: "Your trip has been updated."},
+	})
+
+	active, err := fl.Store.FindActiveReservation(lineUserID)
+	if err != nil {
+		t.Fatal("trip not found: ", err)
+	}
+	if active.To != "Condo A" || active.ToCoords != placeCoords("condo a") || active.NumOfPassengers != 3 {
+		t.Errorf("trip row isn't updated: %v", active)
+	}
+	if active.Polyline == "" {
+		t.Error("polyline must be recomputed")
+	}
+
+	// driver takes the job; nothing can be changed anymore
+	fl.Store.UpdateTrip(active.TripID, func(trip *MemoryTrip) {
+		trip.DriverID = uuid.New()
+	})
+	runConversation(t, fl, app, lineUserID, []fakeLineStep{
+		{Name: "edit-from", Postback: "edit:from", Expect: "Contact assigned driver"},
+		{Name: "status", Text: "status", Expect: "Record confirmation"},
+	})
+}
+
+func TestOneTripAtATime(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+	os.Setenv("BOOKING_HORIZON", "48h")
+	defer os.Unsetenv("BOOKING_HORIZON")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	lineUserID := "U" + uuid.New().String()
+
+	tomorrow := time.Now().Add(24 * time.Hour).Truncate(time.Minute)
+	runConversation(t, fl, app, lineUserID, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Postback: "DATETIME", Datetime: tomorrow, Expect: "How many passengers?"},
+		{Name: "passengers", Text: "1", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+	booked, err := fl.Store.FindActiveReservation(lineUserID)
+	if err != nil {
+		t.Fatal("trip not found: ", err)
+	}
+
+	// riding now isn't mistaken for tomorrow's trip
+	app.Cleanup(lineUserID)
+	runConversation(t, fl, app, lineUserID, []fakeLineStep{
+		{Name: "init-now", Text: "call the cab", Expect: "You already have a ride at " + PickupTimeText(tomorrow)},
+		{Name: "book-now", Text: "I need a taxi to citi resort now", Expect: "You already have a ride at"},
+		{Name: "status", Text: "status", Expect: "Record confirmation"},
+	})
+	active, err := fl.Store.FindActiveReservation(lineUserID)
+	if err != nil || active.TripID != booked.TripID || !active.ReservedAt.Equal(tomorrow) || active.To != booked.To {
+		t.Fatalf("tomorrow's trip must be kept as it is: %v %v", active, err)
+	}
+
+	runConversation(t, fl, app, lineUserID, []fakeLineStep{
+		{Name: "cancel", Text: "cancel", Expect: "Your reservation cancelled."},
+		{Name: "init-again", Text: "call the cab", Expect: "Where to?"},
+	})
+}
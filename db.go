@@ -3,14 +3,13 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/encoding/wkb"
 )
@@ -32,16 +31,17 @@ type Coords struct {
 
 // Trip stores trip information
 type Trip struct {
-	ID           int        `json:"id"`
-	UserID       uuid.UUID  `json:"user_id"`
-	DriverID     uuid.UUID  `json:"driver_id"`
-	ReservedAt   *time.Time `json:"reserved_at"`
-	AcceptedAt   *time.Time `json:"accepted_at"`
-	PickedUpAt   *time.Time `json:"picked_up_at"`
-	DroppedOffAt *time.Time `json:"dropped_off_at"`
-	CancelledAt  *time.Time `json:"cancelled_at"`
-	From         string     `json:"from"`
-	To           string     `json:"to"`
+	ID              int        `json:"id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	DriverID        uuid.UUID  `json:"driver_id"`
+	ReservedAt      *time.Time `json:"reserved_at"`
+	AcceptedAt      *time.Time `json:"accepted_at"`
+	PickedUpAt      *time.Time `json:"picked_up_at"`
+	DroppedOffAt    *time.Time `json:"dropped_off_at"`
+	CancelledAt     *time.Time `json:"cancelled_at"`
+	From            string     `json:"from"`
+	To              string     `json:"to"`
+	NumOfPassengers int        `json:"no_passengers"`
 	// PlaceFrom      Coords     `json:"place_from"`
 	// PlaceTo        Coords     `json:"place_to"`
 	Note           string `json:"note"`
@@ -49,6 +49,16 @@ type Trip struct {
 	DriverFeedback int    `json:"driver_feedback"`
 }
 
+// IsDriverAssigned tells if a driver took this trip already
+func (trip *Trip) IsDriverAssigned() bool {
+	return trip.DriverID != uuid.UUID{}
+}
+
+// IsPickedUp tells if the rider is already picked up
+func (trip *Trip) IsPickedUp() bool {
+	return trip.PickedUpAt != nil && trip.PickedUpAt.Format("2006-01-01") != "0001-01-01"
+}
+
 // Location stores a list of available choices
 type Location struct {
 	ID    int    `json:"id"`
@@ -56,23 +66,63 @@ type Location struct {
 	Name  string `json:"name"`
 }
 
+// NamedLocation is a location with its names in every language,
+// i.e. en, th & ja
+type NamedLocation struct {
+	Location
+	Names map[string]string
+}
+
+// NameIn returns the name in lang, or the English one
+func (loc NamedLocation) NameIn(lang string) string {
+	if name := loc.Names[lang]; name != "" {
+		return name
+	}
+	return loc.Name
+}
+
+// SavedPlace is a place a rider names for themselves, e.g. home or work
+type SavedPlace struct {
+	ID     int       `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	Place  Coords    `json:"place"`
+}
+
+// PostgresStore is UserStore, TripStore, LocationStore, SubscriptionStore
+// and PlaceStore on PostgreSQL with PostGIS
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns stores on db
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
 // GetLocationByID returns a location in Location struct
-func (app *HailingApp) GetLocationByID(ID int) (*Location, error) {
+func (s *PostgresStore) GetLocationByID(ID int) (*Location, error) {
 	result := Location{}
 	var p []byte
-	err := app.pdb.QueryRow(`SELECT id, name, ST_AsGeoJSON(place)
+	err := s.db.QueryRow(`SELECT id, name, ST_AsGeoJSON(place)
 		FROM location
 		WHERE id=$1`, ID).Scan(&result.ID, &result.Name, &p)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	json.Unmarshal(p, &result.Place)
-	log.Printf("[GetLocationByID] location ID: %v -- %v", ID, result)
 	return &result, nil
 }
 
 // GetLocations return most popular locations
-func (app *HailingApp) GetLocations(lang string, total int) ([]Location, error) {
+func (s *PostgresStore) GetLocations(lang string, total int) ([]Location, error) {
 	results := []Location{}
 	maxTotal := 10
 	if total < 1 || total > maxTotal {
@@ -89,7 +139,7 @@ func (app *HailingApp) GetLocations(lang string, total int) ([]Location, error)
 		FROM location
 		ORDER BY popularity DESC
 		LIMIT $1`, fieldName)
-	rows, err := app.pdb.Query(q, total)
+	rows, err := s.db.Query(q, total)
 	if err != nil {
 		return nil, err
 	}
@@ -107,50 +157,134 @@ func (app *HailingApp) GetLocations(lang string, total int) ([]Location, error)
 	return results, nil
 }
 
-// FindOrCreateUser handles user query from line user id
-func (app *HailingApp) FindOrCreateUser(lineUserID string) (*User, error) {
+// ListLocations returns all locations named in every language,
+// most popular first
+func (s *PostgresStore) ListLocations() ([]NamedLocation, error) {
+	rows, err := s.db.Query(`
+	SELECT id, name, COALESCE(name_th, ''), COALESCE(name_ja, ''), ST_AsGeoJSON(place)
+	FROM location
+	ORDER BY popularity DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []NamedLocation{}
+	for rows.Next() {
+		var one NamedLocation
+		var nameTH, nameJA string
+		var p []byte
+		if err := rows.Scan(&one.ID, &one.Name, &nameTH, &nameJA, &p); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(p, &one.Place)
+		one.Names = map[string]string{"en": one.Name, "th": nameTH, "ja": nameJA}
+		results = append(results, one)
+	}
+	return results, rows.Err()
+}
+
+// MatchTripLocations links both ends of the trip to the nearest location
+// within radius (m)
+func (s *PostgresStore) MatchTripLocations(tripID int, radius float64) error {
+	var ID int
+	err := s.db.QueryRow(`
+	UPDATE "trip" t SET
+		"location_from_id" = (
+			SELECT l.id FROM "location" l
+			WHERE ST_DWithin(l.place::geography, t.place_from::geography, $2)
+			ORDER BY ST_Distance(l.place::geography, t.place_from::geography)
+			LIMIT 1
+		),
+		"location_to_id" = (
+			SELECT l.id FROM "location" l
+			WHERE ST_DWithin(l.place::geography, t.place_to::geography, $2)
+			ORDER BY ST_Distance(l.place::geography, t.place_to::geography)
+			LIMIT 1
+		)
+	WHERE t.id=$1
+	RETURNING t.id`, tripID, radius).Scan(&ID)
+	if err != nil {
+		return notFound(err)
+	}
+	return nil
+}
+
+// NearestLocation returns the location nearest to coords within radius (m)
+func (s *PostgresStore) NearestLocation(coords [2]float64, radius float64) (*Location, float64, error) {
+	result := Location{}
+	var p []byte
+	var distance float64
+	point := fmt.Sprintf("POINT(%.8f %.8f)", coords[0], coords[1])
+	err := s.db.QueryRow(`
+	SELECT id, name, ST_AsGeoJSON(place),
+		ST_Distance(place::geography, $1::geography)
+	FROM "location"
+	WHERE ST_DWithin(place::geography, $1::geography, $2)
+	ORDER BY place::geography <-> $1::geography
+	LIMIT 1`, point, radius).Scan(&result.ID, &result.Name, &p, &distance)
+	if err != nil {
+		return nil, 0, notFound(err)
+	}
+	json.Unmarshal(p, &result.Place)
+	return &result, distance, nil
+}
+
+// RecomputePopularity scores locations by the trips from & to them;
+// see popularityScore. Locations without any trip keep their popularity.
+func (s *PostgresStore) RecomputePopularity(now time.Time, halfLife time.Duration) error {
+	_, err := s.db.Exec(`
+	WITH ends AS (
+		SELECT "location_from_id" AS location_id, "reserved_at" FROM "trip"
+		WHERE cancelled_at is null AND location_from_id is not null
+		UNION ALL
+		SELECT "location_to_id", "reserved_at" FROM "trip"
+		WHERE cancelled_at is null AND location_to_id is not null
+	), scores AS (
+		SELECT location_id, SUM(POWER(0.5,
+			GREATEST(EXTRACT(EPOCH FROM ($1::timestamptz - reserved_at)), 0) / $2
+		)) AS score
+		FROM ends
+		GROUP BY location_id
+	)
+	UPDATE "location" l
+	SET "popularity" = ROUND(scores.score * 100)
+	FROM scores
+	WHERE scores.location_id = l.id`, now, halfLife.Seconds())
+	return err
+}
+
+// FindUserByLineID returns user by LINE user ID
+func (s *PostgresStore) FindUserByLineID(lineUserID string) (*User, error) {
 	row := User{}
-	err := app.pdb.QueryRow(`
+	err := s.db.QueryRow(`
 		SELECT id,line_user_id,username,profile_url,lang FROM "user"
 		WHERE line_user_id=$1`,
 		lineUserID).Scan(
 		&row.ID, &row.LineUserID, &row.Username, &row.ProfileURL, &row.Language)
-
-	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
-		// we have to create a new record
-		profile, botErr := app.bot.GetProfile(lineUserID).Do()
-		if botErr != nil {
-			return nil, botErr
-		}
-		username := profile.DisplayName
-		profileURL := profile.PictureURL
-		uC, errC := app.CreateUser(username, lineUserID, profileURL)
-		if errC != nil {
-			return nil, errC
-		}
-		return uC, nil
+	if err != nil {
+		return nil, notFound(err)
 	}
 	return &row, nil
 }
 
 // FindUserByID is to find LineUserID from the system ID
-func (app *HailingApp) FindUserByID(ID uuid.UUID) (*User, error) {
+func (s *PostgresStore) FindUserByID(ID uuid.UUID) (*User, error) {
 	u := User{}
-	err := app.pdb.QueryRow(`
+	err := s.db.QueryRow(`
 	SELECT id,line_user_id,username,profile_url,lang
 	FROM "user"
 	WHERE id=$1`,
 		ID).Scan(&u.ID, &u.LineUserID, &u.Username, &u.ProfileURL, &u.Language)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &u, nil
 }
 
 // CreateUser handles user creation
-func (app *HailingApp) CreateUser(username string, lineUserID string, profileURL string) (*User, error) {
+func (s *PostgresStore) CreateUser(username string, lineUserID string, profileURL string) (*User, error) {
 	var uid uuid.UUID
-	err := app.pdb.QueryRow(`
+	err := s.db.QueryRow(`
 	INSERT INTO "user"("username", "line_user_id", "profile_url")
 	VALUES($1, $2, $3) RETURNING id
 	`, username, lineUserID, profileURL).Scan(&uid)
@@ -160,7 +294,7 @@ func (app *HailingApp) CreateUser(username string, lineUserID string, profileURL
 		newRandom, _ := uuid.NewRandom()
 		four := fmt.Sprintf("%v", newRandom)[:4]
 		newUsername := fmt.Sprintf("%s_%s", username, four)
-		return app.CreateUser(newUsername, lineUserID, profileURL)
+		return s.CreateUser(newUsername, lineUserID, profileURL)
 	}
 
 	if err != nil {
@@ -178,21 +312,36 @@ func (app *HailingApp) CreateUser(username string, lineUserID string, profileURL
 	return &u, nil
 }
 
-// SaveReservationToPostgres is to record this completed reservation to a permanent medium (postgresl)
-func (app *HailingApp) SaveReservationToPostgres(rec *ReservationRecord) (int, error) {
+// SetLanguage save preferred lanague to "user" table
+func (s *PostgresStore) SetLanguage(userID uuid.UUID, lang string) error {
+	var resultID uuid.UUID
+	err := s.db.QueryRow(`
+	UPDATE "user" SET "lang" = $2
+	WHERE id=$1
+	RETURNING id
+	`, userID, lang).Scan(&resultID)
+	if err != nil {
+		log.Printf("[save2psql-lang] %v", err)
+		return notFound(err)
+	}
+	return nil
+}
+
+// SaveReservation is to record this completed reservation to a permanent medium (postgresl)
+func (s *PostgresStore) SaveReservation(rec *ReservationRecord) (int, error) {
 	var tripID int
 	if rec.TripID == -1 {
 		placeFrom := fmt.Sprintf("POINT(%.8f %.8f)", rec.FromCoords[0], rec.FromCoords[1])
 		placeTo := fmt.Sprintf("POINT(%.8f %.8f)", rec.ToCoords[0], rec.ToCoords[1])
 		// insert if no trip_id yet
-		err := app.pdb.QueryRow(`
+		err := s.db.QueryRow(`
 		INSERT INTO trip(
 			"user_id", "from", "place_from", "to", "place_to",
-			"reserved_at", "polyline", "no_passengers"
+			"reserved_at", "polyline", "no_passengers", "fare"
 		)
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
 			rec.UserID, rec.From, placeFrom, rec.To, placeTo,
-			rec.ReservedAt, rec.Polyline, rec.NumOfPassengers,
+			rec.ReservedAt, rec.Polyline, rec.NumOfPassengers, rec.Fare,
 		).Scan(&tripID)
 		if err != nil {
 			log.Printf("[save2psql-create] %v", err)
@@ -200,40 +349,49 @@ func (app *HailingApp) SaveReservationToPostgres(rec *ReservationRecord) (int, e
 		}
 		return tripID, nil
 	}
-	// update postgresql record
-	err := app.pdb.QueryRow(`
-	UPDATE "trip" SET ("from", "to", "reserved_at") = ($2, $3, $4)
+	// update postgresql record; driver side sees the change from this row
+	placeFrom := fmt.Sprintf("POINT(%.8f %.8f)", rec.FromCoords[0], rec.FromCoords[1])
+	placeTo := fmt.Sprintf("POINT(%.8f %.8f)", rec.ToCoords[0], rec.ToCoords[1])
+	err := s.db.QueryRow(`
+	UPDATE "trip" SET (
+		"from", "place_from", "to", "place_to",
+		"reserved_at", "polyline", "no_passengers", "fare"
+	) = ($2, $3, $4, $5, $6, $7, $8, $9)
 	WHERE id=$1
 	RETURNING id
-	`, rec.TripID, rec.From, rec.To, rec.ReservedAt).Scan(&tripID)
+	`, rec.TripID, rec.From, placeFrom, rec.To, placeTo,
+		rec.ReservedAt, rec.Polyline, rec.NumOfPassengers, rec.Fare,
+	).Scan(&tripID)
 	if err != nil {
 		log.Printf("[save2psql-update] %v", err)
-		return -1, err
+		return -1, notFound(err)
 	}
 	return tripID, nil
 }
 
-// FindActiveReservation query from postgresql and put in redis
-func (app *HailingApp) FindActiveReservation(lineUserID string) (*ReservationRecord, error) {
-	record := ReservationRecord{LineUserID: lineUserID, State: "done", IsConfirmed: true}
+// FindActiveReservation query from postgresql; the soonest trip comes first
+func (s *PostgresStore) FindActiveReservation(lineUserID string) (*ReservationRecord, error) {
+	record := ReservationRecord{LineUserID: lineUserID, State: StateDone, Waiting: StatePickup, IsConfirmed: true}
 
 	var pFrom orb.Point
 	var pTo orb.Point
 	var pickedUpAt sql.NullTime
-	err := app.pdb.QueryRow(`
+	err := s.db.QueryRow(`
 	SELECT
 		t.id, t.user_id,
 		t.from, t.to, t.reserved_at,
-		t.picked_up_at, t.polyline, t.no_passengers,
+		t.picked_up_at, t.polyline, t.no_passengers, COALESCE(t.fare, 0),
 		ST_AsBinary(t.place_from), ST_AsBinary(t.place_to)
 	FROM "trip" t
 	LEFT JOIN "user" u ON t.user_id = u.id
 	WHERE u.line_user_id = $1
 		AND t.dropped_off_at is null
-		AND t.cancelled_at is null`, lineUserID).Scan(
+		AND t.cancelled_at is null
+	ORDER BY t.reserved_at, t.id DESC
+	LIMIT 1`, lineUserID).Scan(
 		&record.TripID, &record.UserID,
 		&record.From, &record.To, &record.ReservedAt,
-		&pickedUpAt, &record.Polyline, &record.NumOfPassengers,
+		&pickedUpAt, &record.Polyline, &record.NumOfPassengers, &record.Fare,
 		wkb.Scanner(&pFrom), wkb.Scanner(&pTo),
 	)
 	record.FromCoords = [2]float64{pFrom.Lon(), pFrom.Lat()}
@@ -243,117 +401,394 @@ func (app *HailingApp) FindActiveReservation(lineUserID string) (*ReservationRec
 	}
 	if err != nil {
 		// log.Printf("[FindActiveReservation] %v", err)
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &record, nil
 }
 
-// SaveTripFeedback update feedback from user
-func (app *HailingApp) SaveTripFeedback(tripID int, rating int) (string, error) {
-	var resultTripID int
-	err := app.pdb.QueryRow(`
-	UPDATE "trip" SET "user_feedback" = $2
-	WHERE id=$1
-	RETURNING id
-	`, tripID, rating).Scan(&resultTripID)
+// GetTrip returns trip record
+func (s *PostgresStore) GetTrip(tripID int) (*Trip, error) {
+	trip := Trip{ID: tripID}
+	err := s.db.QueryRow(`
+	SELECT "user_id", "driver_id", "reserved_at", "picked_up_at", "from", "to", "dropped_off_at",
+		"cancelled_at", "no_passengers"
+	FROM "trip"
+	WHERE id=$1`, tripID).Scan(
+		&trip.UserID, &trip.DriverID, &trip.ReservedAt, &trip.PickedUpAt, &trip.From, &trip.To,
+		&trip.DroppedOffAt, &trip.CancelledAt, &trip.NumOfPassengers,
+	)
 	if err != nil {
-		log.Printf("[save2psql-cancel] %v", err)
-		return "failed", err
+		return nil, notFound(err)
 	}
-	return strconv.Itoa(resultTripID), nil
+	return &trip, nil
 }
 
-// SetLanguage save preferred lanague to "user" table
-func (app *HailingApp) SetLanguage(userID uuid.UUID, lang string) (string, error) {
-	var resultID uuid.UUID
-	err := app.pdb.QueryRow(`
-	UPDATE "user" SET "lang" = $2
-	WHERE id=$1
-	RETURNING id
-	`, userID, lang).Scan(&resultID)
+// GetTripPlaces returns pickup & drop-off coordinates of the trip
+func (s *PostgresStore) GetTripPlaces(tripID int) ([2]float64, [2]float64, error) {
+	var pFrom orb.Point
+	var pTo orb.Point
+	err := s.db.QueryRow(`
+	SELECT ST_AsBinary("place_from"), ST_AsBinary("place_to")
+	FROM "trip"
+	WHERE id=$1`, tripID).Scan(wkb.Scanner(&pFrom), wkb.Scanner(&pTo))
 	if err != nil {
-		log.Printf("[save2psql-cancel] %v", err)
-		return "failed", err
+		return [2]float64{}, [2]float64{}, notFound(err)
 	}
-	return "ok", nil
+	return [2]float64{pFrom.Lon(), pFrom.Lat()}, [2]float64{pTo.Lon(), pTo.Lat()}, nil
 }
 
-// GetTripRecordByID returns trip record
-func (app *HailingApp) GetTripRecordByID(tripID int) (*Trip, error) {
-	trip := Trip{}
-	err := app.pdb.QueryRow(`
-	SELECT "user_id", "reserved_at", "picked_up_at", "from", "to", "dropped_off_at"
+// TripHistory returns the user's trips which aren't cancelled, latest first
+func (s *PostgresStore) TripHistory(userID uuid.UUID, limit int) ([]PastTrip, error) {
+	rows, err := s.db.Query(`
+	SELECT "id", "from", ST_AsBinary("place_from"), "to", ST_AsBinary("place_to"), "reserved_at",
+		COALESCE("location_from_id", 0), COALESCE("location_to_id", 0)
 	FROM "trip"
-	WHERE id=$1`, tripID).Scan(
-		&trip.UserID, &trip.ReservedAt, &trip.PickedUpAt, &trip.From, &trip.To,
-		&trip.DroppedOffAt,
-	)
+	WHERE user_id=$1 AND cancelled_at is null
+	ORDER BY reserved_at DESC
+	LIMIT $2`, userID, limit)
 	if err != nil {
-		log.Printf("[GetTripRecord] %v", err)
 		return nil, err
 	}
-	return &trip, nil
+	defer rows.Close()
+	results := []PastTrip{}
+	for rows.Next() {
+		var trip PastTrip
+		var pFrom orb.Point
+		var pTo orb.Point
+		if err := rows.Scan(
+			&trip.ID, &trip.From, wkb.Scanner(&pFrom), &trip.To, wkb.Scanner(&pTo), &trip.ReservedAt,
+			&trip.FromLocation, &trip.ToLocation,
+		); err != nil {
+			return nil, err
+		}
+		trip.FromCoords = [2]float64{pFrom.Lon(), pFrom.Lat()}
+		trip.ToCoords = [2]float64{pTo.Lon(), pTo.Lat()}
+		results = append(results, trip)
+	}
+	return results, rows.Err()
 }
 
-// GetTripRecord returns trip record
-func (app *HailingApp) GetTripRecord(rec *ReservationRecord) (*Trip, error) {
-	trip := Trip{}
-	err := app.pdb.QueryRow(`
-	SELECT "user_id", "driver_id", "reserved_at", "picked_up_at"
+// UpcomingTrips returns trips to pick up in (from, to]
+func (s *PostgresStore) UpcomingTrips(from time.Time, to time.Time) ([]Trip, error) {
+	rows, err := s.db.Query(`
+	SELECT "id", "user_id", "driver_id", "reserved_at", "from", "to"
 	FROM "trip"
-	WHERE id=$1`, rec.TripID).Scan(
-		&trip.UserID, &trip.DriverID, &trip.ReservedAt, &trip.PickedUpAt,
-	)
+	WHERE reserved_at > $1 AND reserved_at <= $2
+		AND cancelled_at is null
+		AND picked_up_at is null
+		AND dropped_off_at is null
+	ORDER BY reserved_at`, from, to)
 	if err != nil {
-		log.Printf("[GetTripRecord] %v", err)
 		return nil, err
 	}
-	return &trip, nil
+	defer rows.Close()
+	results := []Trip{}
+	for rows.Next() {
+		var trip Trip
+		if err := rows.Scan(
+			&trip.ID, &trip.UserID, &trip.DriverID, &trip.ReservedAt, &trip.From, &trip.To,
+		); err != nil {
+			return nil, err
+		}
+		results = append(results, trip)
+	}
+	return results, rows.Err()
 }
 
-// CancelReservation will handle whether it's okay to cancel or not too
-func (app *HailingApp) CancelReservation(rec *ReservationRecord) (string, error) {
-	trip, err := app.GetTripRecord(rec)
+// SaveTripFeedback update feedback from user
+func (s *PostgresStore) SaveTripFeedback(tripID int, rating int) error {
+	var resultTripID int
+	err := s.db.QueryRow(`
+	UPDATE "trip" SET "user_feedback" = $2
+	WHERE id=$1
+	RETURNING id
+	`, tripID, rating).Scan(&resultTripID)
 	if err != nil {
-		return "failed", err
+		log.Printf("[save2psql-feedback] %v", err)
+		return notFound(err)
 	}
-	blankUUID := uuid.UUID{}
-	if trip.DriverID != blankUUID {
-		return "failed", errors.New("Contact assigned driver for cancellation")
+	return nil
+}
+
+// SaveTravelChoice logs the option the rider took
+func (s *PostgresStore) SaveTravelChoice(choice *TravelChoice) error {
+	var tripID sql.NullInt64
+	if choice.TripID > 0 {
+		tripID = sql.NullInt64{Int64: int64(choice.TripID), Valid: true}
 	}
-	fmt.Print("[PSQL-CANCEL] ", trip)
-	if trip.PickedUpAt != nil && trip.PickedUpAt.Format("2006-01-01") != "0001-01-01" {
-		// cancel isn't possible now
-		return "failed", errors.New("Cancellation is not allowed at this point")
+	var cost sql.NullFloat64
+	if choice.Option.Cost >= 0 {
+		cost = sql.NullFloat64{Float64: choice.Option.Cost, Valid: true}
 	}
-	var tripID int
-	now := time.Now()
-	note := "User cancelled via line-bot"
-	// update postgresql record
-	err = app.pdb.QueryRow(`
+	_, err := s.db.Exec(`
+	INSERT INTO travel_choice(
+		"user_id", "trip_id", "mode", "place_from", "place_to",
+		"distance", "duration", "cost", "currency", "co2", "chosen_at"
+	)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		choice.UserID, tripID, choice.Option.Mode,
+		fmt.Sprintf("POINT(%.8f %.8f)", choice.From[0], choice.From[1]),
+		fmt.Sprintf("POINT(%.8f %.8f)", choice.To[0], choice.To[1]),
+		choice.Option.Distance, choice.Option.Duration, cost, choice.Option.Currency,
+		choice.Option.CO2, choice.ChosenAt,
+	)
+	if err != nil {
+		log.Printf("[save2psql-travel-choice] %v", err)
+	}
+	return err
+}
+
+// CancelTrip marks trip as cancelled
+func (s *PostgresStore) CancelTrip(tripID int, note string, cancelledAt time.Time) error {
+	var resultTripID int
+	err := s.db.QueryRow(`
 		UPDATE "trip" SET ("note", "cancelled_at") = ($2, $3)
 		WHERE id=$1
 		RETURNING id
-		`, rec.TripID, note, now).Scan(&tripID)
+		`, tripID, note, cancelledAt).Scan(&resultTripID)
 	if err != nil {
-		log.Printf("[save2psql-cancel] [1] %v", err)
-		return "failed", err
+		return notFound(err)
 	}
-	return "success", nil
+	return nil
 }
 
-func (app *HailingApp) UpdateCancellationReason(tripID string, reason string) (string, error) {
-	note := fmt.Sprintf("User cancelled via line-bot\nreason: %s", reason)
-	// update postgresql record
-	err := app.pdb.QueryRow(`
+// SetTripNote replaces the trip's note
+func (s *PostgresStore) SetTripNote(tripID int, note string) error {
+	var resultTripID int
+	err := s.db.QueryRow(`
 		UPDATE "trip" SET "note" = $2
 		WHERE id=$1
 		RETURNING id
-		`, tripID, note).Scan(&tripID)
+		`, tripID, note).Scan(&resultTripID)
+	if err != nil {
+		return notFound(err)
+	}
+	return nil
+}
+
+const subscriptionColumns = `
+	"id", "user_id", "from", ST_AsBinary("place_from"), "to", ST_AsBinary("place_to"),
+	"weekdays", "pickup_time", "no_passengers", "paused", "skip_dates",
+	"last_scheduled_on", "stopped_at"`
+
+// scanSubscription reads a row of subscriptionColumns
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*Subscription, error) {
+	var sub Subscription
+	var pFrom orb.Point
+	var pTo orb.Point
+	var weekdays int
+	var skipDates pq.StringArray
+	var lastScheduled sql.NullTime
+	var stoppedAt sql.NullTime
+	err := row.Scan(
+		&sub.ID, &sub.UserID, &sub.From, wkb.Scanner(&pFrom), &sub.To, wkb.Scanner(&pTo),
+		&weekdays, &sub.PickupTime, &sub.NumOfPassengers, &sub.Paused, &skipDates,
+		&lastScheduled, &stoppedAt,
+	)
 	if err != nil {
-		log.Printf("[save2psql-cancel] [2] %v", err)
-		return "failed", err
+		return nil, notFound(err)
+	}
+	sub.FromCoords = [2]float64{pFrom.Lon(), pFrom.Lat()}
+	sub.ToCoords = [2]float64{pTo.Lon(), pTo.Lat()}
+	sub.Weekdays = WeekdaysFromMask(weekdays)
+	for _, date := range skipDates {
+		// date[] comes as 2006-01-02 but be safe with timestamps
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		sub.SkipDates = append(sub.SkipDates, date)
+	}
+	if lastScheduled.Valid {
+		sub.LastScheduled = lastScheduled.Time.Format("2006-01-02")
+	}
+	if stoppedAt.Valid {
+		sub.StoppedAt = &stoppedAt.Time
+	}
+	return &sub, nil
+}
+
+// nullDate is NULL for empty date string
+func nullDate(date string) interface{} {
+	if date == "" {
+		return nil
 	}
-	return "success", nil
+	return date
+}
+
+// CreateSubscription returns ID of the new subscription
+func (s *PostgresStore) CreateSubscription(sub *Subscription) (int, error) {
+	var ID int
+	placeFrom := fmt.Sprintf("POINT(%.8f %.8f)", sub.FromCoords[0], sub.FromCoords[1])
+	placeTo := fmt.Sprintf("POINT(%.8f %.8f)", sub.ToCoords[0], sub.ToCoords[1])
+	err := s.db.QueryRow(`
+	INSERT INTO subscription(
+		"user_id", "from", "place_from", "to", "place_to",
+		"weekdays", "pickup_time", "no_passengers", "paused", "skip_dates",
+		"last_scheduled_on"
+	)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::date[], '{}'), $11) RETURNING id`,
+		sub.UserID, sub.From, placeFrom, sub.To, placeTo,
+		WeekdayMask(sub.Weekdays), sub.PickupTime, sub.NumOfPassengers, sub.Paused,
+		pq.Array(sub.SkipDates), nullDate(sub.LastScheduled),
+	).Scan(&ID)
+	if err != nil {
+		log.Printf("[save2psql-subscription] %v", err)
+		return -1, err
+	}
+	return ID, nil
+}
+
+// GetSubscription returns subscription by ID
+func (s *PostgresStore) GetSubscription(ID int) (*Subscription, error) {
+	row := s.db.QueryRow(`SELECT `+subscriptionColumns+`
+	FROM "subscription"
+	WHERE id=$1`, ID)
+	return scanSubscription(row)
+}
+
+// FindSubscriptions returns subscriptions of the user which aren't stopped
+func (s *PostgresStore) FindSubscriptions(userID uuid.UUID) ([]Subscription, error) {
+	return s.querySubscriptions(`SELECT `+subscriptionColumns+`
+	FROM "subscription"
+	WHERE user_id=$1 AND stopped_at is null
+	ORDER BY id`, userID)
+}
 
+// ActiveSubscriptions returns subscriptions neither paused nor stopped
+func (s *PostgresStore) ActiveSubscriptions() ([]Subscription, error) {
+	return s.querySubscriptions(`SELECT ` + subscriptionColumns + `
+	FROM "subscription"
+	WHERE paused = false AND stopped_at is null
+	ORDER BY id`)
+}
+
+func (s *PostgresStore) querySubscriptions(query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *sub)
+	}
+	return results, rows.Err()
+}
+
+// UpdateSubscription saves pause, skip dates & stop; see ClaimPickup for
+// last scheduled date
+func (s *PostgresStore) UpdateSubscription(sub *Subscription) error {
+	var ID int
+	var stoppedAt interface{}
+	if sub.StoppedAt != nil {
+		stoppedAt = *sub.StoppedAt
+	}
+	err := s.db.QueryRow(`
+	UPDATE "subscription" SET (
+		"paused", "skip_dates", "stopped_at"
+	) = ($2, COALESCE($3::date[], '{}'), $4)
+	WHERE id=$1
+	RETURNING id
+	`, sub.ID, sub.Paused, pq.Array(sub.SkipDates), stoppedAt,
+	).Scan(&ID)
+	if err != nil {
+		log.Printf("[save2psql-subscription] %v", err)
+		return notFound(err)
+	}
+	return nil
+}
+
+// ClaimPickup moves last_scheduled_on to date in one statement so only one
+// instance gets the pickup
+func (s *PostgresStore) ClaimPickup(ID int, date string) (bool, error) {
+	result, err := s.db.Exec(`
+	UPDATE "subscription" SET "last_scheduled_on" = $2::date
+	WHERE id=$1
+		AND paused = false AND stopped_at is null
+		AND NOT ($2::date = ANY(COALESCE(skip_dates, '{}')))
+		AND (last_scheduled_on is null OR last_scheduled_on < $2::date)
+	`, ID, date)
+	if err != nil {
+		log.Printf("[save2psql-subscription] claim: %v", err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// SavePlace adds a place or moves the one with the same name
+func (s *PostgresStore) SavePlace(userID uuid.UUID, name string, coords [2]float64) (*SavedPlace, error) {
+	place := SavedPlace{
+		UserID: userID,
+		Name:   name,
+		Place:  Coords{Coordinates: coords, Type: "Point"},
+	}
+	point := fmt.Sprintf("POINT(%.8f %.8f)", coords[0], coords[1])
+	err := s.db.QueryRow(`
+	INSERT INTO saved_place("user_id", "name", "place")
+	VALUES($1, $2, $3)
+	ON CONFLICT ("user_id", lower("name"))
+	DO UPDATE SET "name" = EXCLUDED."name", "place" = EXCLUDED."place"
+	RETURNING id`, userID, name, point).Scan(&place.ID)
+	if err != nil {
+		log.Printf("[save2psql-place] %v", err)
+		return nil, err
+	}
+	return &place, nil
+}
+
+// SavedPlaces returns places of the user, oldest first
+func (s *PostgresStore) SavedPlaces(userID uuid.UUID) ([]SavedPlace, error) {
+	rows, err := s.db.Query(`
+	SELECT "id", "name", ST_AsBinary("place")
+	FROM "saved_place"
+	WHERE user_id=$1
+	ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []SavedPlace{}
+	for rows.Next() {
+		var point orb.Point
+		place := SavedPlace{UserID: userID}
+		if err := rows.Scan(&place.ID, &place.Name, wkb.Scanner(&point)); err != nil {
+			return nil, err
+		}
+		place.Place = Coords{Coordinates: [2]float64{point.Lon(), point.Lat()}, Type: "Point"}
+		results = append(results, place)
+	}
+	return results, rows.Err()
+}
+
+// GetSavedPlace returns saved place by ID
+func (s *PostgresStore) GetSavedPlace(ID int) (*SavedPlace, error) {
+	var point orb.Point
+	place := SavedPlace{ID: ID}
+	err := s.db.QueryRow(`
+	SELECT "user_id", "name", ST_AsBinary("place")
+	FROM "saved_place"
+	WHERE id=$1`, ID).Scan(&place.UserID, &place.Name, wkb.Scanner(&point))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	place.Place = Coords{Coordinates: [2]float64{point.Lon(), point.Lat()}, Type: "Point"}
+	return &place, nil
+}
+
+// RemovePlace deletes the user's place by name, case-insensitive
+func (s *PostgresStore) RemovePlace(userID uuid.UUID, name string) error {
+	var ID int
+	err := s.db.QueryRow(`
+	DELETE FROM "saved_place"
+	WHERE user_id=$1 AND lower("name")=lower($2)
+	RETURNING id`, userID, name).Scan(&ID)
+	if err != nil {
+		return notFound(err)
+	}
+	return nil
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRouting stands in for OSRM (/route/v1/<mode>/<lon>,<lat>;<lon>,<lat>),
+// Google Directions (/maps/api/directions/json) and OpenTripPlanner
+// (/otp/routers/default/plan) in tests and offline development. Routes
+// come from fixtures, or are straight lines at the speed of the mode.
+// Faults can be injected into any API.
+type FakeRouting struct {
+	mu       sync.Mutex
+	fixtures map[string]Route
+	faults   map[string]Fault
+	requests int
+}
+
+// Fault is what goes wrong with every response of an API until it's
+// cleared with a zero Fault
+type Fault struct {
+	// Latency delays the response, unless the client gives up first
+	Latency time.Duration
+	// HTTPStatus fails the request with this status, e.g. 503
+	HTTPStatus int
+	// NoRoute answers NoRoute (OSRM) or ZERO_RESULTS (Google)
+	NoRoute bool
+}
+
+// RouteFixture is a recorded route of mode between two points
+type RouteFixture struct {
+	Mode  string     `json:"mode"`
+	From  [2]float64 `json:"from"`
+	To    [2]float64 `json:"to"`
+	Route Route      `json:"route"`
+}
+
+// fakeSpeeds (m/s) of straight-line routes
+var fakeSpeeds = map[string]float64{
+	"car":     8.3, // 30 km/h in the city
+	"walk":    1.4,
+	"bicycle": 4.2,
+	"transit": 6.0,
+}
+
+// fakeTrafficFactor is how much longer a car takes in traffic
+const fakeTrafficFactor = 1.3
+
+// fakeTransitFare (satang) of every OTP itinerary
+const fakeTransitFare = 1600
+
+// NewFakeRouting returns FakeRouting without fixtures
+func NewFakeRouting() *FakeRouting {
+	return &FakeRouting{fixtures: map[string]Route{}, faults: map[string]Fault{}}
+}
+
+// fixtureKey rounds points to about 10 m like routeCacheKey
+func fixtureKey(mode string, from [2]float64, to [2]float64) string {
+	return fmt.Sprintf("%s:%.4f,%.4f;%.4f,%.4f", mode, from[0], from[1], to[0], to[1])
+}
+
+// AddFixture serves route for mode between from & to
+func (f *FakeRouting) AddFixture(fixture RouteFixture) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fixtures[fixtureKey(fixture.Mode, fixture.From, fixture.To)] = fixture.Route
+}
+
+// LoadFixtures adds fixtures of a JSON array of RouteFixture
+func (f *FakeRouting) LoadFixtures(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fixtures []RouteFixture
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, fixture := range fixtures {
+		f.AddFixture(fixture)
+	}
+	return nil
+}
+
+// Inject makes every response of api ("osrm", "google" or "otp") go wrong
+func (f *FakeRouting) Inject(api string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[api] = fault
+}
+
+// Requests returns how many requests it has served
+func (f *FakeRouting) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// route returns the fixture, or a straight line
+func (f *FakeRouting) route(mode string, from [2]float64, to [2]float64) Route {
+	if route, ok := f.fixtures[fixtureKey(mode, from, to)]; ok {
+		return route
+	}
+	speed, ok := fakeSpeeds[mode]
+	if !ok {
+		speed = fakeSpeeds["car"]
+	}
+	distance := pointDistance(from, to)
+	return Route{
+		Geometry:   encodePolyline([][2]float64{from, to}),
+		Distance:   distance,
+		Duration:   distance / speed,
+		WeightName: "routability",
+		Weight:     distance / speed,
+	}
+}
+
+func (f *FakeRouting) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api := "osrm"
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/directions/json"):
+		api = "google"
+	case strings.HasSuffix(r.URL.Path, "/plan"):
+		api = "otp"
+	}
+	f.mu.Lock()
+	f.requests++
+	fault := f.faults[api]
+	f.mu.Unlock()
+
+	select {
+	case <-time.After(fault.Latency):
+	case <-r.Context().Done():
+		return
+	}
+	if fault.HTTPStatus != 0 {
+		http.Error(w, http.StatusText(fault.HTTPStatus), fault.HTTPStatus)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch api {
+	case "google":
+		f.serveGoogle(w, r, fault)
+	case "otp":
+		f.serveOTP(w, r, fault)
+	default:
+		f.serveOSRM(w, r, fault)
+	}
+}
+
+// serveOSRM answers .../route/v1/<mode>/<lon>,<lat>;<lon>,<lat>
+func (f *FakeRouting) serveOSRM(w http.ResponseWriter, r *http.Request, fault Fault) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var points [][2]float64
+	if len(parts) >= 2 {
+		for _, lonlat := range strings.Split(parts[len(parts)-1], ";") {
+			p, ok := parseFakePoint(lonlat, false)
+			if !ok {
+				points = nil
+				break
+			}
+			points = append(points, p)
+		}
+	}
+	if len(points) != 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(body{Code: "InvalidUrl", Message: "URL string malformed close to position 1"})
+		return
+	}
+	if fault.NoRoute {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(body{Code: "NoRoute", Message: "Impossible route between points"})
+		return
+	}
+	f.mu.Lock()
+	route := f.route(parts[len(parts)-2], points[0], points[1])
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(body{
+		Code:   "Ok",
+		Routes: []Route{route},
+		Waypoints: []waypoint{
+			{Point: points[0]},
+			{Point: points[1]},
+		},
+	})
+}
+
+// serveGoogle answers directions/json?origin=<lat>,<lng>&destination=...
+func (f *FakeRouting) serveGoogle(w http.ResponseWriter, r *http.Request, fault Fault) {
+	q := r.URL.Query()
+	from, okFrom := parseFakePoint(q.Get("origin"), true)
+	to, okTo := parseFakePoint(q.Get("destination"), true)
+	if !okFrom || !okTo || q.Get("key") == "" {
+		json.NewEncoder(w).Encode(ggDirectionResp{Status: "INVALID_REQUEST", ErrorMessage: "origin, destination & key are required"})
+		return
+	}
+	if fault.NoRoute {
+		json.NewEncoder(w).Encode(ggDirectionResp{Status: "ZERO_RESULTS"})
+		return
+	}
+	mode := "car"
+	for ours, theirs := range googleModes {
+		if theirs == q.Get("mode") {
+			mode = ours
+		}
+	}
+	f.mu.Lock()
+	route := f.route(mode, from, to)
+	f.mu.Unlock()
+	leg := ggLeg{
+		Distance:      tV{Text: DistanceText(route.Distance), Value: route.Distance},
+		Duration:      tV{Text: fmt.Sprintf("%.0f mins", route.Duration/60), Value: route.Duration},
+		StartLocation: latlon{Lat: from[1], Lon: from[0]},
+		EndLocation:   latlon{Lat: to[1], Lon: to[0]},
+	}
+	if mode == "car" && q.Get("departure_time") != "" {
+		traffic := route.DurationInTraffic
+		if traffic == 0 {
+			traffic = route.Duration * fakeTrafficFactor
+		}
+		leg.DurationInTraffic = tV{Text: fmt.Sprintf("%.0f mins", traffic/60), Value: traffic}
+	}
+	json.NewEncoder(w).Encode(ggDirectionResp{
+		Status: "OK",
+		Routes: []ggRouteResp{{
+			Legs:             []ggLeg{leg},
+			OverviewPolyline: polylineDict{Points: route.Geometry},
+		}},
+	})
+}
+
+// serveOTP answers plan?fromPlace=<lat>,<lon>&toPlace=... with a single
+// bus leg on the transit route
+func (f *FakeRouting) serveOTP(w http.ResponseWriter, r *http.Request, fault Fault) {
+	q := r.URL.Query()
+	from, okFrom := parseFakePoint(q.Get("fromPlace"), true)
+	to, okTo := parseFakePoint(q.Get("toPlace"), true)
+	if !okFrom || !okTo {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"id":400,"msg":"fromPlace & toPlace are required","message":"BOGUS_PARAMETER"}}`)
+		return
+	}
+	if fault.NoRoute {
+		fmt.Fprint(w, `{"error":{"id":404,"msg":"No trip found.","message":"PATH_NOT_FOUND","noPath":true}}`)
+		return
+	}
+	f.mu.Lock()
+	route := f.route("transit", from, to)
+	f.mu.Unlock()
+	b, _ := json.Marshal(map[string]interface{}{
+		"plan": map[string]interface{}{
+			"itineraries": []interface{}{map[string]interface{}{
+				"duration": route.Duration,
+				"fare": map[string]interface{}{"fare": map[string]interface{}{"regular": map[string]interface{}{
+					"cents":    fakeTransitFare,
+					"currency": map[string]string{"currencyCode": "THB"},
+				}}},
+				"legs": []interface{}{map[string]interface{}{
+					"mode":        "BUS",
+					"distance":    route.Distance,
+					"duration":    route.Duration,
+					"legGeometry": map[string]string{"points": route.Geometry},
+				}},
+			}},
+		},
+	})
+	w.Write(b)
+}
+
+// parseFakePoint reads "lon,lat", or "lat,lon" like Google
+func parseFakePoint(s string, latFirst bool) ([2]float64, bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return [2]float64{}, false
+	}
+	a, errA := strconv.ParseFloat(parts[0], 64)
+	b, errB := strconv.ParseFloat(parts[1], 64)
+	if errA != nil || errB != nil {
+		return [2]float64{}, false
+	}
+	if latFirst {
+		return [2]float64{b, a}, true
+	}
+	return [2]float64{a, b}, true
+}
+
+// ServeFakeRouting serves FakeRouting on addr, e.g. ":5001", with fixtures
+// of fixturesPath if it's given, until the server stops
+func ServeFakeRouting(addr string, fixturesPath string) error {
+	fake := NewFakeRouting()
+	if fixturesPath != "" {
+		if err := fake.LoadFixtures(fixturesPath); err != nil {
+			return err
+		}
+	}
+	log.Printf("[FakeRouting] serving OSRM, Google Directions & OTP on %s", addr)
+	return http.ListenAndServe(addr, fake)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFakeRouting(t *testing.T) {
+	fake := NewFakeRouting()
+	if err := fake.LoadFixtures("testdata/routes.json"); err != nil {
+		t.Fatal("LoadFixtures failed: ", err)
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	osrm := &OSRMProvider{BaseURL: server.URL + "/route/v1", Timeout: time.Second}
+	google := &GoogleProvider{APIKey: "fake", BaseURL: server.URL + "/maps/api/directions/json", Timeout: time.Second}
+
+	// condo a to citi resort is recorded
+	req := RouteRequest{Mode: "car", From: placeCoords("condo a"), To: placeCoords("citi resort"), Departure: time.Now()}
+	for _, provider := range []RoutingProvider{osrm, google} {
+		route, err := provider.Route(context.Background(), req)
+		if err != nil || route.Distance != 1850 || route.Duration != 420 {
+			t.Errorf("%s: expect the recorded route, got %v %v", provider.Name(), route, err)
+		}
+	}
+	if route, _ := google.Route(context.Background(), req); route.DurationInTraffic != 600 {
+		t.Errorf("expect recorded traffic, got %v", route)
+	}
+
+	// the way back isn't, it's a straight line at the speed of the mode
+	back := RouteRequest{Mode: "walk", From: req.To, To: req.From}
+	route, err := osrm.Route(context.Background(), back)
+	distance := pointDistance(back.From, back.To)
+	if err != nil || route.Distance != distance || route.Duration != distance/fakeSpeeds["walk"] {
+		t.Errorf("expect straight walk of %.0f m, got %v %v", distance, route, err)
+	}
+	if fake.Requests() != 4 {
+		t.Errorf("expect 4 requests, got %d", fake.Requests())
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FareRates are what a ride costs, in the currency of FareTable
+type FareRates struct {
+	Base  float64 `toml:"base"`
+	PerKm float64 `toml:"per_km"`
+	// PerMinute is charged for travel time in traffic
+	PerMinute         float64 `toml:"per_minute"`
+	PerExtraPassenger float64 `toml:"per_extra_passenger"`
+	Minimum           float64 `toml:"minimum"`
+}
+
+// fields returns rates by their keys in the fares file
+func (r *FareRates) fields() map[string]*float64 {
+	return map[string]*float64{
+		"base":                &r.Base,
+		"per_km":              &r.PerKm,
+		"per_minute":          &r.PerMinute,
+		"per_extra_passenger": &r.PerExtraPassenger,
+		"minimum":             &r.Minimum,
+	}
+}
+
+// FareMultiplier raises fares of pickups in Hours on Days, in Zone if
+// it's given
+type FareMultiplier struct {
+	Name   string  `toml:"name"`
+	Hours  string  `toml:"hours"`
+	Days   string  `toml:"days"`
+	Zone   string  `toml:"zone"`
+	Factor float64 `toml:"factor"`
+	// window is Hours & Days parsed, see Zone.OpenAt
+	window Zone
+}
+
+// FareTable is the fares file: default rates, multipliers and rates of
+// zones, which fall back to the default ones
+type FareTable struct {
+	Currency string `toml:"currency"`
+	FareRates
+	Multipliers []FareMultiplier     `toml:"multipliers"`
+	Zones       map[string]FareRates `toml:"zones"`
+}
+
+// FareEstimate is the fare of a trip and how it came out
+type FareEstimate struct {
+	Amount   float64
+	Currency string
+	// Zone is the zone whose rates are used, empty for the default ones
+	Zone       string
+	Multiplier float64
+}
+
+// LoadFares reads a fares file. Zones must be in area unless it's nil.
+func LoadFares(path string, area *ServiceArea) (*FareTable, error) {
+	var t FareTable
+	md, err := toml.DecodeFile(path, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Currency == "" {
+		return nil, fmt.Errorf("%s: currency is required", path)
+	}
+	for key, rate := range t.fields() {
+		if *rate < 0 {
+			return nil, fmt.Errorf("%s: %s must not be negative", path, key)
+		}
+	}
+	for name, rates := range t.Zones {
+		if area != nil && !area.HasZone(name) {
+			return nil, fmt.Errorf("%s: zone %q is not in the service area", path, name)
+		}
+		zoneRates := rates.fields()
+		for key, rate := range t.fields() {
+			if !md.IsDefined("zones", name, key) {
+				*zoneRates[key] = *rate
+			} else if *zoneRates[key] < 0 {
+				return nil, fmt.Errorf("%s: %s %s must not be negative", path, name, key)
+			}
+		}
+		t.Zones[name] = rates
+	}
+	for i := range t.Multipliers {
+		m := &t.Multipliers[i]
+		if m.Factor <= 0 {
+			return nil, fmt.Errorf("%s: multiplier %q needs a positive factor", path, m.Name)
+		}
+		if m.Hours != "" {
+			if m.window.Open, m.window.Close, err = parseHours(m.Hours); err != nil {
+				return nil, fmt.Errorf("%s: multiplier %q %v", path, m.Name, err)
+			}
+		}
+		if m.Days != "" {
+			if m.window.Days, err = ParseWeekdays(m.Days); err != nil {
+				return nil, fmt.Errorf("%s: multiplier %q days: %v", path, m.Name, err)
+			}
+		}
+		if m.Zone != "" && area != nil && !area.HasZone(m.Zone) {
+			return nil, fmt.Errorf("%s: multiplier %q zone %q is not in the service area", path, m.Name, m.Zone)
+		}
+	}
+	return &t, nil
+}
+
+// Estimate prices the car route of rec picked up in zone (may be empty).
+// Pickup time is rec.ReservedAt, or now if it's not set yet.
+func (t *FareTable) Estimate(route *Route, rec *ReservationRecord, zone string) FareEstimate {
+	rates, ok := t.Zones[zone]
+	if !ok {
+		rates, zone = t.FareRates, ""
+	}
+	pickupAt := rec.ReservedAt
+	if pickupAt.IsZero() {
+		pickupAt = time.Now()
+	}
+	multiplier := 1.0
+	for _, m := range t.Multipliers {
+		if m.Zone != "" && m.Zone != zone {
+			continue
+		}
+		if m.window.OpenAt(pickupAt) && m.Factor > multiplier {
+			multiplier = m.Factor
+		}
+	}
+
+	amount := rates.Base +
+		rates.PerKm*route.Distance/1000 +
+		rates.PerMinute*route.TravelTime()/60
+	if rec.NumOfPassengers > 1 {
+		amount += rates.PerExtraPassenger * float64(rec.NumOfPassengers-1)
+	}
+	amount = math.Ceil(amount * multiplier)
+	if amount < rates.Minimum {
+		amount = rates.Minimum
+	}
+	return FareEstimate{Amount: amount, Currency: t.Currency, Zone: zone, Multiplier: multiplier}
+}
+
+// FareText is like "85 THB"
+func FareText(amount float64, currency string) string {
+	return fmt.Sprintf("%.0f %s", amount, currency)
+}
+
+var (
+	faresOnce sync.Once
+	fares     *FareTable
+)
+
+// CurrentFares returns the fare table of FARES_FILE (fares.toml by default),
+// loaded on first use
+func CurrentFares() *FareTable {
+	faresOnce.Do(func() {
+		path := os.Getenv("FARES_FILE")
+		if path == "" {
+			path = "fares.toml"
+		}
+		t, err := LoadFares(path, CurrentServiceArea())
+		if err != nil {
+			log.Fatalf("[Fares] %v", err)
+		}
+		fares = t
+	})
+	return fares
+}
+
+// EstimateFare prices the car route of rec with the rates of its pickup zone
+func (app *HailingApp) EstimateFare(route *Route, rec *ReservationRecord) FareEstimate {
+	zone := ""
+	if z := CurrentServiceArea().ZoneAt(rec.FromCoords); z != nil {
+		zone = z.Name
+	}
+	return CurrentFares().Estimate(route, rec, zone)
+}
+
+// applyRoute keeps the car route of rec and its fare on rec, and starts
+// drawing its map for the trip cards
+func (app *HailingApp) applyRoute(rec *ReservationRecord, route *Route) {
+	rec.Polyline = route.Geometry
+	rec.TravelTime = route.TravelTime()
+	estimate := app.EstimateFare(route, rec)
+	log.Printf("[Fare] %s: %+v", rec.LineUserID, estimate)
+	rec.Fare = estimate.Amount
+	app.PrepareRouteMap(rec)
+}
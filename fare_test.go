@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFareTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fares")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fares.toml")
+	load := func(content string) (*FareTable, error) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadFares(path, CurrentServiceArea())
+	}
+
+	table, err := load(`
+currency = "THB"
+base = 35.0
+per_km = 6.5
+per_minute = 2.0
+per_extra_passenger = 10.0
+minimum = 40.0
+
+[[multipliers]]
+hours = "07:00-09:30"
+days = "weekdays"
+factor = 1.2
+
+[[multipliers]]
+hours = "08:00-09:00"
+zone = "Phrom Phong"
+factor = 1.5
+
+[zones."Phrom Phong"]
+base = 30.0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates := table.Zones["Phrom Phong"]; rates.Base != 30 || rates.PerKm != 6.5 || rates.Minimum != 40 {
+		t.Errorf("zone rates must fall back to the default ones: %+v", rates)
+	}
+
+	monday := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", "2020-06-01 "+clock, bangkok)
+		return t
+	}
+	route := &Route{Distance: 2000, Duration: 300, DurationInTraffic: 600}
+	tests := []struct {
+		name       string
+		route      *Route
+		passengers int
+		at         time.Time
+		zone       string
+		amount     float64
+		multiplier float64
+	}{
+		// 35 + 6.5*2 + 2*10 = 68
+		{"default", route, 1, monday("12:00"), "", 68, 1},
+		{"passengers", route, 3, monday("12:00"), "", 88, 1},
+		{"rush-hour", route, 1, monday("07:30"), "", 82, 1.2},
+		{"zone", route, 1, monday("12:00"), "Phrom Phong", 63, 1},
+		{"zone-rush-hour", route, 1, monday("08:15"), "Phrom Phong", 95, 1.5},
+		{"unknown-zone", route, 1, monday("12:00"), "Asok", 68, 1},
+		{"minimum", &Route{Distance: 100, Duration: 30}, 1, monday("12:00"), "", 40, 1},
+	}
+	for _, tc := range tests {
+		rec := &ReservationRecord{NumOfPassengers: tc.passengers, ReservedAt: tc.at}
+		got := table.Estimate(tc.route, rec, tc.zone)
+		if got.Amount != tc.amount || got.Multiplier != tc.multiplier || got.Currency != "THB" {
+			t.Errorf("%s: expect %.0f x%.1f, got %+v", tc.name, tc.amount, tc.multiplier, got)
+		}
+	}
+
+	invalid := map[string]string{
+		"no-currency":   `base = 35.0`,
+		"negative":      "currency = \"THB\"\nper_km = -1.0",
+		"unknown-zone":  "currency = \"THB\"\n[zones.Asok]\nbase = 1.0",
+		"bad-hours":     "currency = \"THB\"\n[[multipliers]]\nhours = \"7-9\"\nfactor = 1.2",
+		"no-factor":     "currency = \"THB\"\n[[multipliers]]\nhours = \"07:00-09:00\"",
+		"bad-days":      "currency = \"THB\"\n[[multipliers]]\ndays = \"someday\"\nfactor = 1.2",
+		"negative-zone": "currency = \"THB\"\n[zones.\"Phrom Phong\"]\nbase = -1.0",
+	}
+	for name, content := range invalid {
+		if _, err := load(content); err == nil {
+			t.Errorf("%s must fail", name)
+		}
+	}
+}
+
+func TestFareOnConfirmation(t *testing.T) {
+	fake := NewFakeRouting()
+	if err := fake.LoadFixtures("testdata/routes.json"); err != nil {
+		t.Fatal(err)
+	}
+	osrm := httptest.NewServer(fake)
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	app.routing = NewRoutingProvider(app.sessions)
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+		{Name: "from", Text: "condo a", Expect: "When?"},
+		{Name: "when", Text: "now", Expect: "How many passengers?"},
+	})
+	replies := fakeLineStep{Text: "2"}.Run(fl, app, rider)
+	rec, _ := app.FindRecord(rider)
+	// Phrom Phong: 30 + 6.5*1.85 + 2*10 + 10, before any multiplier
+	if rec.Fare < 73 {
+		t.Fatalf("expect the fare of the fixture route, got %v", rec.Fare)
+	}
+	fare := FareText(rec.Fare, "THB")
+	shown := false
+	for _, msg := range replies {
+		shown = shown || strings.Contains(string(msg.Contents), fare)
+	}
+	if !shown {
+		t.Errorf("expect %q on the confirmation card", fare)
+	}
+
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+	active, err := fl.Store.FindActiveReservation(rider)
+	if err != nil {
+		t.Fatal("trip not found: ", err)
+	}
+	if active.Fare != rec.Fare {
+		t.Errorf("expect fare %v on the trip, got %v", rec.Fare, active.Fare)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// ReservationState is a step of the reservation conversation
+type ReservationState string
+
+// All states of reservation conversation in the order they are asked
+const (
+	StateInit            ReservationState = "init"
+	StateTo              ReservationState = "to"
+	StateFrom            ReservationState = "from"
+	StateWhen            ReservationState = "when"
+	StateNumOfPassengers ReservationState = "num_of_passengers"
+	StateFinal           ReservationState = "final"
+	StateDone            ReservationState = "done"
+	StatePickup          ReservationState = "pickup"
+)
+
+// StateDef describes a state: what to ask, how to take the answer and
+// where the conversation may go afterward
+type StateDef struct {
+	State ReservationState
+	// Label is shown on the diagram
+	Label string
+	// Filled reports whether the record already has the answer of this
+	// state. States without Filled are never asked automatically.
+	Filled func(rec *ReservationRecord) bool
+	// Apply validates reply and saves it to the record. nil means this
+	// state doesn't take any answer.
+	Apply func(app *HailingApp, rec *ReservationRecord, reply Reply) error
+	// Question builds the question while waiting for this state
+	Question func(app *HailingApp, rec *ReservationRecord, localizer *i18n.Localizer) Question
+	// Next lists states which are allowed to follow this one
+	Next []ReservationState
+}
+
+// StateMachine is a declarative definition of a conversation
+type StateMachine struct {
+	// Complete is the state a record goes to when nothing is missing,
+	// and Waiting is what it waits for afterward
+	Complete ReservationState
+	Waiting  ReservationState
+	order    []ReservationState
+	states   map[ReservationState]*StateDef
+}
+
+// TransitionError is returned when the conversation is asked to move
+// between two states which aren't connected
+type TransitionError struct {
+	From ReservationState
+	To   ReservationState
+}
+
+func (e *TransitionError) Error() string {
+	if e.To == "" {
+		return fmt.Sprintf("Wrong state: %q doesn't take any answer", e.From)
+	}
+	return fmt.Sprintf("Wrong state: cannot go from %q to %q", e.From, e.To)
+}
+
+// ValidationError is returned when the answer isn't valid for the state.
+// Its message is the one of Err so it can be shown to user as is.
+type ValidationError struct {
+	State ReservationState
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// NewStateMachine returns StateMachine of defs; the order of defs is the
+// order the missing answers are asked
+func NewStateMachine(complete ReservationState, waiting ReservationState, defs ...StateDef) *StateMachine {
+	m := &StateMachine{
+		Complete: complete,
+		Waiting:  waiting,
+		states:   map[ReservationState]*StateDef{},
+	}
+	for i := range defs {
+		def := defs[i]
+		m.order = append(m.order, def.State)
+		m.states[def.State] = &def
+	}
+	return m
+}
+
+// Get returns definition of the state or nil if there is no such state
+func (m *StateMachine) Get(state ReservationState) *StateDef {
+	return m.states[state]
+}
+
+// States returns all states in order
+func (m *StateMachine) States() []ReservationState {
+	return append([]ReservationState{}, m.order...)
+}
+
+// CanTransition reports whether from -> to is one of the transitions
+func (m *StateMachine) CanTransition(from ReservationState, to ReservationState) bool {
+	def := m.states[from]
+	if def == nil {
+		return false
+	}
+	for _, next := range def.Next {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Missing returns the first state which isn't answered yet
+// or "" if the record is complete
+func (m *StateMachine) Missing(rec *ReservationRecord) ReservationState {
+	for _, state := range m.order {
+		def := m.states[state]
+		if def.Filled != nil && !def.Filled(rec) {
+			return state
+		}
+	}
+	return ""
+}
+
+// IsComplete reports whether every state is answered
+// and which one is missing (Complete state if nothing)
+func (m *StateMachine) IsComplete(rec *ReservationRecord) (bool, ReservationState) {
+	missing := m.Missing(rec)
+	if missing == "" {
+		return true, m.Complete
+	}
+	return false, missing
+}
+
+// Transition moves rec to wait for the given state
+func (m *StateMachine) Transition(rec *ReservationRecord, to ReservationState) error {
+	if !m.CanTransition(rec.Waiting, to) {
+		return &TransitionError{From: rec.Waiting, To: to}
+	}
+	rec.State = rec.Waiting
+	rec.Waiting = to
+	return nil
+}
+
+// Rewind moves rec back to an answered state so it can be asked again.
+// Other answers are kept.
+func (m *StateMachine) Rewind(rec *ReservationRecord, to ReservationState) error {
+	def := m.states[to]
+	if def == nil || def.Filled == nil || def.Apply == nil || to == rec.Waiting {
+		return &TransitionError{From: rec.Waiting, To: to}
+	}
+	return m.Transition(rec, to)
+}
+
+// canSkipTo reports whether to can be reached from from by skipping only
+// states which are already answered
+func (m *StateMachine) canSkipTo(rec *ReservationRecord, from ReservationState, to ReservationState) bool {
+	visited := map[ReservationState]bool{from: true}
+	queue := []ReservationState{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, next := range m.states[state].Next {
+			if next == to {
+				return true
+			}
+			def := m.states[next]
+			if visited[next] || def == nil || def.Filled == nil || !def.Filled(rec) {
+				continue
+			}
+			visited[next] = true
+			queue = append(queue, next)
+		}
+	}
+	return false
+}
+
+// Advance moves rec from the answered state to the next missing one,
+// skipping states which are already answered. When nothing is missing,
+// rec becomes Complete and waits for Waiting.
+func (m *StateMachine) Advance(rec *ReservationRecord) error {
+	from := rec.Waiting
+	next := m.Missing(rec)
+	if next == "" {
+		next = m.Complete
+	}
+	if m.states[from] == nil || !m.canSkipTo(rec, from, next) {
+		return &TransitionError{From: from, To: next}
+	}
+	rec.State = from
+	rec.Waiting = next
+	if next == m.Complete {
+		rec.State = m.Complete
+		rec.Waiting = m.Waiting
+	}
+	return nil
+}
+
+// Fire applies reply to the state rec is waiting for, then advances it
+func (m *StateMachine) Fire(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	def := m.states[rec.Waiting]
+	if def == nil || def.Apply == nil {
+		return &TransitionError{From: rec.Waiting}
+	}
+	if err := def.Apply(app, rec, reply); err != nil {
+		switch err.(type) {
+		case *ValidationError, *TransitionError:
+			return err
+		}
+		return &ValidationError{State: rec.Waiting, Err: err}
+	}
+	return m.Advance(rec)
+}
+
+// Question returns what to ask while rec is waiting
+func (m *StateMachine) Question(app *HailingApp, rec *ReservationRecord, localizer *i18n.Localizer) Question {
+	def := m.states[rec.Waiting]
+	if def == nil || def.Question == nil {
+		return Question{Text: "n/a"}
+	}
+	return def.Question(app, rec, localizer)
+}
+
+// edges returns all transitions in stable order
+func (m *StateMachine) edges() [][2]ReservationState {
+	results := [][2]ReservationState{}
+	for _, state := range m.order {
+		next := append([]ReservationState{}, m.states[state].Next...)
+		sort.SliceStable(next, func(i, j int) bool {
+			return m.index(next[i]) < m.index(next[j])
+		})
+		for _, to := range next {
+			results = append(results, [2]ReservationState{state, to})
+		}
+	}
+	return results
+}
+
+func (m *StateMachine) index(state ReservationState) int {
+	for i, s := range m.order {
+		if s == state {
+			return i
+		}
+	}
+	return len(m.order)
+}
+
+func (m *StateMachine) label(state ReservationState) string {
+	if def := m.states[state]; def != nil && def.Label != "" {
+		return def.Label
+	}
+	return string(state)
+}
+
+// Dot returns the diagram in Graphviz format
+func (m *StateMachine) Dot() string {
+	var b strings.Builder
+	b.WriteString("digraph reservation {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, state := range m.order {
+		shape := "box"
+		if len(m.states[state].Next) == 0 {
+			shape = "doublecircle"
+		}
+		fmt.Fprintf(&b, "  %s [label=%q shape=%s];\n", state, m.label(state), shape)
+	}
+	for _, e := range m.edges() {
+		fmt.Fprintf(&b, "  %s -> %s;\n", e[0], e[1])
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns the diagram in Mermaid format
+func (m *StateMachine) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	for _, state := range m.order {
+		fmt.Fprintf(&b, "  %s : %s\n", state, m.label(state))
+	}
+	if len(m.order) > 0 {
+		fmt.Fprintf(&b, "  [*] --> %s\n", m.order[0])
+	}
+	for _, e := range m.edges() {
+		fmt.Fprintf(&b, "  %s --> %s\n", e[0], e[1])
+	}
+	for _, state := range m.order {
+		if len(m.states[state].Next) == 0 {
+			fmt.Fprintf(&b, "  %s --> [*]\n", state)
+		}
+	}
+	return b.String()
+}
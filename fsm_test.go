@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReservationFSMAdvance(t *testing.T) {
+	rec := &ReservationRecord{State: StateInit, Waiting: StateTo, TripID: -1}
+	if err := reservationFSM.Transition(rec, StateFinal); err == nil {
+		t.Error("to -> final must not be allowed")
+	} else if _, ok := err.(*TransitionError); !ok {
+		t.Errorf("expect TransitionError: %v", err)
+	}
+
+	rec.To = "Citi Resort"
+	if err := reservationFSM.Advance(rec); err != nil || rec.Waiting != StateFrom {
+		t.Errorf("expect waiting for from: %v %v", rec.Waiting, err)
+	}
+	rec.From = "BTS Phromphong"
+	rec.ReservedAt = time.Now()
+	reservationFSM.Advance(rec)
+	rec.NumOfPassengers = 2
+	reservationFSM.Advance(rec)
+	if rec.State != StateNumOfPassengers || rec.Waiting != StateFinal {
+		t.Errorf("expect waiting for final: %v -> %v", rec.State, rec.Waiting)
+	}
+
+	// anything but confirmation keeps it waiting
+	err := reservationFSM.Fire(nil, rec, Reply{Text: "hmm"})
+	if _, ok := err.(*ValidationError); !ok || rec.Waiting != StateFinal {
+		t.Errorf("expect ValidationError in final: %v %v", rec.Waiting, err)
+	}
+	if err := reservationFSM.Fire(nil, rec, Reply{Text: "confirm"}); err != nil {
+		t.Fatal("confirm failed: ", err)
+	}
+	if rec.State != StateDone || rec.Waiting != StatePickup {
+		t.Errorf("expect done & waiting for pickup: %v -> %v", rec.State, rec.Waiting)
+	}
+
+	err = reservationFSM.Fire(nil, rec, Reply{Text: "Citi Resort"})
+	if _, ok := err.(*TransitionError); !ok {
+		t.Errorf("pickup only takes modify-pickup-time: %v", err)
+	}
+	pickupAt := time.Now().Add(time.Hour)
+	app := &HailingApp{bookingHorizon: 24 * time.Hour}
+	err = reservationFSM.Fire(app, rec, Reply{Text: "modify-pickup-time", Datetime: pickupAt})
+	if err != nil || !rec.ReservedAt.Equal(pickupAt) || rec.State != StateDone {
+		t.Errorf("modify pickup time failed: %v %v", rec.ReservedAt, err)
+	}
+}
+
+func TestReservationFSMDiagram(t *testing.T) {
+	dot := reservationFSM.Dot()
+	for _, edge := range []string{"init -> to;", "num_of_passengers -> final;", "done -> pickup;"} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("%q is missing from dot output", edge)
+		}
+	}
+	mermaid := reservationFSM.Mermaid()
+	if !strings.HasPrefix(mermaid, "stateDiagram-v2\n") || !strings.Contains(mermaid, "[*] --> init") {
+		t.Errorf("unexpected mermaid output: %s", mermaid)
+	}
+}
+
+func TestReservationFSMRewind(t *testing.T) {
+	rec := &ReservationRecord{
+		State:           StateNumOfPassengers,
+		Waiting:         StateFinal,
+		To:              "Citi Resort",
+		From:            "BTS Phromphong",
+		ReservedAt:      time.Now(),
+		NumOfPassengers: 2,
+	}
+	for _, state := range []ReservationState{StateDone, StateFinal, StateInit} {
+		if err := reservationFSM.Rewind(rec, state); err == nil {
+			t.Errorf("rewind to %v must not be allowed", state)
+		}
+	}
+	if err := reservationFSM.Rewind(rec, StateTo); err != nil || rec.Waiting != StateTo {
+		t.Fatalf("rewind to 'to' failed: %v %v", rec.Waiting, err)
+	}
+	// the new answer goes straight back to confirmation
+	rec.To = "Condo A"
+	if err := reservationFSM.Advance(rec); err != nil || rec.Waiting != StateFinal {
+		t.Errorf("expect back to final: %v %v", rec.Waiting, err)
+	}
+	if rec.From != "BTS Phromphong" || rec.NumOfPassengers != 2 {
+		t.Errorf("other answers must be kept: %v", rec)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReverseGeocoder names a point so drivers & trip history see an address
+// instead of "custom"
+type ReverseGeocoder interface {
+	ReverseGeocode(coords [2]float64) (string, error)
+}
+
+// LocationGeocoder names a point after the nearest location of the
+// location table within Radius (m), e.g. "350 m from citi resort"
+type LocationGeocoder struct {
+	Locations LocationStore
+	Radius    float64
+}
+
+// ReverseGeocode returns ErrNotFound if no location is close enough
+func (g *LocationGeocoder) ReverseGeocode(coords [2]float64) (string, error) {
+	loc, distance, err := g.Locations.NearestLocation(coords, g.Radius)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s from %s", DistanceText(distance), loc.Name), nil
+}
+
+// NominatimGeocoder asks a Nominatim-compatible server, e.g.
+// https://nominatim.openstreetmap.org, for the address
+type NominatimGeocoder struct {
+	BaseURL string
+	// Language is accept-language, e.g. "th,en"
+	Language  string
+	UserAgent string
+	Client    *http.Client
+}
+
+type nominatimResp struct {
+	DisplayName string `json:"display_name"`
+	Error       string `json:"error"`
+}
+
+// ReverseGeocode returns the first few parts of the address, which is
+// enough to find the place within a city
+func (g *NominatimGeocoder) ReverseGeocode(coords [2]float64) (string, error) {
+	params := url.Values{}
+	params.Set("format", "jsonv2")
+	params.Set("lon", fmt.Sprintf("%.7f", coords[0]))
+	params.Set("lat", fmt.Sprintf("%.7f", coords[1]))
+	params.Set("zoom", "18")
+	if g.Language != "" {
+		params.Set("accept-language", g.Language)
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(g.BaseURL, "/")+"/reverse?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	// Nominatim usage policy asks for an identifying user agent
+	req.Header.Set("User-Agent", g.UserAgent)
+	httpResp, err := g.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nominatim: %s", httpResp.Status)
+	}
+	var resp nominatimResp
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("nominatim: %s", resp.Error)
+	}
+	parts := strings.Split(resp.DisplayName, ", ")
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	address := strings.Join(parts, ", ")
+	if address == "" {
+		return "", errors.New("nominatim: no address")
+	}
+	return address, nil
+}
+
+// GeocoderChain tries each geocoder in turn until one names the point
+type GeocoderChain []ReverseGeocoder
+
+// ReverseGeocode returns the error of the last geocoder if none works
+func (chain GeocoderChain) ReverseGeocode(coords [2]float64) (string, error) {
+	err := errors.New("no geocoder")
+	for _, g := range chain {
+		var name string
+		name, err = g.ReverseGeocode(coords)
+		if err == nil {
+			return name, nil
+		}
+		log.Printf("[ReverseGeocode] %T %v: %v", g, coords, err)
+	}
+	return "", err
+}
+
+// NewReverseGeocoder returns the geocoder of GEOCODER_URL, if it's set,
+// falling back to the location table within GEOCODER_RADIUS (default 1 km)
+func NewReverseGeocoder(locations LocationStore) ReverseGeocoder {
+	offline := &LocationGeocoder{Locations: locations, Radius: envFloat("GEOCODER_RADIUS", 1000)}
+	baseURL := os.Getenv("GEOCODER_URL")
+	if baseURL == "" {
+		return offline
+	}
+	language := os.Getenv("GEOCODER_LANGUAGE")
+	if language == "" {
+		language = "th,en"
+	}
+	return GeocoderChain{
+		&NominatimGeocoder{
+			BaseURL:   baseURL,
+			Language:  language,
+			UserAgent: "hailing-bot",
+			Client:    &http.Client{Timeout: envDuration("GEOCODER_TIMEOUT", 3*time.Second)},
+		},
+		offline,
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReverseGeocoder(t *testing.T) {
+	store := NewMemoryStore()
+	store.SeedLocations()
+	offline := &LocationGeocoder{Locations: store, Radius: 1000}
+
+	// about 110 m east of condo a
+	near := [2]float64{100.5633, 13.7349}
+	if name, err := offline.ReverseGeocode(near); err != nil || name != "108 m from condo a" {
+		t.Errorf("expect 108 m from condo a, got %q %v", name, err)
+	}
+	if _, err := offline.ReverseGeocode([2]float64{100.60, 13.70}); err != ErrNotFound {
+		t.Errorf("expect nothing nearby, got %v", err)
+	}
+
+	failing := false
+	nominatim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/reverse" || r.Header.Get("User-Agent") == "" || q.Get("lat") != "13.7349000" || q.Get("lon") != "100.5633000" {
+			t.Errorf("unexpected request: %v %v", r.URL, r.Header)
+		}
+		if failing {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"display_name": "Sukhumvit 24, Khlong Tan, Khlong Toei, Bangkok, 10110, Thailand"}`)
+	}))
+	defer nominatim.Close()
+	chain := GeocoderChain{
+		&NominatimGeocoder{BaseURL: nominatim.URL + "/", UserAgent: "test", Client: &http.Client{Timeout: time.Second}},
+		offline,
+	}
+	if name, _ := chain.ReverseGeocode(near); name != "Sukhumvit 24, Khlong Tan, Khlong Toei" {
+		t.Errorf("expect address from nominatim, got %q", name)
+	}
+	failing = true
+	if name, _ := chain.ReverseGeocode(near); name != "108 m from condo a" {
+		t.Errorf("expect location table when nominatim fails, got %q", name)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Intent is what a free-text message asks for
+type Intent string
+
+// Intents of free text, in the order they win when a message has cues of
+// several ones, e.g. "change time of my taxi" is change_time, not book
+const (
+	IntentNone       Intent = ""
+	IntentChangeTime Intent = "change_time"
+	IntentCancel     Intent = "cancel"
+	IntentStatus     Intent = "status"
+	IntentHelp       Intent = "help"
+	IntentBook       Intent = "book"
+)
+
+var intentOrder = []Intent{IntentChangeTime, IntentCancel, IntentStatus, IntentHelp, IntentBook}
+
+func intentNames() []string {
+	names := []string{}
+	for _, intent := range intentOrder {
+		names = append(names, string(intent))
+	}
+	return names
+}
+
+// Utterance is the intent of a message with slots it fills
+type Utterance struct {
+	Intent Intent
+	// Destination & Origin are place names as typed, to be matched later
+	Destination string
+	Origin      string
+	// When is pickup time as typed, e.g. "now" or "tomorrow 7am"
+	When       string
+	Passengers int
+}
+
+func (u Utterance) String() string {
+	return fmt.Sprintf("%s{to: %q, from: %q, when: %q, passengers: %d}",
+		u.Intent, u.Destination, u.Origin, u.When, u.Passengers)
+}
+
+// slot patterns are case-insensitive. Place markers come before the place
+// in English & Thai ("to X", "ไปX") but after it in Japanese ("Xまで").
+var (
+	placeMarkerPattern = regexp.MustCompile(`(?i)\b(?:to|from)\b|ไป|จาก|まで|から|へ`)
+	placeMarkers       = map[string]struct {
+		destination bool
+		after       bool
+	}{
+		"to":   {destination: true},
+		"from": {},
+		"ไป":   {destination: true},
+		"จาก":  {},
+		"まで":   {destination: true, after: true},
+		"へ":    {destination: true, after: true},
+		"から":   {after: true},
+	}
+	passengerPattern = regexp.MustCompile(`(?i)(\d+)\s*(?:people|persons?|passengers?|pax|คน|人|名)`)
+	// fillers are trimmed off the start or the end of a place name
+	leadingFillers = []string{
+		"please", "pls", "i", "we", "need", "want", "would like", "a", "an",
+		"the", "my", "me", "us", "go", "get", "take", "call",
+		"ขอ", "อยาก", "ที่", "で",
+	}
+	trailingFillers = []string{
+		"please", "pls", "for", "หน่อย", "ครับ", "ค่ะ", "คะ", "นะ", "ด้วย",
+		"ください", "お願いします", "おねがいします", "行きたい", "行って",
+	}
+)
+
+// ParseUtterance finds the intent & slots of text with cues of words. It
+// returns IntentNone for a plain answer such as "citi resort" or "2", so
+// the current question takes it as usual.
+func ParseUtterance(text string, words *Words) Utterance {
+	u := Utterance{}
+	rest := text
+	if m := passengerPattern.FindStringSubmatchIndex(rest); m != nil {
+		u.Passengers, _ = strconv.Atoi(rest[m[2]:m[3]])
+		rest = rest[:m[0]] + " " + rest[m[1]:]
+	}
+	if _, spans, ok := findTime(rest, time.Now()); ok {
+		parts := []string{}
+		for i := len(spans) - 1; i >= 0; i-- {
+			span := spans[i]
+			parts = append([]string{strings.TrimSpace(rest[span[0]:span[1]])}, parts...)
+			rest = rest[:span[0]] + " " + rest[span[1]:]
+		}
+		u.When = strings.Join(parts, " ")
+	}
+
+	allCues := []string{}
+	for _, intent := range intentOrder {
+		for _, cues := range words.Intents[intent] {
+			allCues = append(allCues, cues...)
+		}
+	}
+	leading := append(allCues, leadingFillers...)
+	trailing := append(allCues[:len(allCues):len(allCues)], trailingFillers...)
+
+	// text between markers, e.g. "from condo a to citi resort"
+	marked := false
+	markers := placeMarkerPattern.FindAllStringIndex(rest, -1)
+	for i, m := range markers {
+		marker := placeMarkers[strings.ToLower(rest[m[0]:m[1]])]
+		var phrase string
+		if marker.after {
+			start := 0
+			if i > 0 {
+				start = markers[i-1][1]
+			}
+			phrase = rest[start:m[0]]
+		} else {
+			end := len(rest)
+			if i+1 < len(markers) {
+				end = markers[i+1][0]
+			}
+			phrase = rest[m[1]:end]
+		}
+		phrase = trimWords(phrase, leading, trailing)
+		if phrase == "" {
+			continue
+		}
+		marked = true
+		if marker.destination {
+			u.Destination = phrase
+		} else {
+			u.Origin = phrase
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, intent := range intentOrder {
+		for _, cues := range words.Intents[intent] {
+			for _, cue := range cues {
+				if containsWord(lower, strings.ToLower(cue)) {
+					u.Intent = intent
+					break
+				}
+			}
+			if u.Intent != IntentNone {
+				break
+			}
+		}
+		if u.Intent != IntentNone {
+			break
+		}
+	}
+	if u.Intent == IntentNone && marked {
+		// "to citi resort" is clear enough
+		u.Intent = IntentBook
+	}
+	if u.Intent == IntentBook && !marked {
+		// whatever is left could be the destination, e.g. "taxi citi resort"
+		u.Destination = trimWords(rest, leading, trailing)
+	}
+	return u
+}
+
+// isASCII reports whether s is plain ASCII, i.e. words are separated
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// containsWord reports whether text contains word; English words must not
+// be part of a longer word, e.g. "car" isn't in "scary"
+func containsWord(text string, word string) bool {
+	if !isASCII(word) {
+		return strings.Contains(text, word)
+	}
+	for from := 0; ; {
+		i := strings.Index(text[from:], word)
+		if i == -1 {
+			return false
+		}
+		start, end := from+i, from+i+len(word)
+		if (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end])) {
+			return true
+		}
+		from = start + 1
+	}
+}
+
+// trimWords trims leading & trailing words and punctuation off phrase
+func trimWords(phrase string, leading []string, trailing []string) string {
+	for {
+		phrase = strings.Trim(phrase, " \t,.!?、。")
+		trimmed := false
+		for _, w := range leading {
+			n := len(w)
+			if n > 0 && n <= len(phrase) && strings.EqualFold(phrase[:n], w) &&
+				(!isASCII(w) || n == len(phrase) || !isWordByte(phrase[n])) {
+				phrase = phrase[n:]
+				trimmed = true
+				break
+			}
+		}
+		for _, w := range trailing {
+			n := len(w)
+			if n > 0 && n <= len(phrase) && strings.EqualFold(phrase[len(phrase)-n:], w) &&
+				(!isASCII(w) || n == len(phrase) || !isWordByte(phrase[len(phrase)-n-1])) {
+				phrase = phrase[:len(phrase)-n]
+				trimmed = true
+				break
+			}
+		}
+		if !trimmed {
+			return phrase
+		}
+	}
+}
+
+// FillRecord pre-fills the rider's reservation with slots of u and moves it
+// on to the first missing answer. Slots which don't validate are left to
+// be asked. The error is AmbiguousPlaceError or TimeConfirmationError if the
+// rider has to pick or confirm the answer being asked for, or the first
+// slot which doesn't validate.
+func (app *HailingApp) FillRecord(lineUserID string, u Utterance) (*ReservationRecord, error) {
+	rec, old, err := app.findOrCreateRecord(lineUserID)
+	if err != nil {
+		return nil, errors.New("There is a problem")
+	}
+	if rec.IsConfirmed {
+		// a trip is on, there is nothing to fill
+		return rec, nil
+	}
+	slots := []struct {
+		state ReservationState
+		reply Reply
+	}{
+		{StateTo, Reply{Text: u.Destination}},
+		{StateFrom, Reply{Text: u.Origin}},
+		{StateWhen, Reply{Text: u.When}},
+		{StateNumOfPassengers, Reply{Text: strconv.Itoa(u.Passengers)}},
+	}
+	var firstErr error
+	// answers the rider has to pick or confirm, see ChoiceFor
+	choices := map[ReservationState]error{}
+	for _, slot := range slots {
+		if slot.reply.Text == "" || slot.reply.Text == "0" {
+			continue
+		}
+		if err := reservationFSM.Get(slot.state).Apply(app, rec, slot.reply); err != nil {
+			log.Printf("[FillRecord] %s %q: %v", slot.state, slot.reply.Text, err)
+			switch err.(type) {
+			case *AmbiguousPlaceError, *TimeConfirmationError:
+				choices[slot.state] = err
+			default:
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	if missing := reservationFSM.Missing(rec); missing != rec.Waiting {
+		if err := reservationFSM.Advance(rec); err != nil {
+			log.Printf("[FillRecord] advance: %v", err)
+		}
+	}
+	if rec.Waiting == StateFinal {
+		if err := app.checkTrip(rec); err != nil {
+			firstErr = err
+		}
+	}
+	if err := app.UpdateRecord(old, rec); err != nil {
+		return nil, err
+	}
+	if err, ok := choices[rec.Waiting]; ok {
+		return rec, err
+	}
+	return rec, firstErr
+}
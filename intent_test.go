@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseUtterance(t *testing.T) {
+	words := Vocabulary().Words()
+	tests := []struct {
+		text   string
+		expect Utterance
+	}{
+		{"I need a taxi to citi resort now", Utterance{Intent: IntentBook, Destination: "citi resort", When: "now"}},
+		{"ขอรถไปบีทีเอส", Utterance{Intent: IntentBook, Destination: "บีทีเอส"}},
+		{"ขอรถไปที่ซิตี้ รีสอร์ท อีก 15 นาที 3 คนครับ", Utterance{Intent: IntentBook, Destination: "ซิตี้ รีสอร์ท", When: "อีก 15 นาที", Passengers: 3}},
+		{"シティリゾートからBTSプロムポン駅まで2人", Utterance{Intent: IntentBook, Origin: "シティリゾート", Destination: "BTSプロムポン駅", Passengers: 2}},
+		{"タクシーでシティリゾートまでお願いします", Utterance{Intent: IntentBook, Destination: "シティリゾート"}},
+		{"Cab from condo a to Citi Resort in 20 minutes for 2 people please", Utterance{Intent: IntentBook, Origin: "condo a", Destination: "Citi Resort", When: "in 20 minutes", Passengers: 2}},
+		{"taxi citi resort", Utterance{Intent: IntentBook, Destination: "citi resort"}},
+		{"where is my taxi?", Utterance{Intent: IntentStatus}},
+		{"please cancel my ride", Utterance{Intent: IntentCancel}},
+		{"help", Utterance{Intent: IntentHelp}},
+		{"change time to +30min", Utterance{Intent: IntentChangeTime, When: "+30min"}},
+		{"เลื่อนเวลาเป็นอีก 1 ชั่วโมง", Utterance{Intent: IntentChangeTime, When: "อีก 1 ชั่วโมง"}},
+		// plain answers are left to the current question
+		{"citi resort", Utterance{}},
+		{"2", Utterance{}},
+		{"+15min", Utterance{When: "+15min"}},
+		{"tomorrow 7am", Utterance{When: "tomorrow 7am"}},
+		{"scary", Utterance{}},
+	}
+	for _, tc := range tests {
+		got := ParseUtterance(tc.text, words)
+		if got != tc.expect {
+			t.Errorf("%q: expect %v, got %v", tc.text, tc.expect, got)
+		}
+	}
+}
+
+func TestIntentConversation(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "book", Text: "I need a taxi to citi resort now", Expect: "Pickup location?"},
+		{Name: "from", Text: "condo a", Expect: "How many passengers?"},
+		{Name: "help", Text: "how to use this?", Expect: "Help"},
+	})
+	rec, _ := app.FindRecord(rider)
+	if rec.To != "citi resort" || rec.ToCoords != placeCoords("citi resort") || rec.From != "condo a" || rec.ReservedAt.IsZero() {
+		t.Errorf("unexpected record: %v", rec)
+	}
+
+	thai := "U" + uuid.New().String()
+	runConversation(t, fl, app, thai, []fakeLineStep{
+		{Name: "book", Text: "ขอรถไปบีทีเอส", Expect: "Pickup location?"},
+	})
+	rec, _ = app.FindRecord(thai)
+	if rec.To != "บีทีเอส พร้อมพงษ์" || rec.ToCoords != placeCoords("bts phromphong") {
+		t.Errorf("unexpected destination: %v", rec)
+	}
+
+	// everything at once goes straight to confirmation once the rider
+	// confirms pickup time in words
+	all := "U" + uuid.New().String()
+	replies := fakeLineStep{Text: "cab from condo a to bts phromphong in 30 min for 2 people"}.Run(fl, app, all)
+	when := confirmedTime(t, replies)
+	if d := time.Until(when); d < 29*time.Minute || d > 31*time.Minute {
+		t.Errorf("expect pickup in 30 minutes, got %v", when)
+	}
+	runConversation(t, fl, app, all, []fakeLineStep{
+		{Name: "confirm-time", Postback: fmt.Sprintf("when:%d", when.Unix()), Expect: "EstTravelTime"},
+		{Name: "change-time", Text: "change the time", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "EstTravelTime"},
+		{Name: "cancel", Text: "please cancel it"},
+	})
+	if _, err := app.FindRecord(all); err == nil {
+		t.Error("reservation must be cancelled")
+	}
+}
+
+// confirmedTime returns pickup time of PickupTimeConfirmDialog in replies
+func confirmedTime(t *testing.T, replies []fakeLineMessage) time.Time {
+	pattern := regexp.MustCompile(`"data":"when(?:-change)?:(\d+)"`)
+	for _, msg := range replies {
+		if m := pattern.FindStringSubmatch(string(msg.Contents)); m != nil {
+			sec, _ := strconv.ParseInt(m[1], 10, 64)
+			return time.Unix(sec, 0)
+		}
+	}
+	t.Fatalf("no pickup time to confirm in %v", replies)
+	return time.Time{}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"log"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// LineMessenger is Messenger for LINE messaging API
+type LineMessenger struct {
+	bot *linebot.Client
+}
+
+// NewLineMessenger wraps linebot.Client as Messenger
+func NewLineMessenger(bot *linebot.Client) *LineMessenger {
+	return &LineMessenger{bot: bot}
+}
+
+// Reply sends messages with reply token
+func (m *LineMessenger) Reply(replyToken string, messages ...Message) error {
+	if _, err := m.bot.ReplyMessage(
+		replyToken,
+		lineMessages(messages)...,
+	).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Push sends messages to LINE user ID
+func (m *LineMessenger) Push(userID string, messages ...Message) error {
+	if _, err := m.bot.PushMessage(userID, lineMessages(messages)...).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Profile returns LINE profile of the user
+func (m *LineMessenger) Profile(userID string) (*Profile, error) {
+	profile, err := m.bot.GetProfile(userID).Do()
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{
+		UserID:      profile.UserID,
+		DisplayName: profile.DisplayName,
+		PictureURL:  profile.PictureURL,
+	}, nil
+}
+
+func lineMessages(messages []Message) []linebot.SendingMessage {
+	results := []linebot.SendingMessage{}
+	for _, msg := range messages {
+		if one := lineMessage(msg); one != nil {
+			results = append(results, one)
+		}
+	}
+	return results
+}
+
+func lineMessage(msg Message) linebot.SendingMessage {
+	switch m := msg.(type) {
+	case TextMessage:
+		txt := linebot.NewTextMessage(m.Text)
+		if len(m.QuickReplies) == 0 {
+			return txt
+		}
+		items := make([]*linebot.QuickReplyButton, len(m.QuickReplies))
+		for i, btn := range m.QuickReplies {
+			items[i] = linebot.NewQuickReplyButton(btn.Image, lineQuickReplyAction(btn))
+		}
+		return txt.WithQuickReplies(linebot.NewQuickReplyItems(items...))
+	case ConfirmCard:
+		return lineConfirmCard(m)
+	case RichCard:
+		return linebot.NewFlexMessage(m.AltText, lineBubble(m))
+	case Carousel:
+		bubbles := make([]*linebot.BubbleContainer, len(m.Cards))
+		for i, card := range m.Cards {
+			bubbles[i] = lineBubble(card)
+		}
+		return linebot.NewFlexMessage(m.AltText, &linebot.CarouselContainer{
+			Type:     linebot.FlexContainerTypeCarousel,
+			Contents: bubbles,
+		})
+	case nil:
+		return nil
+	}
+	log.Printf("[LineMessenger] unknown message: %v", msg)
+	return nil
+}
+
+func lineQuickReplyAction(btn QuickReplyButton) linebot.QuickReplyAction {
+	switch btn.Type {
+	case ActionPostback:
+		return linebot.NewPostbackAction(btn.Label, btn.Data, "", btn.Text)
+	case ActionDatetime:
+		return linebot.NewDatetimePickerAction(btn.Label, btn.Data, "datetime", "", "", "")
+	case ActionLocation:
+		return linebot.NewLocationAction(btn.Label)
+	}
+	return linebot.NewMessageAction(btn.Label, btn.Text)
+}
+
+func lineTemplateAction(btn CardButton) linebot.TemplateAction {
+	switch btn.Action {
+	case ActionPostback:
+		return linebot.NewPostbackAction(btn.Label, btn.Data, "", btn.Text)
+	case ActionDatetime:
+		return linebot.NewDatetimePickerAction(btn.Label, btn.Data, "datetime", "", "", "")
+	}
+	return linebot.NewMessageAction(btn.Label, btn.Text)
+}
+
+func lineButton(btn CardButton) *linebot.ButtonComponent {
+	style := linebot.FlexButtonStyleTypeLink
+	height := linebot.FlexButtonHeightTypeSm
+	switch btn.Style {
+	case ButtonPrimary:
+		style = linebot.FlexButtonStyleTypePrimary
+		height = linebot.FlexButtonHeightTypeMd
+	case ButtonSecondary:
+		style = linebot.FlexButtonStyleTypeSecondary
+		height = linebot.FlexButtonHeightTypeMd
+	}
+	return &linebot.ButtonComponent{
+		Height: height,
+		Style:  style,
+		Color:  btn.Color,
+		Action: lineTemplateAction(btn),
+	}
+}
+
+func lineConfirmCard(card ConfirmCard) linebot.SendingMessage {
+	contents := &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:   linebot.FlexComponentTypeBox,
+			Layout: linebot.FlexBoxLayoutTypeVertical,
+			Contents: []linebot.FlexComponent{
+				&linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   card.Text,
+					Weight: linebot.FlexTextWeightTypeBold,
+					Size:   linebot.FlexTextSizeTypeXl,
+					Wrap:   true,
+				},
+			},
+		},
+		Footer: &linebot.BoxComponent{
+			Type:   linebot.FlexComponentTypeBox,
+			Layout: linebot.FlexBoxLayoutTypeVertical,
+			Contents: []linebot.FlexComponent{
+				lineButton(card.Button),
+			},
+		},
+	}
+	return linebot.NewFlexMessage(card.AltText, contents)
+}
+
+func lineBubble(card RichCard) *linebot.BubbleContainer {
+	flexLabel := 3
+	flexDesc := 7
+	flexEditDesc := 5
+	flexEdit := 2
+	linkColor := "#679AF0"
+	flex0 := 0
+	primaryColor := "#000000"
+	secondaryColor := "#AAAAAA"
+
+	elements := []linebot.FlexComponent{}
+	if card.Title != "" {
+		elements = append(elements, &linebot.TextComponent{
+			Type:   linebot.FlexComponentTypeText,
+			Text:   card.Title,
+			Weight: linebot.FlexTextWeightTypeBold,
+			Size:   linebot.FlexTextSizeTypeXl,
+			Wrap:   true,
+		})
+	}
+	for _, block := range card.Body {
+		switch block.Kind {
+		case BlockHeading:
+			elements = append(elements, &linebot.TextComponent{
+				Type:   linebot.FlexComponentTypeText,
+				Text:   block.Text,
+				Weight: linebot.FlexTextWeightTypeRegular,
+				Size:   linebot.FlexTextSizeTypeLg,
+				Margin: linebot.FlexComponentMarginTypeMd,
+				Wrap:   true,
+			})
+		case BlockField:
+			row := []linebot.FlexComponent{
+				&linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   block.Label,
+					Weight: linebot.FlexTextWeightTypeRegular,
+					Flex:   &flexLabel,
+					Size:   linebot.FlexTextSizeTypeSm,
+					Wrap:   true,
+				},
+				&linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   block.Text,
+					Weight: linebot.FlexTextWeightTypeRegular,
+					Flex:   &flexDesc,
+					Size:   linebot.FlexTextSizeTypeSm,
+					Wrap:   true,
+				},
+			}
+			if block.Button != nil {
+				row[1].(*linebot.TextComponent).Flex = &flexEditDesc
+				row = append(row, &linebot.TextComponent{
+					Type:   linebot.FlexComponentTypeText,
+					Text:   block.Button.Label,
+					Flex:   &flexEdit,
+					Size:   linebot.FlexTextSizeTypeSm,
+					Align:  linebot.FlexComponentAlignTypeEnd,
+					Color:  linkColor,
+					Action: lineTemplateAction(*block.Button),
+				})
+			}
+			elements = append(elements, &linebot.BoxComponent{
+				Type:     linebot.FlexComponentTypeBox,
+				Layout:   linebot.FlexBoxLayoutTypeBaseline,
+				Spacing:  linebot.FlexComponentSpacingTypeXs,
+				Margin:   linebot.FlexComponentMarginTypeXl,
+				Contents: row,
+			})
+		case BlockOption:
+			elements = append(elements, &linebot.BoxComponent{
+				Layout: linebot.FlexBoxLayoutTypeBaseline,
+				Contents: []linebot.FlexComponent{
+					&linebot.IconComponent{
+						URL:  block.Icon,
+						Size: linebot.FlexIconSizeType3xl,
+					},
+					&linebot.TextComponent{
+						Type:    linebot.FlexComponentTypeText,
+						Text:    block.Label,
+						Flex:    &flex0,
+						Margin:  linebot.FlexComponentMarginTypeSm,
+						Weight:  linebot.FlexTextWeightTypeRegular,
+						Color:   secondaryColor,
+						Wrap:    true,
+						Gravity: linebot.FlexComponentGravityTypeCenter,
+					},
+					&linebot.TextComponent{
+						Type:  linebot.FlexComponentTypeText,
+						Text:  block.Text,
+						Size:  linebot.FlexTextSizeTypeXl,
+						Align: linebot.FlexComponentAlignTypeEnd,
+						Color: primaryColor,
+					},
+				},
+			})
+		case BlockButton:
+			if block.Button != nil {
+				elements = append(elements, lineButton(*block.Button))
+			}
+		default:
+			size := linebot.FlexTextSizeTypeMd
+			if block.Small {
+				size = linebot.FlexTextSizeTypeSm
+			}
+			elements = append(elements, &linebot.TextComponent{
+				Type:   linebot.FlexComponentTypeText,
+				Text:   block.Text,
+				Wrap:   true,
+				Weight: linebot.FlexTextWeightTypeRegular,
+				Size:   size,
+			})
+		}
+	}
+
+	footer := []linebot.FlexComponent{
+		&linebot.SpacerComponent{
+			Type: linebot.FlexComponentTypeSeparator,
+			Size: linebot.FlexSpacerSizeTypeSm,
+		},
+	}
+	if card.InlineButtons && len(card.Buttons) > 0 {
+		buttons := []linebot.FlexComponent{}
+		for _, btn := range card.Buttons {
+			buttons = append(buttons, lineButton(btn))
+		}
+		footer = []linebot.FlexComponent{
+			&linebot.BoxComponent{
+				Type:     linebot.FlexComponentTypeBox,
+				Layout:   linebot.FlexBoxLayoutTypeHorizontal,
+				Contents: buttons,
+			},
+		}
+	} else {
+		for _, btn := range card.Buttons {
+			footer = append(footer, lineButton(btn))
+		}
+	}
+
+	bubble := &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: elements,
+		},
+		Footer: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: footer,
+		},
+	}
+	if card.Hero != "" {
+		bubble.Hero = &linebot.ImageComponent{
+			Type:        linebot.FlexComponentTypeImage,
+			URL:         card.Hero,
+			Size:        linebot.FlexImageSizeTypeFull,
+			AspectRatio: linebot.FlexImageAspectRatioType20to13,
+			AspectMode:  linebot.FlexImageAspectModeTypeCover,
+		}
+	}
+	return bubble
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeLine is an in-memory LINE platform. It serves the messaging API that
+// linebot.Client calls (reply, push, profile) and records every message the
+// bot sends, and it builds signed webhook requests for HailingApp.Callback.
+type fakeLine struct {
+	t      *testing.T
+	secret string
+	server *httptest.Server
+	// Store is users, trips, locations, subscriptions & saved places of apps
+	// from NewApp.
+	// Each app has its own session store.
+	Store *MemoryStore
+
+	mu      sync.Mutex
+	replies map[string][]fakeLineMessage // by reply token
+	pushes  map[string][]fakeLineMessage // by LINE user ID
+	// pushDown makes push API fail, see FailPushes
+	pushDown bool
+}
+
+// fakeLineMessage is a message as the LINE API receives it
+type fakeLineMessage struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text"`
+	AltText    string          `json:"altText"`
+	Contents   json.RawMessage `json:"contents"`
+	QuickReply *struct {
+		Items []struct {
+			Action struct {
+				Type  string `json:"type"`
+				Label string `json:"label"`
+				Text  string `json:"text"`
+				Data  string `json:"data"`
+			} `json:"action"`
+		} `json:"items"`
+	} `json:"quickReply"`
+}
+
+// Summary is what we check in tests: text or alt text of flex message
+func (m fakeLineMessage) Summary() string {
+	if m.Type == "flex" {
+		return m.AltText
+	}
+	return m.Text
+}
+
+func TestMain(m *testing.M) {
+	// condo a, where most test trips start, is about 70 m south of the
+	// Phrom Phong zone; tests run in a copy of the zone which takes it in
+	if os.Getenv("SERVICE_AREA_FILE") == "" {
+		os.Setenv("SERVICE_AREA_FILE", "testdata/service_area.json")
+	}
+	os.Exit(m.Run())
+}
+
+func newFakeLine(t *testing.T) *fakeLine {
+	fl := &fakeLine{
+		t:       t,
+		secret:  "fake-line-channel-secret",
+		replies: map[string][]fakeLineMessage{},
+		pushes:  map[string][]fakeLineMessage{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/bot/message/reply", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ReplyToken string            `json:"replyToken"`
+			Messages   []fakeLineMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"message":"bad request"}`, 400)
+			return
+		}
+		fl.mu.Lock()
+		fl.replies[body.ReplyToken] = append(fl.replies[body.ReplyToken], body.Messages...)
+		fl.mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/v2/bot/message/push", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			To       string            `json:"to"`
+			Messages []fakeLineMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"message":"bad request"}`, 400)
+			return
+		}
+		fl.mu.Lock()
+		defer fl.mu.Unlock()
+		if fl.pushDown {
+			http.Error(w, `{"message":"service unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		fl.pushes[body.To] = append(fl.pushes[body.To], body.Messages...)
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/v2/bot/profile/", func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimPrefix(r.URL.Path, "/v2/bot/profile/")
+		json.NewEncoder(w).Encode(map[string]string{
+			"userId":      userID,
+			"displayName": "rider-" + userID,
+			"pictureUrl":  "https://example.com/" + userID + ".png",
+		})
+	})
+	fl.server = httptest.NewServer(mux)
+	fl.Store = NewMemoryStore()
+	fl.Store.SeedLocations()
+	return fl
+}
+
+// Close shuts down the fake API server
+func (fl *fakeLine) Close() {
+	fl.server.Close()
+}
+
+// NewApp returns HailingApp which talks to this fake LINE platform
+func (fl *fakeLine) NewApp() *HailingApp {
+	os.Setenv("ENDPOINT_BASE", fl.server.URL)
+	defer os.Unsetenv("ENDPOINT_BASE")
+	app, err := NewHailingApp(fl.secret, "fake-line-channel-token", "https://hailing.example.com")
+	if err != nil {
+		fl.t.Fatal("App initialization failed ", err)
+	}
+	app.users, app.trips, app.locations = fl.Store, fl.Store, fl.Store
+	app.subscriptions, app.places = fl.Store, fl.Store
+	app.geocoder = NewReverseGeocoder(fl.Store)
+	app.sessions = NewMemorySessionStore("test:")
+	return app
+}
+
+// Pushes returns all messages pushed to lineUserID so far
+func (fl *fakeLine) Pushes(lineUserID string) []fakeLineMessage {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.pushes[lineUserID]
+}
+
+// FailPushes makes push API fail until it's called with false
+func (fl *fakeLine) FailPushes(down bool) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.pushDown = down
+}
+
+// Sign returns X-Line-Signature of body
+func (fl *fakeLine) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(fl.secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Post delivers a single webhook event to app.Callback and returns the
+// HTTP status and every message the bot replied to this event
+func (fl *fakeLine) Post(app *HailingApp, lineUserID string, event map[string]interface{}) (int, []fakeLineMessage) {
+	replyToken := uuid.New().String()
+	event["replyToken"] = replyToken
+	event["mode"] = "active"
+	event["timestamp"] = time.Now().UnixNano() / int64(time.Millisecond)
+	event["source"] = map[string]string{"type": "user", "userId": lineUserID}
+	body, _ := json.Marshal(map[string]interface{}{
+		"destination": "Ufakebot",
+		"events":      []interface{}{event},
+	})
+
+	req := httptest.NewRequest("POST", "/line-bot", bytes.NewReader(body))
+	req.Header.Set("X-Line-Signature", fl.Sign(body))
+	w := httptest.NewRecorder()
+	app.Callback(w, req)
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return w.Code, fl.replies[replyToken]
+}
+
+// SendText sends text message from lineUserID
+func (fl *fakeLine) SendText(app *HailingApp, lineUserID string, text string) []fakeLineMessage {
+	_, msgs := fl.Post(app, lineUserID, map[string]interface{}{
+		"type": "message",
+		"message": map[string]string{
+			"id":   uuid.New().String(),
+			"type": "text",
+			"text": text,
+		},
+	})
+	return msgs
+}
+
+// SendLocation sends location message from lineUserID
+func (fl *fakeLine) SendLocation(app *HailingApp, lineUserID string, lon float64, lat float64) []fakeLineMessage {
+	_, msgs := fl.Post(app, lineUserID, map[string]interface{}{
+		"type": "message",
+		"message": map[string]interface{}{
+			"id":        uuid.New().String(),
+			"type":      "location",
+			"title":     "pin",
+			"address":   "pin",
+			"latitude":  lat,
+			"longitude": lon,
+		},
+	})
+	return msgs
+}
+
+// SendPostback sends postback (e.g. button tap) from lineUserID.
+// datetime is only for datetime picker and can be zero.
+func (fl *fakeLine) SendPostback(app *HailingApp, lineUserID string, data string, datetime time.Time) []fakeLineMessage {
+	postback := map[string]interface{}{"data": data}
+	if !datetime.IsZero() {
+		postback["params"] = map[string]string{
+			"datetime": datetime.In(bangkok).Format("2006-01-02T15:04"),
+		}
+	}
+	_, msgs := fl.Post(app, lineUserID, map[string]interface{}{
+		"type":     "postback",
+		"postback": postback,
+	})
+	return msgs
+}
+
+// fakeLineStep is one turn in a scripted conversation
+type fakeLineStep struct {
+	Name     string
+	Text     string
+	Postback string
+	Datetime time.Time
+	Coords   [2]float64
+	// Expect must be found in one of the replies (see fakeLineMessage.Summary)
+	// or leave it empty to not check anything
+	Expect string
+}
+
+// Run sends this step and returns replies
+func (step fakeLineStep) Run(fl *fakeLine, app *HailingApp, lineUserID string) []fakeLineMessage {
+	switch {
+	case step.Postback != "":
+		return fl.SendPostback(app, lineUserID, step.Postback, step.Datetime)
+	case step.Coords != [2]float64{0, 0}:
+		return fl.SendLocation(app, lineUserID, step.Coords[0], step.Coords[1])
+	}
+	return fl.SendText(app, lineUserID, step.Text)
+}
+
+// runConversation plays steps in order and checks replies of each step
+func runConversation(t *testing.T, fl *fakeLine, app *HailingApp, lineUserID string, steps []fakeLineStep) {
+	for i, step := range steps {
+		replies := step.Run(fl, app, lineUserID)
+		if step.Expect == "" {
+			continue
+		}
+		summaries := []string{}
+		for _, msg := range replies {
+			summaries = append(summaries, msg.Summary())
+		}
+		if len(replies) == 0 {
+			t.Fatalf("[%d:%s] no reply", i, step.Name)
+		}
+		found := false
+		for _, s := range summaries {
+			if strings.Contains(s, step.Expect) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("[%d:%s] expect %q in replies %v", i, step.Name, step.Expect, summaries)
+		}
+	}
+}
+
+// stubOSRM serves straight routes between the requested points for
+// every OSRM request, see FakeRouting
+func stubOSRM() *httptest.Server {
+	return httptest.NewServer(NewFakeRouting())
+}
+
+func TestCallbackRejectsInvalidSignature(t *testing.T) {
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	body := []byte(`{"destination":"Ufakebot","events":[]}`)
+	req := httptest.NewRequest("POST", "/line-bot", bytes.NewReader(body))
+	req.Header.Set("X-Line-Signature", "bad-signature")
+	w := httptest.NewRecorder()
+	app.Callback(w, req)
+	if w.Code != 400 {
+		t.Errorf("invalid signature must be rejected with 400, got %d", w.Code)
+	}
+
+	code, _ := fl.Post(app, "Ufakeuser", map[string]interface{}{"type": "follow"})
+	if code != 200 {
+		t.Errorf("signed request must be accepted, got %d", code)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Place matching thresholds: candidates below minPlaceScore are dropped;
+// the best one is taken without asking if it scores at least
+// surePlaceScore and is clearly ahead of the runner-up
+const (
+	minPlaceScore  = 0.7
+	surePlaceScore = 0.85
+	placeScoreGap  = 0.1
+)
+
+// PlaceMatch is a location which matches the text a rider typed
+type PlaceMatch struct {
+	NamedLocation
+	// Matched is the name (in any language) which matches best
+	Matched string
+	Score   float64
+	// Exact is true when the text is the name apart from letter case
+	Exact bool
+}
+
+// AmbiguousPlaceError is returned when the text matches several places,
+// or one place but not well enough, so the rider has to pick
+type AmbiguousPlaceError struct {
+	Text       string
+	Candidates []PlaceMatch
+}
+
+func (e *AmbiguousPlaceError) Error() string {
+	return "Which one do you prefer?"
+}
+
+// normalizePlace makes names comparable: compatibility forms, lowercase,
+// no spaces or punctuation. Thai tone marks are dropped since they are the
+// most common typos and Thai riders often skip them on mobile keyboards.
+func normalizePlace(text string) string {
+	text = norm.NFKC.String(strings.ToLower(text))
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r >= '่' && r <= '์':
+			// mai ek, mai tho, mai tri, mai chattawa & thanthakhat
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// levenshtein is the edit distance between a & b in runes
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
+
+// placeSimilarity scores normalized text against a normalized name, 0-1
+func placeSimilarity(text string, name string) float64 {
+	if text == "" || name == "" {
+		return 0
+	}
+	if text == name {
+		return 1
+	}
+	t, n := []rune(text), []rune(name)
+	longer := len(t)
+	if len(n) > longer {
+		longer = len(n)
+	}
+	score := 1 - float64(levenshtein(t, n))/float64(longer)
+	// part of the name, e.g. "phromphong" for "bts phromphong"
+	if len(t) >= 3 && strings.Contains(name, text) {
+		partial := 0.8 + 0.2*float64(len(t))/float64(len(n))
+		if partial > score {
+			score = partial
+		}
+	}
+	return score
+}
+
+// MatchPlaces ranks locations by how well one of their names matches text,
+// best first. Ties keep the order of locations, i.e. popularity.
+func MatchPlaces(text string, locations []NamedLocation) []PlaceMatch {
+	query := normalizePlace(text)
+	results := []PlaceMatch{}
+	for _, loc := range locations {
+		best := PlaceMatch{NamedLocation: loc}
+		for _, name := range loc.Names {
+			normalized := normalizePlace(name)
+			score := placeSimilarity(query, normalized)
+			if score > best.Score {
+				best.Score = score
+				best.Matched = name
+				best.Exact = strings.EqualFold(strings.TrimSpace(text), name)
+			}
+		}
+		if best.Score >= minPlaceScore {
+			results = append(results, best)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+// MatchPlace returns the place text refers to. It returns
+// AmbiguousPlaceError if the rider has to pick, or nil & nil if nothing
+// matches.
+func MatchPlace(text string, locations []NamedLocation) (*PlaceMatch, error) {
+	matches := MatchPlaces(text, locations)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	top := matches[0]
+	if top.Score >= surePlaceScore && (len(matches) == 1 || matches[1].Score < top.Score-placeScoreGap) {
+		return &top, nil
+	}
+	if len(matches) > 5 {
+		matches = matches[:5]
+	}
+	return nil, &AmbiguousPlaceError{Text: text, Candidates: matches}
+}
+
+// MatchLocationText matches text against names of all locations;
+// see MatchPlace
+func (app *HailingApp) MatchLocationText(text string) (*PlaceMatch, error) {
+	locations, err := app.locations.ListLocations()
+	if err != nil {
+		log.Printf("[MatchLocationText] %v", err)
+		return nil, nil
+	}
+	match, err := MatchPlace(text, locations)
+	if ambiguous, ok := err.(*AmbiguousPlaceError); ok {
+		names := []string{}
+		for _, c := range ambiguous.Candidates {
+			names = append(names, fmt.Sprintf("%s (%.2f)", c.Matched, c.Score))
+		}
+		log.Printf("[MatchLocationText] %q is ambiguous: %s", text, strings.Join(names, ", "))
+	}
+	return match, err
+}
+
+// PlacePickerFlex asks the rider to pick one of the candidates
+func (app *HailingApp) PlacePickerFlex(candidates []PlaceMatch, lang string, localizer *i18n.Localizer) Message {
+	whichOne := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "WhichOneDoYouPrefer",
+			Other: "Which one do you prefer?",
+		},
+	})
+	items := []CardBlock{}
+	for _, c := range candidates {
+		items = append(items, ButtonBlock(PostbackButton(
+			c.NameIn(lang),
+			fmt.Sprintf("location:%s:%d", c.Name, c.ID),
+		)))
+	}
+	return RichCard{
+		AltText: whichOne,
+		Title:   whichOne,
+		Body:    items,
+	}
+}
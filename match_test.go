@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func testNamedLocations() []NamedLocation {
+	names := []map[string]string{
+		{"en": "condo a", "th": "คอนโด เอ", "ja": "コンドA"},
+		{"en": "citi resort", "th": "ซิตี้ รีสอร์ท", "ja": "シティリゾート"},
+		{"en": "bts phromphong", "th": "บีทีเอส พร้อมพงษ์", "ja": "BTSプロムポン駅"},
+		{"en": "condo b", "th": "คอนโด บี", "ja": "コンドB"},
+	}
+	results := []NamedLocation{}
+	for i, n := range names {
+		results = append(results, NamedLocation{
+			Location: Location{ID: i + 1, Name: n["en"]},
+			Names:    n,
+		})
+	}
+	return results
+}
+
+func TestMatchPlace(t *testing.T) {
+	locations := testNamedLocations()
+	tests := []struct {
+		text      string
+		expect    string // matched name; empty if none or ambiguous
+		ambiguous int    // number of candidates if the rider has to pick
+	}{
+		{text: "Citi Resort", expect: "citi resort"},
+		{text: "citiresort", expect: "citi resort"},
+		{text: "phromphong", expect: "bts phromphong"},
+		{text: "BTS  Phrom-phong!", expect: "bts phromphong"},
+		{text: "btsphromphonk", expect: "bts phromphong"},
+		{text: "บีทีเอส พร้อมพงษ์", expect: "บีทีเอส พร้อมพงษ์"},
+		{text: "บีทีเอสพรอมพงษ", expect: "บีทีเอส พร้อมพงษ์"},
+		{text: "ซิตี้รีสอร์ต", expect: "ซิตี้ รีสอร์ท"},
+		{text: "ｃｏｎｄｏ ａ", expect: "condo a"},
+		{text: "シティリゾート", expect: "シティリゾート"},
+		{text: "condo", ambiguous: 2},
+		{text: "citi resrot", ambiguous: 1},
+		{text: "nowhere"},
+		{text: "ab"},
+	}
+	for _, tc := range tests {
+		match, err := MatchPlace(tc.text, locations)
+		if tc.ambiguous > 0 {
+			ambiguous, ok := err.(*AmbiguousPlaceError)
+			if !ok || len(ambiguous.Candidates) != tc.ambiguous {
+				t.Errorf("%q: expect %d candidates, got %v %v", tc.text, tc.ambiguous, match, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.text, err)
+			continue
+		}
+		if tc.expect == "" {
+			if match != nil {
+				t.Errorf("%q: expect no match, got %v", tc.text, match)
+			}
+			continue
+		}
+		if match == nil || match.Matched != tc.expect {
+			t.Errorf("%q: expect %q, got %v", tc.text, tc.expect, match)
+		}
+	}
+}
+
+func TestPlacePickerConversation(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	fl.Store.AddLocation(MemoryLocation{
+		Location: Location{ID: 4, Name: "condo b", Place: Coords{Coordinates: [2]float64{100.5701, 13.7399}}},
+		Names:    map[string]string{"th": "คอนโด บี"},
+	})
+	app := fl.NewApp()
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "ambiguous", Text: "condo", Expect: "Which one do you prefer?"},
+		{Name: "pick", Postback: "location:condo a:1", Expect: "Pickup location?"},
+		{Name: "thai-typo", Text: "คอนโดบี้", Expect: "When?"},
+	})
+	rec, _ := app.FindRecord(rider)
+	if rec.To != "condo a" || rec.From != "คอนโด บี" || rec.FromCoords != [2]float64{100.5701, 13.7399} {
+		t.Errorf("unexpected places: %v", rec)
+	}
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// MemoryStore is UserStore, TripStore, LocationStore, SubscriptionStore
+// and PlaceStore kept in memory.
+// It behaves like PostgresStore and is meant for tests and local demos.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	users       map[uuid.UUID]*User
+	trips       map[int]*MemoryTrip
+	locations   map[int]*MemoryLocation
+	subs        map[int]*Subscription
+	places      map[int]*SavedPlace
+	choices     []TravelChoice
+	lastTripID  int
+	lastSubID   int
+	lastPlaceID int
+}
+
+// MemoryTrip is a row of trip table in MemoryStore
+type MemoryTrip struct {
+	Trip
+	PlaceFrom [2]float64
+	PlaceTo   [2]float64
+	Polyline  string
+	Fare      float64
+	// location IDs matched to both ends, 0 if none
+	LocationFrom int
+	LocationTo   int
+}
+
+// MemoryLocation is a row of location table in MemoryStore
+type MemoryLocation struct {
+	Location
+	Names      map[string]string // localized names by language i.e. th, ja
+	Popularity int
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:     map[uuid.UUID]*User{},
+		trips:     map[int]*MemoryTrip{},
+		locations: map[int]*MemoryLocation{},
+		subs:      map[int]*Subscription{},
+		places:    map[int]*SavedPlace{},
+	}
+}
+
+// AddLocation adds or replaces a location
+func (s *MemoryStore) AddLocation(loc MemoryLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	one := loc
+	s.locations[loc.ID] = &one
+}
+
+// SeedLocations adds places of the words file as locations,
+// in the order of popularity
+func (s *MemoryStore) SeedLocations() {
+	places := Vocabulary().Words().Places
+	for i, place := range places {
+		s.AddLocation(MemoryLocation{
+			Location: Location{
+				ID:    i + 1,
+				Name:  place.Name,
+				Place: Coords{Coordinates: place.Coords, Type: "Point"},
+			},
+			Names: map[string]string{
+				"th": place.NameIn("th"),
+				"ja": place.NameIn("ja"),
+			},
+			Popularity: len(places) - i,
+		})
+	}
+}
+
+// UpdateTrip lets tests and demos play the driver side, e.g. accept or drop off
+func (s *MemoryStore) UpdateTrip(tripID int, update func(trip *MemoryTrip)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trip, ok := s.trips[tripID]
+	if !ok {
+		return ErrNotFound
+	}
+	update(trip)
+	return nil
+}
+
+// GetLocationByID returns a location in Location struct
+func (s *MemoryStore) GetLocationByID(ID int) (*Location, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.locations[ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := loc.Location
+	return &result, nil
+}
+
+// GetLocations return most popular locations
+func (s *MemoryStore) GetLocations(lang string, total int) ([]Location, error) {
+	maxTotal := 10
+	if total < 1 || total > maxTotal {
+		total = maxTotal
+	}
+	s.mu.RLock()
+	all := []*MemoryLocation{}
+	for _, loc := range s.locations {
+		all = append(all, loc)
+	}
+	s.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Popularity == all[j].Popularity {
+			return all[i].ID < all[j].ID
+		}
+		return all[i].Popularity > all[j].Popularity
+	})
+
+	results := []Location{}
+	for _, loc := range all {
+		if len(results) == total {
+			break
+		}
+		one := loc.Location
+		if name, ok := loc.Names[lang]; ok && name != "" {
+			one.Name = name
+		}
+		results = append(results, one)
+	}
+	return results, nil
+}
+
+// ListLocations returns all locations named in every language,
+// most popular first
+func (s *MemoryStore) ListLocations() ([]NamedLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []NamedLocation{}
+	for _, loc := range s.locations {
+		names := map[string]string{"en": loc.Name}
+		for lang, name := range loc.Names {
+			names[lang] = name
+		}
+		results = append(results, NamedLocation{Location: loc.Location, Names: names})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		pi, pj := s.locations[results[i].ID].Popularity, s.locations[results[j].ID].Popularity
+		if pi == pj {
+			return results[i].ID < results[j].ID
+		}
+		return pi > pj
+	})
+	return results, nil
+}
+
+// nearestLocation returns ID of the location nearest to coords within
+// radius (m) & how far it is, or 0
+func (s *MemoryStore) nearestLocation(coords [2]float64, radius float64) (int, float64) {
+	nearest, nearestDistance := 0, radius
+	for _, loc := range s.locations {
+		d := geo.Distance(orb.Point(coords), orb.Point(loc.Place.Coordinates))
+		if d < nearestDistance || (d == nearestDistance && nearest == 0) {
+			nearest, nearestDistance = loc.ID, d
+		}
+	}
+	return nearest, nearestDistance
+}
+
+// NearestLocation returns the location nearest to coords within radius (m)
+func (s *MemoryStore) NearestLocation(coords [2]float64, radius float64) (*Location, float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ID, distance := s.nearestLocation(coords, radius)
+	if ID == 0 {
+		return nil, 0, ErrNotFound
+	}
+	result := s.locations[ID].Location
+	return &result, distance, nil
+}
+
+// MatchTripLocations links both ends of the trip to the nearest location
+func (s *MemoryStore) MatchTripLocations(tripID int, radius float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trip, ok := s.trips[tripID]
+	if !ok {
+		return ErrNotFound
+	}
+	trip.LocationFrom, _ = s.nearestLocation(trip.PlaceFrom, radius)
+	trip.LocationTo, _ = s.nearestLocation(trip.PlaceTo, radius)
+	return nil
+}
+
+// RecomputePopularity scores locations by the trips from & to them;
+// locations without any trip keep their popularity
+func (s *MemoryStore) RecomputePopularity(now time.Time, halfLife time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scores := map[int]float64{}
+	for _, trip := range s.trips {
+		if trip.CancelledAt != nil || trip.ReservedAt == nil {
+			continue
+		}
+		weight := decay(now.Sub(*trip.ReservedAt), halfLife)
+		scores[trip.LocationFrom] += weight
+		scores[trip.LocationTo] += weight
+	}
+	for ID, loc := range s.locations {
+		if score, ok := scores[ID]; ok {
+			loc.Popularity = popularityScore(score)
+		}
+	}
+	return nil
+}
+
+// FindUserByLineID returns user by LINE user ID
+func (s *MemoryStore) FindUserByLineID(lineUserID string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.LineUserID == lineUserID {
+			result := *u
+			return &result, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// FindUserByID is to find LineUserID from the system ID
+func (s *MemoryStore) FindUserByID(ID uuid.UUID) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *u
+	return &result, nil
+}
+
+// CreateUser handles user creation
+func (s *MemoryStore) CreateUser(username string, lineUserID string, profileURL string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			// duplicate username found
+			newRandom, _ := uuid.NewRandom()
+			four := fmt.Sprintf("%v", newRandom)[:4]
+			username = fmt.Sprintf("%s_%s", username, four)
+			break
+		}
+	}
+	u := User{
+		ID:         uuid.New(),
+		Username:   username,
+		LineUserID: lineUserID,
+		ProfileURL: profileURL,
+		Language:   "en",
+	}
+	s.users[u.ID] = &u
+	result := u
+	return &result, nil
+}
+
+// SetLanguage save preferred lanague
+func (s *MemoryStore) SetLanguage(userID uuid.UUID, lang string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Language = lang
+	return nil
+}
+
+// SaveReservation inserts or updates trip from the record
+func (s *MemoryStore) SaveReservation(rec *ReservationRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reservedAt := rec.ReservedAt
+	if rec.TripID == -1 {
+		s.lastTripID++
+		s.trips[s.lastTripID] = &MemoryTrip{
+			Trip: Trip{
+				ID:              s.lastTripID,
+				UserID:          rec.UserID,
+				ReservedAt:      &reservedAt,
+				From:            rec.From,
+				To:              rec.To,
+				NumOfPassengers: rec.NumOfPassengers,
+			},
+			PlaceFrom: rec.FromCoords,
+			PlaceTo:   rec.ToCoords,
+			Polyline:  rec.Polyline,
+			Fare:      rec.Fare,
+		}
+		return s.lastTripID, nil
+	}
+	trip, ok := s.trips[rec.TripID]
+	if !ok {
+		return -1, ErrNotFound
+	}
+	trip.From = rec.From
+	trip.To = rec.To
+	trip.ReservedAt = &reservedAt
+	trip.PlaceFrom = rec.FromCoords
+	trip.PlaceTo = rec.ToCoords
+	trip.Polyline = rec.Polyline
+	trip.NumOfPassengers = rec.NumOfPassengers
+	trip.Fare = rec.Fare
+	return trip.ID, nil
+}
+
+// FindActiveReservation returns the soonest trip which is still going on
+func (s *MemoryStore) FindActiveReservation(lineUserID string) (*ReservationRecord, error) {
+	user, err := s.FindUserByLineID(lineUserID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var active *MemoryTrip
+	for _, trip := range s.trips {
+		if trip.UserID != user.ID || trip.DroppedOffAt != nil || trip.CancelledAt != nil {
+			continue
+		}
+		if active == nil || soonerTrip(&trip.Trip, &active.Trip) {
+			active = trip
+		}
+	}
+	if active == nil {
+		return nil, ErrNotFound
+	}
+	record := ReservationRecord{
+		LineUserID:      lineUserID,
+		State:           StateDone,
+		Waiting:         StatePickup,
+		IsConfirmed:     true,
+		TripID:          active.ID,
+		UserID:          active.UserID,
+		From:            active.From,
+		FromCoords:      active.PlaceFrom,
+		To:              active.To,
+		ToCoords:        active.PlaceTo,
+		Polyline:        active.Polyline,
+		NumOfPassengers: active.NumOfPassengers,
+		Fare:            active.Fare,
+	}
+	if active.ReservedAt != nil {
+		record.ReservedAt = *active.ReservedAt
+	}
+	if active.PickedUpAt != nil {
+		record.PickedUpAt = *active.PickedUpAt
+	}
+	return &record, nil
+}
+
+// GetTrip returns trip record
+func (s *MemoryStore) GetTrip(tripID int) (*Trip, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trip, ok := s.trips[tripID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := trip.Trip
+	return &result, nil
+}
+
+// GetTripPlaces returns pickup & drop-off coordinates of the trip
+func (s *MemoryStore) GetTripPlaces(tripID int) ([2]float64, [2]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trip, ok := s.trips[tripID]
+	if !ok {
+		return [2]float64{}, [2]float64{}, ErrNotFound
+	}
+	return trip.PlaceFrom, trip.PlaceTo, nil
+}
+
+// TripHistory returns the user's trips which aren't cancelled, latest first
+func (s *MemoryStore) TripHistory(userID uuid.UUID, limit int) ([]PastTrip, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []PastTrip{}
+	for _, trip := range s.trips {
+		if trip.UserID != userID || trip.CancelledAt != nil || trip.ReservedAt == nil {
+			continue
+		}
+		results = append(results, PastTrip{
+			ID:           trip.ID,
+			From:         trip.From,
+			FromCoords:   trip.PlaceFrom,
+			To:           trip.To,
+			ToCoords:     trip.PlaceTo,
+			ReservedAt:   *trip.ReservedAt,
+			FromLocation: trip.LocationFrom,
+			ToLocation:   trip.LocationTo,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ReservedAt.After(results[j].ReservedAt)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// UpcomingTrips returns trips to pick up in (from, to]
+func (s *MemoryStore) UpcomingTrips(from time.Time, to time.Time) ([]Trip, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []Trip{}
+	for _, trip := range s.trips {
+		if trip.ReservedAt == nil || !trip.ReservedAt.After(from) || trip.ReservedAt.After(to) {
+			continue
+		}
+		if trip.CancelledAt != nil || trip.PickedUpAt != nil || trip.DroppedOffAt != nil {
+			continue
+		}
+		results = append(results, trip.Trip)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ReservedAt.Before(*results[j].ReservedAt)
+	})
+	return results, nil
+}
+
+// SaveTripFeedback update feedback from user
+func (s *MemoryStore) SaveTripFeedback(tripID int, rating int) error {
+	return s.UpdateTrip(tripID, func(trip *MemoryTrip) {
+		trip.UserFeedback = rating
+	})
+}
+
+// CancelTrip marks trip as cancelled
+func (s *MemoryStore) CancelTrip(tripID int, note string, cancelledAt time.Time) error {
+	return s.UpdateTrip(tripID, func(trip *MemoryTrip) {
+		trip.Note = note
+		trip.CancelledAt = &cancelledAt
+	})
+}
+
+// SetTripNote replaces the trip's note
+func (s *MemoryStore) SetTripNote(tripID int, note string) error {
+	return s.UpdateTrip(tripID, func(trip *MemoryTrip) {
+		trip.Note = note
+	})
+}
+
+// SaveTravelChoice logs the option the rider took
+func (s *MemoryStore) SaveTravelChoice(choice *TravelChoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.choices = append(s.choices, *choice)
+	return nil
+}
+
+// TravelChoices returns options the user took, oldest first
+func (s *MemoryStore) TravelChoices(userID uuid.UUID) []TravelChoice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []TravelChoice{}
+	for _, choice := range s.choices {
+		if choice.UserID == userID {
+			results = append(results, choice)
+		}
+	}
+	return results
+}
+
+// soonerTrip reports whether a is picked up before b;
+// the newer one wins if both are at the same time
+func soonerTrip(a *Trip, b *Trip) bool {
+	if a.ReservedAt != nil && b.ReservedAt != nil && !a.ReservedAt.Equal(*b.ReservedAt) {
+		return a.ReservedAt.Before(*b.ReservedAt)
+	}
+	return a.ID > b.ID
+}
+
+// copySubscription returns a copy which shares nothing with sub
+func copySubscription(sub *Subscription) Subscription {
+	result := *sub
+	result.Weekdays = append([]time.Weekday{}, sub.Weekdays...)
+	result.SkipDates = append([]string{}, sub.SkipDates...)
+	if sub.StoppedAt != nil {
+		stoppedAt := *sub.StoppedAt
+		result.StoppedAt = &stoppedAt
+	}
+	return result
+}
+
+// CreateSubscription returns ID of the new subscription
+func (s *MemoryStore) CreateSubscription(sub *Subscription) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSubID++
+	one := copySubscription(sub)
+	one.ID = s.lastSubID
+	s.subs[one.ID] = &one
+	return one.ID, nil
+}
+
+// GetSubscription returns subscription by ID
+func (s *MemoryStore) GetSubscription(ID int) (*Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subs[ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := copySubscription(sub)
+	return &result, nil
+}
+
+// FindSubscriptions returns subscriptions of the user which aren't stopped
+func (s *MemoryStore) FindSubscriptions(userID uuid.UUID) ([]Subscription, error) {
+	return s.filterSubscriptions(func(sub *Subscription) bool {
+		return sub.UserID == userID && sub.StoppedAt == nil
+	}), nil
+}
+
+// ActiveSubscriptions returns subscriptions neither paused nor stopped
+func (s *MemoryStore) ActiveSubscriptions() ([]Subscription, error) {
+	return s.filterSubscriptions(func(sub *Subscription) bool {
+		return !sub.Paused && sub.StoppedAt == nil
+	}), nil
+}
+
+func (s *MemoryStore) filterSubscriptions(match func(sub *Subscription) bool) []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []Subscription{}
+	for _, sub := range s.subs {
+		if match(sub) {
+			results = append(results, copySubscription(sub))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
+// UpdateSubscription saves pause, skip dates & stop; see ClaimPickup for
+// last scheduled date
+func (s *MemoryStore) UpdateSubscription(sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.subs[sub.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	one := copySubscription(old)
+	one.Paused = sub.Paused
+	one.SkipDates = append([]string{}, sub.SkipDates...)
+	if sub.StoppedAt != nil {
+		stoppedAt := *sub.StoppedAt
+		one.StoppedAt = &stoppedAt
+	}
+	s.subs[sub.ID] = &one
+	return nil
+}
+
+// ClaimPickup sets last scheduled date if the pickup on date is still due
+func (s *MemoryStore) ClaimPickup(ID int, date string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[ID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if sub.Paused || sub.StoppedAt != nil || sub.isSkipped(date) || date <= sub.LastScheduled {
+		return false, nil
+	}
+	sub.LastScheduled = date
+	return true, nil
+}
+
+// SavePlace adds a place or moves the one with the same name
+func (s *MemoryStore) SavePlace(userID uuid.UUID, name string, coords [2]float64) (*SavedPlace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	place := SavedPlace{
+		UserID: userID,
+		Name:   name,
+		Place:  Coords{Coordinates: coords, Type: "Point"},
+	}
+	for _, old := range s.places {
+		if old.UserID == userID && strings.EqualFold(old.Name, name) {
+			place.ID = old.ID
+		}
+	}
+	if place.ID == 0 {
+		s.lastPlaceID++
+		place.ID = s.lastPlaceID
+	}
+	s.places[place.ID] = &place
+	result := place
+	return &result, nil
+}
+
+// SavedPlaces returns places of the user, oldest first
+func (s *MemoryStore) SavedPlaces(userID uuid.UUID) ([]SavedPlace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []SavedPlace{}
+	for _, place := range s.places {
+		if place.UserID == userID {
+			results = append(results, *place)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// GetSavedPlace returns saved place by ID
+func (s *MemoryStore) GetSavedPlace(ID int) (*SavedPlace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	place, ok := s.places[ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *place
+	return &result, nil
+}
+
+// RemovePlace deletes the user's place by name, case-insensitive
+func (s *MemoryStore) RemovePlace(userID uuid.UUID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ID, place := range s.places {
+		if place.UserID == userID && strings.EqualFold(place.Name, name) {
+			delete(s.places, ID)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreActiveReservation(t *testing.T) {
+	store := NewMemoryStore()
+	user, err := store.CreateUser("rider", "Urider", "")
+	if err != nil {
+		t.Fatal("CreateUser failed: ", err)
+	}
+	if _, err := store.FindActiveReservation("Urider"); err != ErrNotFound {
+		t.Errorf("no trip yet, expect ErrNotFound: %v", err)
+	}
+
+	rec := ReservationRecord{
+		UserID:          user.ID,
+		LineUserID:      user.LineUserID,
+		TripID:          -1,
+		From:            "condo a",
+		FromCoords:      placeCoords("condo a"),
+		To:              "citi resort",
+		ToCoords:        placeCoords("citi resort"),
+		ReservedAt:      time.Now().Add(15 * time.Minute),
+		NumOfPassengers: 2,
+	}
+	first, err := store.SaveReservation(&rec)
+	if err != nil {
+		t.Fatal("SaveReservation failed: ", err)
+	}
+	second, _ := store.SaveReservation(&rec)
+
+	active, err := store.FindActiveReservation("Urider")
+	if err != nil {
+		t.Fatal("FindActiveReservation failed: ", err)
+	}
+	if active.TripID != second || active.State != "done" || !active.IsConfirmed {
+		t.Errorf("latest trip should be active: %v", active)
+	}
+	if active.ToCoords != rec.ToCoords || active.NumOfPassengers != 2 {
+		t.Errorf("active trip data mismatch: %v", active)
+	}
+
+	store.CancelTrip(second, "cancelled", time.Now())
+	active, _ = store.FindActiveReservation("Urider")
+	if active == nil || active.TripID != first {
+		t.Errorf("cancelled trip is not active anymore: %v", active)
+	}
+
+	store.UpdateTrip(first, func(trip *MemoryTrip) {
+		now := time.Now()
+		trip.DroppedOffAt = &now
+	})
+	if _, err := store.FindActiveReservation("Urider"); err != ErrNotFound {
+		t.Errorf("dropped off trip is not active anymore: %v", err)
+	}
+
+	rec.TripID = first
+	rec.To = "bts phromphong"
+	if _, err := store.SaveReservation(&rec); err != nil {
+		t.Error("update failed: ", err)
+	}
+	trip, _ := store.GetTrip(first)
+	if trip.To != "bts phromphong" {
+		t.Errorf("trip is not updated: %v", trip)
+	}
+}
+
+func TestMemoryStoreLocationPopularity(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddLocation(MemoryLocation{Location: Location{ID: 1, Name: "condo a"}, Popularity: 1})
+	store.AddLocation(MemoryLocation{
+		Location:   Location{ID: 2, Name: "bts phromphong"},
+		Names:      map[string]string{"th": "บีทีเอส พร้อมพงษ์"},
+		Popularity: 10,
+	})
+	store.AddLocation(MemoryLocation{Location: Location{ID: 3, Name: "citi resort"}, Popularity: 5})
+
+	locs, _ := store.GetLocations("th", 2)
+	if len(locs) != 2 {
+		t.Fatalf("expect 2 locations, got %v", locs)
+	}
+	if locs[0].ID != 2 || locs[1].ID != 3 {
+		t.Errorf("locations are not ordered by popularity: %v", locs)
+	}
+	if locs[0].Name != "บีทีเอส พร้อมพงษ์" || locs[1].Name != "citi resort" {
+		t.Errorf("localized name mismatch: %v", locs)
+	}
+	if _, err := store.GetLocationByID(4); err != ErrNotFound {
+		t.Errorf("expect ErrNotFound: %v", err)
+	}
+}
+
+func TestMemoryStorePopularityRecompute(t *testing.T) {
+	store := NewMemoryStore()
+	store.SeedLocations() // condo a, citi resort, bts phromphong
+	user, _ := store.CreateUser("rider", "Urider", "")
+	now := time.Now()
+	save := func(from [2]float64, to [2]float64, reservedAt time.Time) int {
+		ID, _ := store.SaveReservation(&ReservationRecord{
+			UserID: user.ID, TripID: -1,
+			FromCoords: from, ToCoords: to, ReservedAt: reservedAt,
+		})
+		if err := store.MatchTripLocations(ID, 150); err != nil {
+			t.Fatal("MatchTripLocations failed: ", err)
+		}
+		return ID
+	}
+	// a pin 50 m away from bts still counts for bts
+	bts := placeCoords("bts phromphong")
+	nearBTS := [2]float64{bts[0] + 0.0004, bts[1]}
+	farAway := [2]float64{100.60, 13.70}
+	save(placeCoords("citi resort"), nearBTS, now.Add(-time.Hour))
+	save(farAway, nearBTS, now.Add(-2*time.Hour))
+	// a year ago condo a was everything
+	for i := 0; i < 3; i++ {
+		save(placeCoords("condo a"), farAway, now.AddDate(-1, 0, -i))
+	}
+	cancelled := save(placeCoords("condo a"), placeCoords("citi resort"), now)
+	store.CancelTrip(cancelled, "", now)
+	// no trip goes to the pier yet; its hand-set popularity stays
+	store.AddLocation(MemoryLocation{Location: Location{ID: 9, Name: "pier", Place: Coords{Coordinates: [2]float64{100.51, 13.72}}}, Popularity: 42})
+
+	if err := store.RecomputePopularity(now, 30*24*time.Hour); err != nil {
+		t.Fatal("RecomputePopularity failed: ", err)
+	}
+	locs, _ := store.GetLocations("en", 4)
+	if locs[0].Name != "bts phromphong" || locs[1].Name != "citi resort" || locs[2].Name != "pier" || locs[3].Name != "condo a" {
+		t.Errorf("popularity must follow recent trips: %v", locs)
+	}
+	store.mu.RLock()
+	btsScore, citi, condo := store.locations[3].Popularity, store.locations[2].Popularity, store.locations[1].Popularity
+	pier := store.locations[9].Popularity
+	store.mu.RUnlock()
+	if btsScore != 200 || citi != 100 || condo != 0 {
+		t.Errorf("unexpected popularity: bts %d, citi %d, condo %d", btsScore, citi, condo)
+	}
+	if pier != 42 {
+		t.Errorf("unmatched location must keep its popularity, got %d", pier)
+	}
+}
+
+func TestMemoryStoreDuplicateUsername(t *testing.T) {
+	store := NewMemoryStore()
+	u1, _ := store.CreateUser("rider", "U1", "")
+	u2, _ := store.CreateUser("rider", "U2", "")
+	if u1.Username == u2.Username {
+		t.Errorf("username must be unique: %v %v", u1.Username, u2.Username)
+	}
+	store.SetLanguage(u2.ID, "ja")
+	u, _ := store.FindUserByLineID("U2")
+	if u.Language != "ja" {
+		t.Errorf("language is not set: %v", u)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+/* Messenger is how HailingApp talks back to riders.
+
+The reservation flow only builds platform-neutral messages (TextMessage,
+ConfirmCard, RichCard) and hands them to a Messenger. Each chat platform
+has its own adapter which turns them into whatever that platform speaks,
+e.g. LineMessenger renders RichCard as a LINE flex bubble.
+*/
+
+// Messenger sends messages to riders on one chat platform
+type Messenger interface {
+	// Reply answers an incoming event identified by replyToken
+	Reply(replyToken string, messages ...Message) error
+	// Push sends messages to userID without an incoming event
+	Push(userID string, messages ...Message) error
+	// Profile returns the platform profile of userID
+	Profile(userID string) (*Profile, error)
+}
+
+// Profile is the rider's public profile on the chat platform
+type Profile struct {
+	UserID      string
+	DisplayName string
+	PictureURL  string
+}
+
+// Message is any message the bot could send
+type Message interface {
+	isMessage()
+}
+
+// Action types for QuickReplyButton.Type and CardButton.Action
+const (
+	ActionMessage  = "message"
+	ActionPostback = "postback"
+	ActionDatetime = "datetime"
+	ActionLocation = "location"
+)
+
+// Button styles for CardButton.Style
+const (
+	ButtonPrimary   = "primary"
+	ButtonSecondary = "secondary"
+	ButtonLink      = "link"
+)
+
+// Kinds of CardBlock
+const (
+	BlockText    = "text"    // a paragraph
+	BlockHeading = "heading" // a section heading
+	BlockField   = "field"   // label & value on the same line
+	BlockOption  = "option"  // icon, caption & value e.g. travel options
+	BlockButton  = "button"  // a button in the body e.g. list of choices
+)
+
+// TextMessage is plain text with optional quick reply buttons
+type TextMessage struct {
+	Text         string
+	QuickReplies []QuickReplyButton
+}
+
+// ConfirmCard is a short question with a single button to act on it
+type ConfirmCard struct {
+	AltText string
+	Text    string
+	Button  CardButton
+}
+
+// RichCard is a card with title, body blocks and buttons at the bottom
+type RichCard struct {
+	AltText string
+	// Hero is the URL of an image on top, e.g. the route map
+	Hero    string
+	Title   string
+	Body    []CardBlock
+	Buttons []CardButton
+	// InlineButtons puts Buttons side by side instead of stacking them
+	InlineButtons bool
+}
+
+// Carousel is RichCards side by side which the rider swipes through
+type Carousel struct {
+	AltText string
+	Cards   []RichCard
+}
+
+// CardBlock is one element in RichCard's body. Which fields are used
+// depends on Kind.
+type CardBlock struct {
+	Kind  string
+	Text  string
+	Label string
+	Icon  string
+	Small bool
+	// Button is the button of BlockButton, or a small link next to the
+	// value of BlockField
+	Button *CardButton
+}
+
+// CardButton is a button on a card
+type CardButton struct {
+	Label  string
+	Action string
+	Data   string
+	Text   string // message text for ActionMessage, display text for ActionPostback
+	Style  string
+	Color  string
+}
+
+func (TextMessage) isMessage() {}
+func (ConfirmCard) isMessage() {}
+func (RichCard) isMessage()    {}
+func (Carousel) isMessage()    {}
+
+// NewTextMessages is a shorthand for a list of TextMessage
+func NewTextMessages(texts ...string) []Message {
+	msgs := make([]Message, len(texts))
+	for i := 0; i < len(texts); i++ {
+		msgs[i] = TextMessage{Text: texts[i]}
+	}
+	return msgs
+}
+
+// TextBlock returns a paragraph for RichCard
+func TextBlock(text string) CardBlock {
+	return CardBlock{Kind: BlockText, Text: text}
+}
+
+// FieldBlock returns label & value line for RichCard
+func FieldBlock(label string, value string) CardBlock {
+	return CardBlock{Kind: BlockField, Label: label, Text: value}
+}
+
+// EditableFieldBlock returns FieldBlock with a link to edit the value
+func EditableFieldBlock(label string, value string, btn CardButton) CardBlock {
+	block := FieldBlock(label, value)
+	block.Button = &btn
+	return block
+}
+
+// ButtonBlock returns a button inside RichCard's body
+func ButtonBlock(btn CardButton) CardBlock {
+	return CardBlock{Kind: BlockButton, Button: &btn}
+}
+
+// PostbackButton returns a button which posts data back to the bot
+func PostbackButton(label string, data string) CardButton {
+	return CardButton{Label: label, Action: ActionPostback, Data: data, Style: ButtonLink}
+}
@@ -0,0 +1,27 @@
+-- recurring rides, see subscription.go
+CREATE TABLE IF NOT EXISTS "subscription" (
+    "id" serial PRIMARY KEY,
+    "user_id" uuid NOT NULL REFERENCES "user"("id"),
+    "from" text NOT NULL,
+    "place_from" geometry(Point) NOT NULL,
+    "to" text NOT NULL,
+    "place_to" geometry(Point) NOT NULL,
+    -- bit 0 is Sunday, see WeekdayMask
+    "weekdays" integer NOT NULL,
+    -- HH:MM in Asia/Bangkok
+    "pickup_time" text NOT NULL,
+    "no_passengers" integer NOT NULL DEFAULT 1,
+    "paused" boolean NOT NULL DEFAULT false,
+    "skip_dates" date[] NOT NULL DEFAULT '{}',
+    "last_scheduled_on" date,
+    "stopped_at" timestamptz,
+    "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS "subscription_user_id_idx" ON "subscription"("user_id");
+
+-- tables created when skip_dates could be null
+UPDATE "subscription" SET "skip_dates" = '{}' WHERE "skip_dates" IS NULL;
+ALTER TABLE "subscription"
+    ALTER COLUMN "skip_dates" SET DEFAULT '{}',
+    ALTER COLUMN "skip_dates" SET NOT NULL;
@@ -0,0 +1,11 @@
+-- places riders save for themselves, see places.go
+CREATE TABLE IF NOT EXISTS "saved_place" (
+    "id" serial PRIMARY KEY,
+    "user_id" uuid NOT NULL REFERENCES "user"("id"),
+    "name" text NOT NULL,
+    "place" geometry(Point) NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "saved_place_user_id_name_key"
+    ON "saved_place"("user_id", lower("name"));
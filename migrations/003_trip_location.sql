@@ -0,0 +1,24 @@
+-- locations matched to trip ends, see MatchTripLocations
+ALTER TABLE "trip"
+    ADD COLUMN IF NOT EXISTS "location_from_id" integer REFERENCES "location"("id"),
+    ADD COLUMN IF NOT EXISTS "location_to_id" integer REFERENCES "location"("id");
+
+CREATE INDEX IF NOT EXISTS "trip_location_from_id_idx" ON "trip"("location_from_id");
+CREATE INDEX IF NOT EXISTS "trip_location_to_id_idx" ON "trip"("location_to_id");
+
+-- trips saved before this migration, matched like MatchTripLocations with
+-- the default LOCATION_RADIUS (150 m)
+UPDATE "trip" t SET
+    "location_from_id" = (
+        SELECT l.id FROM "location" l
+        WHERE ST_DWithin(l.place::geography, t.place_from::geography, 150)
+        ORDER BY ST_Distance(l.place::geography, t.place_from::geography)
+        LIMIT 1
+    ),
+    "location_to_id" = (
+        SELECT l.id FROM "location" l
+        WHERE ST_DWithin(l.place::geography, t.place_to::geography, 150)
+        ORDER BY ST_Distance(l.place::geography, t.place_to::geography)
+        LIMIT 1
+    )
+WHERE t.location_from_id is null AND t.location_to_id is null;
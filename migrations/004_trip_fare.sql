@@ -0,0 +1,3 @@
+-- estimated fare of the trip, see FareTable
+ALTER TABLE "trip"
+    ADD COLUMN IF NOT EXISTS "fare" numeric(10,2);
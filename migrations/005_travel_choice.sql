@@ -0,0 +1,17 @@
+-- options riders took on the travel options carousel, see travel.go
+CREATE TABLE IF NOT EXISTS "travel_choice" (
+    "id" serial PRIMARY KEY,
+    "user_id" uuid NOT NULL REFERENCES "user"("id"),
+    "trip_id" integer REFERENCES "trip"("id"),
+    "mode" text NOT NULL,
+    "place_from" geometry(Point) NOT NULL,
+    "place_to" geometry(Point) NOT NULL,
+    "distance" double precision NOT NULL,
+    "duration" double precision NOT NULL,
+    "cost" numeric(10,2),
+    "currency" text NOT NULL DEFAULT '',
+    "co2" double precision NOT NULL,
+    "chosen_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS "travel_choice_user_id_idx" ON "travel_choice"("user_id");
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// maxSavedPlaces is how many saved places are shown as quick replies
+const maxSavedPlaces = 4
+
+// pinKey keeps the last location pin of the rider in session store
+func pinKey(lineUserID string) string {
+	return "pin:" + lineUserID
+}
+
+// rememberPin keeps the pin so the rider can save it with /places:add
+func (app *HailingApp) rememberPin(lineUserID string, coords [2]float64) {
+	buff, _ := json.Marshal(coords)
+	if err := app.sessions.Put(pinKey(lineUserID), buff, app.sessionTTL); err != nil {
+		log.Printf("[Places] remember pin: %v", err)
+	}
+}
+
+// lastPin returns the last location pin the rider sent
+func (app *HailingApp) lastPin(lineUserID string) ([2]float64, error) {
+	var coords [2]float64
+	result, err := app.sessions.Get(pinKey(lineUserID))
+	if err != nil {
+		return coords, errors.New("Send me a location pin first")
+	}
+	err = json.Unmarshal(result, &coords)
+	return coords, err
+}
+
+// SavedPlaces returns places the user saved; nil if anything goes wrong
+func (app *HailingApp) SavedPlaces(userID uuid.UUID) []SavedPlace {
+	if app.places == nil {
+		return nil
+	}
+	places, err := app.places.SavedPlaces(userID)
+	if err != nil {
+		log.Printf("[Places] %v", err)
+		return nil
+	}
+	return places
+}
+
+// FindSavedPlace returns the user's place by name, case-insensitive
+func (app *HailingApp) FindSavedPlace(userID uuid.UUID, name string) *SavedPlace {
+	name = strings.TrimSpace(name)
+	for _, place := range app.SavedPlaces(userID) {
+		if strings.EqualFold(place.Name, name) {
+			return &place
+		}
+	}
+	return nil
+}
+
+// SavedPlaceReply turns postback of a saved place into the answer
+func (app *HailingApp) SavedPlaceReply(lineUserID string, placeID string) (Reply, error) {
+	user, err := app.FindOrCreateUser(lineUserID)
+	if err != nil {
+		return Reply{}, err
+	}
+	ID, err := strconv.Atoi(placeID)
+	if err != nil {
+		return Reply{}, err
+	}
+	place, err := app.places.GetSavedPlace(ID)
+	if err != nil || place.UserID != user.ID {
+		return Reply{}, errors.New("Place not found")
+	}
+	return Reply{Text: place.Name, Coords: place.Place.Coordinates}, nil
+}
+
+// PlacesHandler handles /places commands:
+//
+//	/places                              list saved places
+//	/places:add:<name>                   save the last location pin
+//	/places:add:<name>:<trip id>:from|to save a place of the past trip
+//	/places:remove:<name>                remove the place
+func (app *HailingApp) PlacesHandler(replyToken string, lineUserID string, args []string) error {
+	user, localizer, err := app.Localizer(lineUserID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	if len(args) == 0 || args[0] == "" || args[0] == "list" {
+		return app.replyText(replyToken, app.placeList(user, localizer))
+	}
+	if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+		return app.replyText(replyToken, "missing arguments")
+	}
+	name := strings.TrimSpace(args[1])
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		// label of quick reply must be less than 20-char
+		if utf8.RuneCountInString(name) > 20 {
+			return app.replyText(replyToken, "Name must be less than 20 characters")
+		}
+		var coords [2]float64
+		if len(args) >= 4 {
+			coords, err = app.tripPlace(user, args[2], args[3])
+		} else {
+			coords, err = app.lastPin(lineUserID)
+		}
+		if err != nil {
+			return app.replyText(replyToken, err.Error())
+		}
+		if _, err := app.places.SavePlace(user.ID, name, coords); err != nil {
+			return app.replyText(replyToken, err.Error())
+		}
+		saved := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "PlaceSaved",
+				Other: "{{.Name}} is saved.",
+			},
+			TemplateData: map[string]string{
+				"Name": name,
+			},
+		})
+		return app.replyText(replyToken, saved)
+	case "remove":
+		if place := app.FindSavedPlace(user.ID, name); place != nil {
+			name = place.Name
+		}
+		if err := app.places.RemovePlace(user.ID, name); err != nil {
+			if err == ErrNotFound {
+				return app.replyText(replyToken, "Place not found")
+			}
+			return app.replyText(replyToken, err.Error())
+		}
+		removed := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "PlaceRemoved",
+				Other: "{{.Name}} is removed.",
+			},
+			TemplateData: map[string]string{
+				"Name": name,
+			},
+		})
+		return app.replyText(replyToken, removed)
+	}
+	return app.replyText(replyToken, "missing arguments")
+}
+
+// tripPlace returns pickup (from) or drop-off (to) of the user's trip
+func (app *HailingApp) tripPlace(user *User, tripID string, end string) ([2]float64, error) {
+	ID, err := strconv.Atoi(tripID)
+	if err != nil {
+		return [2]float64{}, errors.New("Trip not found")
+	}
+	trip, err := app.GetTripRecordByID(ID)
+	if err != nil || trip.UserID != user.ID {
+		return [2]float64{}, errors.New("Trip not found")
+	}
+	from, to, err := app.trips.GetTripPlaces(ID)
+	if err != nil {
+		return [2]float64{}, err
+	}
+	switch strings.ToLower(end) {
+	case "from":
+		return from, nil
+	case "to":
+		return to, nil
+	}
+	return [2]float64{}, fmt.Errorf("Unknown place: %q", end)
+}
+
+// placeList describes saved places of the user & commands
+func (app *HailingApp) placeList(user *User, localizer *i18n.Localizer) string {
+	places := app.SavedPlaces(user.ID)
+	if len(places) == 0 {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "NoSavedPlace",
+				Other: "You have no saved place. Send me a location pin, then /places:add:home to save it.",
+			},
+		})
+	}
+	lines := []string{}
+	for _, place := range places {
+		lines = append(lines, fmt.Sprintf("📍 %s (%.5f, %.5f)",
+			place.Name, place.Place.Coordinates[1], place.Place.Coordinates[0]))
+	}
+	lines = append(lines, "", "/places:add:<name>  /places:remove:<name>")
+	return strings.Join(lines, "\n")
+}
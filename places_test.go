@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSavedPlaces(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	home := [2]float64{100.5701, 13.7399}
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "nothing-yet", Text: "/places", Expect: "You have no saved place."},
+		{Name: "no-pin", Text: "/places:add:Home", Expect: "Send me a location pin first"},
+		{Name: "pin", Coords: home},
+		{Name: "add", Text: "/places:add:Home", Expect: "Home is saved."},
+		{Name: "list", Text: "/places:list", Expect: "📍 Home"},
+	})
+
+	replies := fl.SendText(app, rider, "call the cab")
+	question := replies[len(replies)-1]
+	if question.QuickReply == nil || question.QuickReply.Items[0].Action.Label != "Home" {
+		t.Fatalf("saved place must be the first quick reply: %+v", question.QuickReply)
+	}
+	homeData := question.QuickReply.Items[0].Action.Data
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "to", Postback: homeData, Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+		{Name: "passengers", Text: "1", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+	active, err := fl.Store.FindActiveReservation(rider)
+	if err != nil {
+		t.Fatal("trip not found: ", err)
+	}
+	if active.To != "Home" || active.ToCoords != home {
+		t.Errorf("trip must go home: %v", active)
+	}
+
+	other := "U" + uuid.New().String()
+	runConversation(t, fl, app, other, []fakeLineStep{
+		{Name: "not-yours", Postback: homeData, Expect: "Place not found"},
+		{Name: "not-your-trip", Text: fmt.Sprintf("/places:add:Gym:%d:from", active.TripID), Expect: "Trip not found"},
+	})
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "from-trip", Text: fmt.Sprintf("/places:add:Gym:%d:from", active.TripID), Expect: "Gym is saved."},
+		{Name: "remove", Text: "/places:remove:home", Expect: "Home is removed."},
+		{Name: "remove-again", Text: "/places:remove:home", Expect: "Place not found"},
+		{Name: "cancel", Text: "cancel", Expect: "Your reservation cancelled."},
+	})
+	user, _ := fl.Store.FindUserByLineID(rider)
+	places, _ := fl.Store.SavedPlaces(user.ID)
+	if len(places) != 1 || places[0].Name != "Gym" || places[0].Place.Coordinates != placeCoords("citi resort") {
+		t.Fatalf("unexpected places: %v", places)
+	}
+
+	// saved places by name and on location options
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "gym", Expect: "Pickup location?"},
+	})
+	replies = fl.SendPostback(app, rider, "location-options", time.Time{})
+	if len(replies) == 0 || !strings.Contains(string(replies[0].Contents), "📍 Gym") {
+		t.Errorf("saved place must be on location options: %v", replies)
+	}
+}
+
+func TestSnapPin(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	// about 110 m east of condo a, and nowhere near a location
+	nearCondo := [2]float64{100.5633, 13.7349}
+	nowhere := [2]float64{100.5701, 13.7399}
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Coords: nowhere, Expect: "Pickup location?"},
+		{Name: "from", Coords: nearCondo, Expect: "Meet your driver at condo a, 108 m walk from your pin."},
+	})
+	rec, _ := app.FindRecord(rider)
+	if !strings.HasSuffix(rec.To, " from citi resort") || rec.ToCoords != nowhere {
+		t.Errorf("pin without location nearby must be kept with its address: %v", rec)
+	}
+	if rec.From != "condo a" || rec.FromCoords != placeCoords("condo a") {
+		t.Errorf("pin must be snapped to condo a: %v", rec)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Routes from OSRM & Google come as encoded polylines (precision 5), see
+// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+// Points are [lon, lat] like everywhere else.
+
+// decodePolyline returns the points of an encoded polyline
+func decodePolyline(encoded string) ([][2]float64, error) {
+	var points [][2]float64
+	lat, lon := 0, 0
+	for i := 0; i < len(encoded); {
+		var deltas [2]int
+		for j := range deltas {
+			result, shift := 0, uint(0)
+			for {
+				if i >= len(encoded) {
+					return nil, errors.New("polyline: truncated")
+				}
+				b := int(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, errors.New("polyline: invalid character")
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^(result >> 1)
+			} else {
+				deltas[j] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lon += deltas[1]
+		points = append(points, [2]float64{float64(lon) / 1e5, float64(lat) / 1e5})
+	}
+	return points, nil
+}
+
+// encodePolyline is the reverse of decodePolyline
+func encodePolyline(points [][2]float64) string {
+	var sb strings.Builder
+	prevLat, prevLon := 0, 0
+	for _, p := range points {
+		lat := int(math.Round(p[1] * 1e5))
+		lon := int(math.Round(p[0] * 1e5))
+		encodePolylineValue(&sb, lat-prevLat)
+		encodePolylineValue(&sb, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return sb.String()
+}
+
+func encodePolylineValue(sb *strings.Builder, v int) {
+	v <<= 1
+	if v < 0 {
+		v = ^v
+	}
+	for v >= 0x20 {
+		sb.WriteByte(byte((0x20 | (v & 0x1f)) + 63))
+		v >>= 5
+	}
+	sb.WriteByte(byte(v + 63))
+}
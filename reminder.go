@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// reminderKey marks in session store that the trip's reminder is sent
+func reminderKey(tripID int) string {
+	return fmt.Sprintf("reminder:%d", tripID)
+}
+
+// claimReminder returns true only once per trip, even if several
+// instances share the session store
+func (app *HailingApp) claimReminder(tripID int, reservedAt time.Time, now time.Time) (bool, error) {
+	ttl := reservedAt.Sub(now) + app.reminderLeadTime
+	return app.sessions.CompareAndSwap(reminderKey(tripID), nil, []byte("sent"), ttl)
+}
+
+// releaseReminder gives up the claim of a reminder that isn't sent
+func (app *HailingApp) releaseReminder(tripID int) {
+	if err := app.sessions.Delete(reminderKey(tripID)); err != nil {
+		log.Printf("[Reminder] trip#%d release: %v", tripID, err)
+	}
+}
+
+// skipReminder is for trips booked within reminder lead time;
+// riders don't need to be asked again about what they just booked
+func (app *HailingApp) skipReminder(rec *ReservationRecord) {
+	now := time.Now()
+	if rec.ReservedAt.Sub(now) > app.reminderLeadTime {
+		return
+	}
+	if _, err := app.claimReminder(rec.TripID, rec.ReservedAt, now); err != nil {
+		log.Printf("[Reminder] skip trip#%d: %v", rec.TripID, err)
+	}
+}
+
+// RemindUpcomingTrips pushes "still need this ride?" to riders whose trip
+// starts within reminder lead time. It returns how many reminders are sent;
+// failed ones are tried again next time.
+func (app *HailingApp) RemindUpcomingTrips(now time.Time) (int, error) {
+	trips, err := app.trips.UpcomingTrips(now, now.Add(app.reminderLeadTime))
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for i := range trips {
+		trip := trips[i]
+		ok, err := app.claimReminder(trip.ID, *trip.ReservedAt, now)
+		if err != nil {
+			log.Printf("[Reminder] trip#%d: %v", trip.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		user, err := app.FindUserByID(trip.UserID)
+		if err != nil {
+			log.Printf("[Reminder] trip#%d user: %v", trip.ID, err)
+			app.releaseReminder(trip.ID)
+			continue
+		}
+		localizer := i18n.NewLocalizer(app.i18nBundle, user.Language)
+		if err := app.messenger.Push(user.LineUserID, app.ReminderFlex(&trip, localizer)); err != nil {
+			// let the next round try again
+			log.Printf("[Reminder] trip#%d push: %v", trip.ID, err)
+			app.releaseReminder(trip.ID)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// RunReminders checks upcoming trips every reminder interval until stop
+// is closed
+func (app *HailingApp) RunReminders(stop <-chan struct{}) {
+	runEvery(app.reminderInterval, stop, func(now time.Time) {
+		sent, err := app.RemindUpcomingTrips(now)
+		if err != nil {
+			log.Printf("[Reminder] %v", err)
+		} else if sent > 0 {
+			log.Printf("[Reminder] %d reminder(s) sent", sent)
+		}
+	})
+}
+
+// RunPopularity recomputes location popularity every popularity interval
+// until stop is closed
+func (app *HailingApp) RunPopularity(stop <-chan struct{}) {
+	runEvery(app.popularityInterval, stop, func(now time.Time) {
+		if err := app.locations.RecomputePopularity(now, app.popularityHalfLife); err != nil {
+			log.Printf("[Popularity] %v", err)
+		}
+	})
+}
+
+// runEvery calls job every interval until stop is closed
+func runEvery(interval time.Duration, stop <-chan struct{}, job func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			job(now)
+		}
+	}
+}
+
+// ReminderFlex asks if the rider still needs the upcoming trip
+func (app *HailingApp) ReminderFlex(trip *Trip, localizer *i18n.Localizer) Message {
+	title := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "StillNeedThisRide",
+			Other: "Still need this ride?",
+		},
+	})
+	yes := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Yes",
+			Other: "Yes",
+		},
+	})
+	no := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "NoCancelIt",
+			Other: "No, cancel it",
+		},
+	})
+	return app.TripAnswerFlex(trip, title, yes, no, localizer)
+}
+
+// TripAnswerFlex shows the trip with keep & cancel buttons which are
+// answered by ReminderHandler
+func (app *HailingApp) TripAnswerFlex(trip *Trip, title string, keep string, cancel string, localizer *i18n.Localizer) Message {
+	pickup := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Pickup",
+			Other: "Pickup",
+		},
+	})
+	to := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "To",
+			Other: "To",
+		},
+	})
+	timeLabel := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Time",
+			Other: "Time",
+		},
+	})
+	return RichCard{
+		AltText: title,
+		Title:   title,
+		Body: []CardBlock{
+			FieldBlock(pickup, trip.From),
+			FieldBlock(to, trip.To),
+			FieldBlock(timeLabel, PickupTimeText(*trip.ReservedAt)),
+		},
+		Buttons: []CardButton{
+			PostbackButton(cancel, fmt.Sprintf("reminder:no:%d", trip.ID)),
+			{
+				Label:  keep,
+				Action: ActionPostback,
+				Data:   fmt.Sprintf("reminder:yes:%d", trip.ID),
+				Text:   keep,
+				Style:  ButtonPrimary,
+				Color:  "#679AF0",
+			},
+		},
+		InlineButtons: true,
+	}
+}
+
+// ReminderHandler takes the answer to "still need this ride?";
+// the trip is cancelled if the rider doesn't need it anymore
+func (app *HailingApp) ReminderHandler(replyToken string, lineUserID string, answer string, tripID string) error {
+	user, localizer, err := app.Localizer(lineUserID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	ID, err := strconv.Atoi(tripID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	trip, err := app.GetTripRecordByID(ID)
+	if err == nil && trip.UserID != user.ID {
+		err = errors.New("Trip not found")
+	}
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	if trip.CancelledAt != nil {
+		return app.EndOfCancellation(replyToken, localizer)
+	}
+
+	if answer != "no" {
+		seeYou := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "SeeYouAt",
+				Other: "Great, see you at {{.Time}}.",
+			},
+			TemplateData: map[string]string{
+				"Time": PickupTimeText(*trip.ReservedAt),
+			},
+		})
+		return app.replyText(replyToken, seeYou)
+	}
+
+	_, err = app.CancelReservation(&ReservationRecord{TripID: ID})
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	app.UpdateCancellationReason(tripID, "No longer need a ride (reminder)")
+	if rec, err := app.FindRecord(lineUserID); err == nil && rec.TripID == ID {
+		app.Cleanup(lineUserID)
+	}
+	return app.EndOfCancellation(replyToken, localizer)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestScheduledTripReminder(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+	os.Setenv("BOOKING_HORIZON", "72h")
+	defer os.Unsetenv("BOOKING_HORIZON")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	now := time.Now()
+	pickupAt := now.Add(48 * time.Hour).Truncate(time.Minute)
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "too-far", Postback: "DATETIME", Datetime: now.Add(100 * time.Hour), Expect: "When?"},
+		{Name: "when", Postback: "DATETIME", Datetime: pickupAt, Expect: "How many passengers?"},
+		{Name: "passengers", Text: "1", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+	// riders who just booked don't need a reminder
+	soonRider := "U" + uuid.New().String()
+	runConversation(t, fl, app, soonRider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+		{Name: "passengers", Text: "1", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+
+	if sent, err := app.RemindUpcomingTrips(now); err != nil || sent != 0 {
+		t.Errorf("nothing to remind yet: %d %v", sent, err)
+	}
+	beforePickup := pickupAt.Add(-30 * time.Minute)
+	fl.FailPushes(true)
+	if sent, err := app.RemindUpcomingTrips(beforePickup); err != nil || sent != 0 {
+		t.Fatalf("failed push is not sent: %d %v", sent, err)
+	}
+	fl.FailPushes(false)
+	if sent, err := app.RemindUpcomingTrips(beforePickup); err != nil || sent != 1 {
+		t.Fatalf("expect 1 reminder: %d %v", sent, err)
+	}
+	if sent, _ := app.RemindUpcomingTrips(beforePickup.Add(time.Minute)); sent != 0 {
+		t.Errorf("reminder must be sent only once: %d", sent)
+	}
+	pushes := fl.Pushes(rider)
+	if len(pushes) != 1 || pushes[0].Summary() != "Still need this ride?" {
+		t.Fatalf("unexpected pushes: %v", pushes)
+	}
+
+	active, err := fl.Store.FindActiveReservation(rider)
+	if err != nil {
+		t.Fatal("trip not found: ", err)
+	}
+	runConversation(t, fl, app, soonRider, []fakeLineStep{
+		{Name: "not-yours", Postback: fmt.Sprintf("reminder:no:%d", active.TripID), Expect: "Trip not found"},
+	})
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "no", Postback: fmt.Sprintf("reminder:no:%d", active.TripID), Expect: "Your reservation cancelled."},
+		{Name: "status", Text: "status", Expect: "Start reservation"},
+	})
+	trip, _ := fl.Store.GetTrip(active.TripID)
+	if trip.CancelledAt == nil {
+		t.Fatal("trip must be cancelled")
+	}
+	cancelledAt := *trip.CancelledAt
+	if _, err := app.CancelReservation(&ReservationRecord{TripID: active.TripID}); err != ErrTripCancelled {
+		t.Errorf("cancelled trip can't be cancelled again: %v", err)
+	}
+	trip, _ = fl.Store.GetTrip(active.TripID)
+	if !trip.CancelledAt.Equal(cancelledAt) {
+		t.Errorf("cancellation time is overwritten: %v", trip.CancelledAt)
+	}
+}
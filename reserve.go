@@ -4,42 +4,43 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v7"
 	"github.com/google/uuid"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
-	"github.com/paulmach/orb"
-	"github.com/paulmach/orb/geojson"
-	"github.com/paulmach/orb/planar"
 )
 
 // ReservationRecord : whole process record
 type ReservationRecord struct {
-	State           string     `json:"state"` // i.e. init, to, from, when, final -> done
-	Waiting         string     `json:"waiting"`
-	From            string     `json:"from"`
-	FromCoords      [2]float64 `json:"from_coords"`
-	To              string     `json:"to"`
-	ToCoords        [2]float64 `json:"to_coords"`
-	UserID          uuid.UUID  `json:"user_id"` // this is our system id, not line's
-	LineUserID      string     `json:"line_user_id"`
-	DriverID        string     `json:"driver_id"`
-	ReservedAt      time.Time  `json:"reserved_at"`
-	PickedUpAt      time.Time  `json:"picked_up_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
-	TripID          int        `json:"trip_id"` // postgresql id
-	IsConfirmed     bool       `json:"is_confirmed"`
-	Polyline        string     `json:"polyline"`
-	NumOfPassengers int        `json:"num_of_passengers"` // postgresql id
+	State           ReservationState `json:"state"` // i.e. init, to, from, when, final -> done
+	Waiting         ReservationState `json:"waiting"`
+	From            string           `json:"from"`
+	FromCoords      [2]float64       `json:"from_coords"`
+	To              string           `json:"to"`
+	ToCoords        [2]float64       `json:"to_coords"`
+	UserID          uuid.UUID        `json:"user_id"` // this is our system id, not line's
+	LineUserID      string           `json:"line_user_id"`
+	DriverID        string           `json:"driver_id"`
+	ReservedAt      time.Time        `json:"reserved_at"`
+	PickedUpAt      time.Time        `json:"picked_up_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+	TripID          int              `json:"trip_id"` // postgresql id
+	IsConfirmed     bool             `json:"is_confirmed"`
+	Polyline        string           `json:"polyline"`
+	NumOfPassengers int              `json:"num_of_passengers"` // postgresql id
+	TravelTime      float64          `json:"travel_time"`       // by car in second
+	Fare            float64          `json:"fare"`              // estimated, see FareTable
 	// DroppedOffAt time.Time  `json:"dropped_off_at"`
 }
 
+// ErrRecordChanged is returned when the record was updated by another
+// request while we were processing it
+var ErrRecordChanged = errors.New("Your reservation was just updated, please try again")
+
 // Reply : to store reply in various message type
 type Reply struct {
 	Text     string     `json:"text"`
@@ -47,8 +48,8 @@ type Reply struct {
 	Coords   [2]float64 `json:"coords"`
 }
 
-// QuickReplyButton contains necessary info for linebot.NewQuickReplyButton
-// either postback or message is fine. Type & Data are for Postback
+// QuickReplyButton contains necessary info for a quick reply button.
+// Type is one of Action* (message if empty). Data is for postback & datetime
 type QuickReplyButton struct {
 	Image string `json:"image"`
 	Label string `json:"label"`
@@ -67,47 +68,17 @@ type Question struct {
 }
 
 // WhatsNext : to ask what should be the next step
-func (record *ReservationRecord) WhatsNext() string {
+func (record *ReservationRecord) WhatsNext() ReservationState {
 	/* Step is as follows
 
-	init -> to -> from -> when -> final -> done
+	init -> to -> from -> when -> num_of_passengers -> final -> done -> pickup
 
-	redis record will not live long anymore
+	see reservationFSM for the whole diagram
 	*/
 	done, missing := record.IsComplete()
 	if done {
-		record.State = "done"
-	}
-
-	// all states are init, from, to, when, num_of_passengers
-	switch record.State {
-	case "init":
-		// TODO: checkout if drivers are all occupied or not. If so, pickup time first.
-		return "to"
-	case "to":
-		if record.To == "" {
-			return "to"
-		}
-	case "from":
-		if record.From == "" {
-			return "from"
-		}
-	case "when":
-		if record.ReservedAt.Format("2006-01-01") == "0001-01-01" {
-			return "when"
-		}
-		// there is a chance that when starts first if all drivers are occupied.
-		if record.To == "" {
-			return "to"
-		}
-	case "num_of_passengers":
-		if record.NumOfPassengers == 0 {
-			return "num_of_passengers"
-		}
-	case "final":
-		return "done"
-	case "done":
-		return "pickup"
+		record.State = reservationFSM.Complete
+		return reservationFSM.Waiting
 	}
 	return missing
 }
@@ -122,12 +93,13 @@ func (app *HailingApp) Cancel(userID string) (int, error) {
 
 	if rec.TripID != -1 {
 		_, err := app.CancelReservation(rec)
-		if err != nil && !strings.Contains(err.Error(), "no rows in result set") {
+		// a trip which is gone or cancelled only leaves the session to clear
+		if err != nil && err != ErrNotFound && err != ErrTripCancelled {
 			return -1, err
 		}
 	}
 	// if there is no tripID yet, then continue with cancel process
-	_, err = app.rdb.Del(userID).Result()
+	err = app.sessions.Delete(recordKey(userID))
 	if err != nil {
 		return -1, err
 	}
@@ -135,14 +107,10 @@ func (app *HailingApp) Cancel(userID string) (int, error) {
 
 }
 
-// Cleanup meant to clear all redis cache out of the system
+// Cleanup meant to clear all session data out of the system
 func (app *HailingApp) Cleanup(userID string) error {
 	// if there is no tripID yet, then continue with cancel process
-	_, err := app.rdb.Del(userID).Result()
-	if err != nil {
-		return err
-	}
-	return nil
+	return app.sessions.Delete(recordKey(userID))
 }
 
 // NextStep will return next state and update the state of the record to that
@@ -154,90 +122,149 @@ func (app *HailingApp) NextStep(userID string) (*ReservationRecord, string) {
 	nextStep := rec.WhatsNext()
 	rec.Waiting = nextStep
 
-	err = app.SaveRecordToRedis(rec)
+	err = app.SaveRecord(rec)
 	if err != nil {
 		return nil, "-"
 	}
-	return rec, nextStep
+	return rec, string(nextStep)
 }
 
 // DoneAndSave is to record this completed reservation to a permanent medium (postgresl)
 func (app *HailingApp) DoneAndSave(lineUserID string) (int, error) {
 	// Double check
-	result, err := app.rdb.Get(lineUserID).Result()
+	result, err := app.sessions.Get(recordKey(lineUserID))
 	if err != nil {
 		return -1, errors.New("There is a problem")
 	}
 	var rec ReservationRecord
-	json.Unmarshal([]byte(result), &rec)
+	json.Unmarshal(result, &rec)
 	if rec.From == "" || rec.To == "" || rec.ReservedAt.Format("2006-01-01") == "0001-01-01" {
 		return -1, errors.New("Something is wrong [ERR: R76]")
 	}
 	return app.SaveReservationToPostgres(&rec)
 }
 
-// IsComplete is a shorthand to check if record is filled
-// return IsComplete & missing state
-func (record *ReservationRecord) IsComplete() (bool, string) {
-	if record.To == "" {
-		return false, "to"
-	}
-	if record.From == "" {
-		return false, "from"
+// EditRecord rewinds the record on confirmation step, or the confirmed
+// trip which is waiting for pickup, to the given state so the rider can
+// answer it again
+func (app *HailingApp) EditRecord(lineUserID string, state ReservationState) (*ReservationRecord, error) {
+	rec, old, err := app.loadRecord(lineUserID)
+	if err != nil {
+		return nil, err
 	}
-	if record.ReservedAt.Format("2006-01-01") == "0001-01-01" {
-		return false, "when"
+	switch {
+	case rec.Waiting == StateFinal:
+	case rec.Waiting == StatePickup && rec.TripID != -1:
+		if err := app.CheckTripChangeable(rec); err != nil {
+			return rec, err
+		}
+	default:
+		return rec, &TransitionError{From: rec.Waiting, To: state}
 	}
-	if record.NumOfPassengers == 0 {
-		return false, "num_of_passengers"
+	if err := reservationFSM.Rewind(rec, state); err != nil {
+		return rec, err
 	}
-	if !record.IsConfirmed {
-		return false, "final"
+	rec.UpdatedAt = time.Now()
+	if err := app.UpdateRecord(old, rec); err != nil {
+		return nil, err
 	}
-	return true, "done"
+	return rec, nil
+}
+
+// IsComplete is a shorthand to check if record is filled
+// return IsComplete & missing state
+func (record *ReservationRecord) IsComplete() (bool, ReservationState) {
+	return reservationFSM.IsComplete(record)
+}
+
+// recordKey is the session key of user's ReservationRecord
+func recordKey(lineUserID string) string {
+	return "reservation:" + lineUserID
 }
 
-// SaveRecordToRedis which save ReservationRecord to redis for faster process
-func (app *HailingApp) SaveRecordToRedis(record *ReservationRecord) error {
+// SaveRecord which save ReservationRecord to session store for faster process
+func (app *HailingApp) SaveRecord(record *ReservationRecord) error {
 	buff, _ := json.Marshal(&record)
-	cacheDuration := 10 * time.Minute
 	// log.Printf("[ProcessReservationStep] post_status_change: %s \n   >> record: %v", rec.State, rec.UpdatedAt)
-	if err := app.rdb.Set(record.LineUserID, buff, cacheDuration).Err(); err != nil {
-		log.Printf("Redis Error: %v", err)
+	if err := app.sessions.Put(recordKey(record.LineUserID), buff, app.sessionTTL); err != nil {
+		log.Printf("Session Error: %v", err)
 		return err
 	}
 	return nil
 }
 
-// FindRecord : this is the one to ask if we have any reservation
-func (app *HailingApp) FindRecord(lineUserID string) (*ReservationRecord, error) {
-	result, err := app.rdb.Get(lineUserID).Result()
-	if err != nil || err == redis.Nil {
-		// Redis doesn't do, PostgreSQL will take over
+// UpdateRecord saves record only if nobody has changed it since it was
+// loaded as old. Otherwise ErrRecordChanged is returned.
+func (app *HailingApp) UpdateRecord(old []byte, record *ReservationRecord) error {
+	buff, _ := json.Marshal(&record)
+	ok, err := app.sessions.CompareAndSwap(recordKey(record.LineUserID), old, buff, app.sessionTTL)
+	if err != nil {
+		log.Printf("Session Error: %v", err)
+		return err
+	}
+	if !ok {
+		return ErrRecordChanged
+	}
+	return nil
+}
+
+// loadRecord returns the record and its raw value in session store
+func (app *HailingApp) loadRecord(lineUserID string) (*ReservationRecord, []byte, error) {
+	result, err := app.sessions.Get(recordKey(lineUserID))
+	if err != nil {
+		// session store doesn't have it, trip store will take over
 		rec, err := app.FindActiveReservation(lineUserID)
 		if err != nil {
-			return nil, errors.New("No record found")
+			return nil, nil, errors.New("No record found")
 		}
-		// save to redis before return
-		err = app.SaveRecordToRedis(rec)
+		// save to session store before return
+		buff, _ := json.Marshal(rec)
+		err = app.sessions.Put(recordKey(lineUserID), buff, app.sessionTTL)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		return rec, nil
+		return rec, buff, nil
 	}
 	var rec ReservationRecord
-	json.Unmarshal([]byte(result), &rec)
-	return &rec, nil
+	json.Unmarshal(result, &rec)
+	return &rec, result, nil
+}
+
+// FindRecord : this is the one to ask if we have any reservation
+func (app *HailingApp) FindRecord(lineUserID string) (*ReservationRecord, error) {
+	rec, _, err := app.loadRecord(lineUserID)
+	return rec, err
+}
+
+// HasRecord tells if the rider is booking or has a trip. Unlike FindRecord,
+// the trip isn't loaded into session store.
+func (app *HailingApp) HasRecord(lineUserID string) (bool, error) {
+	if _, err := app.sessions.Get(recordKey(lineUserID)); err == nil {
+		return true, nil
+	}
+	_, err := app.trips.FindActiveReservation(lineUserID)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 // FindOrCreateRecord : this is the one to start everything
 func (app *HailingApp) FindOrCreateRecord(lineUserID string) (*ReservationRecord, error) {
+	rec, _, err := app.findOrCreateRecord(lineUserID)
+	return rec, err
+}
+
+func (app *HailingApp) findOrCreateRecord(lineUserID string) (*ReservationRecord, []byte, error) {
 	// fmt.Println("Reserve: ", lineUserID)
-	rec, err := app.FindRecord(lineUserID)
+	rec, raw, err := app.loadRecord(lineUserID)
 	if err != nil {
-		return app.initReservation(lineUserID)
+		if _, err := app.initReservation(lineUserID); err != nil {
+			return nil, nil, err
+		}
+		return app.loadRecord(lineUserID)
 	}
-	return rec, nil
+	return rec, raw, nil
 }
 
 func (app *HailingApp) initReservation(lineUserID string) (*ReservationRecord, error) {
@@ -256,31 +283,47 @@ func (app *HailingApp) InitReservation(user User) (*ReservationRecord, error) {
 	newRecord := ReservationRecord{
 		UserID:     user.ID,
 		LineUserID: user.LineUserID,
-		State:      "init",
-		Waiting:    "to",
+		State:      StateInit,
+		Waiting:    StateTo,
 		TripID:     -1,
 	}
 
-	err := app.SaveRecordToRedis(&newRecord)
+	err := app.SaveRecord(&newRecord)
 	if err != nil {
-		log.Printf("SAVE to Redis FAILED: %v", err)
+		log.Printf("SAVE to session FAILED: %v", err)
 		return nil, err
 	}
-	log.Printf("SAVE to Redis OK: %v", newRecord)
+	log.Printf("SAVE to session OK: %v", newRecord)
 	return &newRecord, nil
 }
 
+// QuickReplyLocations offers the rider's saved places first, then the
+// places suggested by the rider's trips
 func (app *HailingApp) QuickReplyLocations(record *ReservationRecord) []QuickReplyButton {
-	// NOTE: it should consider user's history too actually
 	results := []QuickReplyButton{}
 	user, _, err := app.Localizer(record.LineUserID)
 	if err != nil {
 		return results
 	}
-	locations, err := app.GetLocations(user.Language, 4)
+	for _, place := range app.SuggestSavedPlaces(record, app.SavedPlaces(user.ID)) {
+		if place.Place.Coordinates == record.FromCoords || place.Place.Coordinates == record.ToCoords {
+			continue
+		}
+		results = append(results, QuickReplyButton{
+			Label: place.Name,
+			Type:  "postback",
+			Data:  fmt.Sprintf("place:%d", place.ID),
+		})
+		if len(results) == maxSavedPlaces {
+			break
+		}
+	}
+
+	locations, err := app.SuggestLocations(record, user.Language, 4)
 	if err != nil {
 		return results
 	}
+	popular := 0
 	for _, loc := range locations {
 		if loc.Place.Coordinates == record.FromCoords || loc.Place.Coordinates == record.ToCoords {
 			continue
@@ -302,7 +345,8 @@ func (app *HailingApp) QuickReplyLocations(record *ReservationRecord) []QuickRep
 			Data:  txt,
 		})
 
-		if len(results) == 3 { // 3 records max
+		popular++
+		if popular == 3 { // 3 records max
 			break
 		}
 	}
@@ -311,141 +355,30 @@ func (app *HailingApp) QuickReplyLocations(record *ReservationRecord) []QuickRep
 
 // QuestionToAsk returns a question appropriate for each state
 func (app *HailingApp) QuestionToAsk(record *ReservationRecord, localizer *i18n.Localizer) Question {
-	// step: init -> to -> from -> when -> final -> done
-	switch strings.ToLower(record.Waiting) {
-	case "to":
-		buttons := app.QuickReplyLocations(record)
-		return Question{
-			Text: localizer.MustLocalize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "WhereTo",
-					Other: "Where to?",
-				},
-			}),
-			Buttons:       buttons,
-			LocationInput: true,
-		}
-	case "from":
-		buttons := app.QuickReplyLocations(record)
-		return Question{
-			Text: localizer.MustLocalize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "PickupLocation",
-					Other: "Pickup location?",
-				},
-			}),
-			Buttons:       buttons,
-			LocationInput: true,
-		}
-	case "when":
-		buttons := []QuickReplyButton{
-			{
-				Label: "Now",
-				Text:  "now",
-			},
-			{
-				Label: localizer.MustLocalize(&i18n.LocalizeConfig{
-					DefaultMessage: &i18n.Message{
-						ID:    "InXMin",
-						Other: "In {{.Min}} mins",
-					},
-					TemplateData: map[string]string{
-						"Min": "15",
-					},
-				}),
-				Text: "+15min",
-			},
-			{
-				Label: localizer.MustLocalize(&i18n.LocalizeConfig{
-					DefaultMessage: &i18n.Message{
-						ID:    "InXMin",
-						Other: "In {{.Min}} mins",
-					},
-					TemplateData: map[string]string{
-						"Min": "30",
-					},
-				}),
-				Text: "+30min",
-			},
-		}
-		return Question{
-			Text: localizer.MustLocalize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "When",
-					Other: "When?",
-				},
-			}),
-			Buttons:       buttons,
-			DatetimeInput: true,
-		}
-	case "num_of_passengers":
-		buttons := []QuickReplyButton{
-			{
-				Label: "1",
-				Text:  "1",
-			},
-			{
-				Label: "2",
-				Text:  "2",
-			},
-			{
-				Label: "3",
-				Text:  "3",
-			},
-			{
-				Label: "4",
-				Text:  "4",
-			},
-		}
-		return Question{
-			Text: localizer.MustLocalize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "HowManyPassengers",
-					Other: "How many passengers?",
-				},
-			}),
-			Buttons: buttons,
-		}
-	case "final":
-		return Question{
-			Text: localizer.MustLocalize(&i18n.LocalizeConfig{
-				DefaultMessage: &i18n.Message{
-					ID:    "Confirm",
-					Other: "Confirm",
-				},
-			}),
-			YesInput: true,
-		}
-	}
-	return Question{
-		Text: "n/a",
-	}
+	return reservationFSM.Question(app, record, localizer)
 }
 
 // IsLocation validates if the location is in the service area
 func IsLocation(reply Reply) (bool, error) {
 	if reply.Coords != [2]float64{0, 0} {
-		b, _ := ioutil.ReadFile("./static/service_area.json")
-		feature, _ := geojson.UnmarshalFeature(b)
-		pnt := orb.Point(reply.Coords)
-		// NOTE: change to postgis if accuracy needed
-		if planar.PolygonContains(feature.Geometry.Bound().ToPolygon(), pnt) {
-			return true, nil
+		if _, err := CurrentServiceArea().Locate(reply.Coords); err != nil {
+			return false, err
 		}
-		return false, errors.New("Outside service area")
+		return true, nil
 	}
 	locPostback := strings.Split(reply.Text, ":")
 	if len(locPostback) > 1 && locPostback[0] == "location" {
 		return true, nil
 	}
 	// check for text if it's match
-	if IsThisIn(strings.ToLower(reply.Text), TargetPlaces) {
+	if Vocabulary().Words().FindPlace(reply.Text) != nil {
 		return true, nil
 	}
 	return false, errors.New("Not a location")
 }
 
-func isTime(reply Reply) (*time.Time, error) {
+// isTime takes pickup time from reply, which must be within horizon from now
+func isTime(reply Reply, horizon time.Duration) (*time.Time, error) {
 	var t time.Time
 	now := time.Now()
 
@@ -476,153 +409,427 @@ func isTime(reply Reply) (*time.Time, error) {
 			t = now.Add(duration * time.Hour)
 		}
 	}
+	return &t, checkPickupTime(t, now, horizon)
+}
+
+// checkPickupTime makes sure t is neither in the past nor beyond horizon
+func checkPickupTime(t time.Time, now time.Time, horizon time.Duration) error {
 	diffFromNow := t.Sub(now)
 	if diffFromNow.Minutes() < 0 {
 		// log.Printf("[isTime] %v \n", diffFromNow)
-		return &t, errors.New("Time is in the past")
+		return errors.New("Time is in the past")
 	}
-	if diffFromNow.Hours() > 24 {
+	if diffFromNow > horizon {
 		// log.Printf("[isTime] %v \n", diffFromNow)
-		return &t, errors.New("Only allow 24-hr in advance")
+		return fmt.Errorf("Only allow %.0f-hr in advance", horizon.Hours())
 	}
-	return &t, nil
+	return nil
 }
 
 // ProcessReservationStep will handle every step of reservation
 func (app *HailingApp) ProcessReservationStep(userID string, reply Reply) (*ReservationRecord, error) {
 
-	rec, err := app.FindOrCreateRecord(userID)
+	rec, old, err := app.findOrCreateRecord(userID)
 	if err != nil {
 		return nil, errors.New("There is a problem")
 	}
 
 	log.Printf("[ProcessReservationStep] GOT record: %v", rec)
 
-	switch rec.Waiting {
-	case "from":
-		_, err := IsLocation(reply)
-		if err != nil {
+	// the rider is changing the trip after confirmation (see EditRecord)
+	changing := rec.TripID != -1 && rec.IsConfirmed && rec.Waiting != StatePickup
+	if changing {
+		if err := app.CheckTripChangeable(rec); err != nil {
+			// too late, go back to the trip as it is
+			app.Cleanup(userID)
+			if trip, findErr := app.FindRecord(userID); findErr == nil {
+				return trip, err
+			}
 			return rec, err
 		}
-		if reply.Coords != [2]float64{0, 0} {
-			rec.From = "custom"
-			rec.FromCoords = reply.Coords
-			// if it's not from LocationInput
-			if strings.Index(reply.Text, "location:") == -1 {
-				rec.From = reply.Text
+	}
+
+	err = reservationFSM.Fire(app, rec, reply)
+	if err != nil {
+		log.Printf("[ProcessReservationStep] %s: %v", rec.Waiting, err)
+		return rec, err
+	}
+	rec.UpdatedAt = time.Now() // always show the last updated timestamp
+	if changing {
+		// old polyline is no longer valid even if we can't get the new one
+		rec.Polyline, rec.TravelTime, rec.Fare = "", 0, 0
+		if _, err := app.ValidateTrip(rec); err != nil {
+			// the change isn't saved, the rider answers again
+			log.Printf("[ProcessReservationStep] changed trip: %v", err)
+			if trip, findErr := app.FindRecord(userID); findErr == nil {
+				return trip, err
 			}
-		} else {
-			locPostback := strings.Split(reply.Text, ":")
-			if len(locPostback) > 1 && locPostback[0] == "location" {
-				log.Printf("[ProcessReservationStep] location-postback: %v", reply.Text)
-				ID, err := strconv.Atoi(locPostback[2])
-				if err != nil {
-					return rec, err
-				}
-				loc, err := app.GetLocationByID(ID)
-				if err != nil {
-					return rec, err
-				}
-				rec.From = loc.Name
-				rec.FromCoords = loc.Place.Coordinates
-			} else {
-				log.Printf("[ProcessReservationStep] location-text: %v", reply.Text)
-				rec.From = reply.Text
-				rec.FromCoords = GetCoordsFromPlace(reply.Text)
+			return rec, err
+		}
+	} else if rec.Waiting == StateFinal {
+		if err := app.checkTrip(rec); err != nil {
+			if uerr := app.UpdateRecord(old, rec); uerr != nil {
+				return nil, uerr
 			}
+			return rec, err
 		}
-	case "to":
-		_, err := IsLocation(reply)
+	}
+
+	log.Printf("[ProcessReservationStep] status_change: %s -> %s \n   >> record: %v", rec.State, rec.Waiting, rec.UpdatedAt)
+	if rec.State == StateDone {
+		tripID, err := app.SaveReservationToPostgres(rec)
 		if err != nil {
 			return rec, err
 		}
-		if reply.Coords != [2]float64{0, 0} {
-			rec.To = "custom"
-			rec.ToCoords = reply.Coords
-			// if it's not from LocationInput
-			if strings.Index(reply.Text, "location:") == -1 {
-				rec.To = reply.Text
-			}
-		} else {
-			locPostback := strings.Split(reply.Text, ":")
-			if len(locPostback) > 1 && locPostback[0] == "location" {
-				log.Printf("[ProcessReservationStep] location-postback: %v", reply.Text)
-				ID, err := strconv.Atoi(locPostback[2])
-				if err != nil {
-					return rec, err
-				}
-				loc, err := app.GetLocationByID(ID)
-				if err != nil {
-					return rec, err
-				}
-				rec.To = loc.Name
-				rec.ToCoords = loc.Place.Coordinates
-			} else {
-				log.Printf("[ProcessReservationStep] location-text: %v", reply.Text)
-				rec.To = reply.Text
-				rec.ToCoords = GetCoordsFromPlace(reply.Text)
+		rec.TripID = tripID
+		app.skipReminder(rec)
+	}
+	err = app.UpdateRecord(old, rec)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// reservationFSM drives the whole reservation conversation
+var reservationFSM = NewStateMachine(StateDone, StatePickup,
+	StateDef{
+		State: StateInit,
+		Label: "Start reservation",
+		Next:  []ReservationState{StateTo},
+	},
+	StateDef{
+		State:    StateTo,
+		Label:    "Where to?",
+		Filled:   func(rec *ReservationRecord) bool { return rec.To != "" },
+		Apply:    applyTo,
+		Question: questionTo,
+		Next:     []ReservationState{StateFrom},
+	},
+	StateDef{
+		State:    StateFrom,
+		Label:    "Pickup location?",
+		Filled:   func(rec *ReservationRecord) bool { return rec.From != "" },
+		Apply:    applyFrom,
+		Question: questionFrom,
+		Next:     []ReservationState{StateWhen},
+	},
+	StateDef{
+		State: StateWhen,
+		Label: "When?",
+		Filled: func(rec *ReservationRecord) bool {
+			return rec.ReservedAt.Format("2006-01-01") != "0001-01-01"
+		},
+		Apply:    applyWhen,
+		Question: questionWhen,
+		// there is a chance that when starts first if all drivers are occupied.
+		Next: []ReservationState{StateNumOfPassengers, StateTo},
+	},
+	StateDef{
+		State:    StateNumOfPassengers,
+		Label:    "How many passengers?",
+		Filled:   func(rec *ReservationRecord) bool { return rec.NumOfPassengers != 0 },
+		Apply:    applyNumOfPassengers,
+		Question: questionNumOfPassengers,
+		Next:     []ReservationState{StateFinal},
+	},
+	StateDef{
+		State:    StateFinal,
+		Label:    "Confirm",
+		Filled:   func(rec *ReservationRecord) bool { return rec.IsConfirmed },
+		Apply:    applyFinal,
+		Question: questionFinal,
+		// riders can go back to edit any answer before confirming
+		Next: []ReservationState{StateDone, StateTo, StateFrom, StateWhen, StateNumOfPassengers},
+	},
+	StateDef{
+		State: StateDone,
+		Label: "Saved to trip",
+		Next:  []ReservationState{StatePickup},
+	},
+	StateDef{
+		State: StatePickup,
+		Label: "Waiting for pickup",
+		Apply: applyPickup,
+		// modify pickup time saves the trip again, and riders can change
+		// places & passengers until a driver is assigned
+		Next: []ReservationState{StateDone, StateTo, StateFrom, StateNumOfPassengers},
+	},
+)
+
+// applyLocation takes location from pin, LocationOptionFlex postback or text
+// which must be inside the service area
+func (app *HailingApp) applyLocation(rec *ReservationRecord, reply Reply) (string, [2]float64, error) {
+	name, coords, err := app.findLocation(rec, reply)
+	if err != nil {
+		return "", [2]float64{}, err
+	}
+	if _, err := CurrentServiceArea().Locate(coords); err != nil {
+		return "", [2]float64{}, err
+	}
+	return name, coords, nil
+}
+
+// findLocation reads location of reply; text can be the name of the
+// rider's saved place or any name of a location in the location table,
+// typos included
+func (app *HailingApp) findLocation(rec *ReservationRecord, reply Reply) (string, [2]float64, error) {
+	if reply.Coords == [2]float64{0, 0} && !strings.HasPrefix(reply.Text, "location:") {
+		if place := app.FindSavedPlace(rec.UserID, reply.Text); place != nil {
+			return place.Name, place.Place.Coordinates, nil
+		}
+		match, err := app.MatchLocationText(reply.Text)
+		if err != nil {
+			return "", [2]float64{}, err
+		}
+		if match != nil {
+			name := match.Matched
+			if match.Exact {
+				// as the rider typed it
+				name = reply.Text
 			}
+			return name, match.Place.Coordinates, nil
+		}
+	}
+	_, err := IsLocation(reply)
+	if err != nil {
+		return "", [2]float64{}, err
+	}
+	if reply.Coords != [2]float64{0, 0} {
+		// a pin is where the driver meets the rider at the nearest
+		// pickup point, if there's one close by
+		if point := app.SnapPin(reply.Coords); point != nil {
+			return point.Name, point.Place.Coordinates, nil
 		}
-	case "when":
-		tm, err := isTime(reply)
+		// LINE location message has no text, LocationInput has the name
+		if reply.Text != "" && strings.Index(reply.Text, "location:") == -1 {
+			return reply.Text, reply.Coords, nil
+		}
+		name := "custom"
+		if address, err := app.geocoder.ReverseGeocode(reply.Coords); err == nil {
+			name = address
+		}
+		return name, reply.Coords, nil
+	}
+	locPostback := strings.Split(reply.Text, ":")
+	if len(locPostback) > 1 && locPostback[0] == "location" {
+		log.Printf("[ProcessReservationStep] location-postback: %v", reply.Text)
+		ID, err := strconv.Atoi(locPostback[2])
 		if err != nil {
-			log.Printf("[ProcessReservationStep] when: %v %v \n", tm, err)
-			return rec, err
+			return "", [2]float64{}, err
 		}
-		rec.ReservedAt = *tm
-	case "num_of_passengers":
-		num, err := strconv.Atoi(reply.Text)
+		loc, err := app.GetLocationByID(ID)
 		if err != nil {
-			log.Printf("[ProcessReservationStep] num_of_passengers: '%v' is not number \n", reply.Text)
-			return rec, err
+			return "", [2]float64{}, err
 		}
-		rec.NumOfPassengers = num
-	case "final":
-		// if it's confirmed, then it's done
-		var yesWords = []string{"last-step-confirmation", "confirm", "yes"}
-		if IsThisIn(reply.Text, yesWords) {
-			rec.IsConfirmed = true
+		return loc.Name, loc.Place.Coordinates, nil
+	}
+	log.Printf("[ProcessReservationStep] location-text: %v", reply.Text)
+	return reply.Text, GetCoordsFromPlace(reply.Text), nil
+}
+
+func applyTo(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	name, coords, err := app.applyLocation(rec, reply)
+	if err != nil {
+		return err
+	}
+	rec.To = name
+	rec.ToCoords = coords
+	return nil
+}
+
+func applyFrom(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	name, coords, err := app.applyLocation(rec, reply)
+	if err != nil {
+		return err
+	}
+	if !rec.ReservedAt.IsZero() {
+		if err := CurrentServiceArea().CheckOpen(coords, rec.ReservedAt); err != nil {
+			return err
 		}
-	case "done":
-		// nothing to save here save record to postgres
-	// case "pickup":  //
-	// 1st case is "modify-pickup-time"
-	// if reply.Text == "modify-pickup-time" {
-	// 	tm, err := isTime(reply)
-	// 	if err != nil {
-	// 		return rec, err
-	// 	}
-	// 	rec.ReservedAt = *tm
-	// }
-	default:
-		// or "pickup" state -- it could
-		if reply.Text == "modify-pickup-time" {
-			tm, err := isTime(reply)
-			if err != nil {
-				return rec, err
+	}
+	rec.From = name
+	rec.FromCoords = coords
+	return nil
+}
+
+func applyWhen(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	tm, err := isTime(reply, app.bookingHorizon)
+	if tm == nil {
+		// words, e.g. "tomorrow 7am", are echoed back to be confirmed
+		// unless it's right now
+		now := time.Now()
+		if at, ok := ParseTime(reply.Text, now); ok {
+			if err := CurrentServiceArea().CheckOpen(rec.FromCoords, at); err != nil {
+				return err
 			}
-			rec.ReservedAt = *tm
-		} else {
-			return rec, errors.New("Wrong state")
+			if at.Sub(now) < time.Minute {
+				rec.ReservedAt = now
+				return nil
+			}
+			if err := checkPickupTime(at, now, app.bookingHorizon); err != nil {
+				return err
+			}
+			return &TimeConfirmationError{At: at}
 		}
 	}
-	log.Printf("[ProcessReservationStep] pre_status_change: %s \n   >> record: %v", rec.State, rec.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := CurrentServiceArea().CheckOpen(rec.FromCoords, *tm); err != nil {
+		return err
+	}
+	rec.ReservedAt = *tm
+	return nil
+}
 
-	rec.State = rec.Waiting
-	rec.Waiting = rec.WhatsNext()
-	rec.UpdatedAt = time.Now() // always show the last updated timestamp
+// PassengersError is returned for a number of passengers other than
+// 1 to Max
+type PassengersError struct {
+	Text string
+	Max  int
+}
 
-	log.Printf("[ProcessReservationStep] mid_status_change: %s \n   >> record: %v", rec.State, rec.UpdatedAt)
-	if rec.State == "done" {
-		tripID, err := app.SaveReservationToPostgres(rec)
-		if err != nil {
-			return rec, err
-		}
-		rec.TripID = tripID
+func (e *PassengersError) Error() string {
+	return fmt.Sprintf("%q passengers, it must be 1 to %d", e.Text, e.Max)
+}
+
+func applyNumOfPassengers(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	num, err := strconv.Atoi(strings.TrimSpace(reply.Text))
+	if err != nil || num < 1 || num > app.maxPassengers {
+		log.Printf("[ProcessReservationStep] num_of_passengers: '%v' is out of range\n", reply.Text)
+		return &PassengersError{Text: reply.Text, Max: app.maxPassengers}
 	}
-	err = app.SaveRecordToRedis(rec)
-	if err != nil {
-		return nil, err
+	rec.NumOfPassengers = num
+	return nil
+}
+
+func applyFinal(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	// if it's confirmed, then it's done
+	var yesWords = []string{"last-step-confirmation", "confirm", "yes"}
+	if !IsThisIn(reply.Text, yesWords) {
+		return errors.New("Please confirm your reservation")
+	}
+	rec.IsConfirmed = true
+	return nil
+}
+
+func applyPickup(app *HailingApp, rec *ReservationRecord, reply Reply) error {
+	if reply.Text != "modify-pickup-time" {
+		return &TransitionError{From: StatePickup}
+	}
+	return applyWhen(app, rec, reply)
+}
+
+func questionTo(app *HailingApp, record *ReservationRecord, localizer *i18n.Localizer) Question {
+	buttons := app.QuickReplyLocations(record)
+	return Question{
+		Text: localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "WhereTo",
+				Other: "Where to?",
+			},
+		}),
+		Buttons:       buttons,
+		LocationInput: true,
+	}
+}
+
+func questionFrom(app *HailingApp, record *ReservationRecord, localizer *i18n.Localizer) Question {
+	buttons := app.QuickReplyLocations(record)
+	return Question{
+		Text: localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "PickupLocation",
+				Other: "Pickup location?",
+			},
+		}),
+		Buttons:       buttons,
+		LocationInput: true,
+	}
+}
+
+func questionWhen(app *HailingApp, record *ReservationRecord, localizer *i18n.Localizer) Question {
+	buttons := []QuickReplyButton{
+		{
+			Label: "Now",
+			Text:  "now",
+		},
+		{
+			Label: localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "InXMin",
+					Other: "In {{.Min}} mins",
+				},
+				TemplateData: map[string]string{
+					"Min": "15",
+				},
+			}),
+			Text: "+15min",
+		},
+		{
+			Label: localizer.MustLocalize(&i18n.LocalizeConfig{
+				DefaultMessage: &i18n.Message{
+					ID:    "InXMin",
+					Other: "In {{.Min}} mins",
+				},
+				TemplateData: map[string]string{
+					"Min": "30",
+				},
+			}),
+			Text: "+30min",
+		},
+	}
+	return Question{
+		Text: localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "When",
+				Other: "When?",
+			},
+		}),
+		Buttons:       buttons,
+		DatetimeInput: true,
+	}
+}
+
+func questionNumOfPassengers(app *HailingApp, record *ReservationRecord, localizer *i18n.Localizer) Question {
+	buttons := []QuickReplyButton{
+		{
+			Label: "1",
+			Text:  "1",
+		},
+		{
+			Label: "2",
+			Text:  "2",
+		},
+		{
+			Label: "3",
+			Text:  "3",
+		},
+		{
+			Label: "4",
+			Text:  "4",
+		},
+	}
+	return Question{
+		Text: localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "HowManyPassengers",
+				Other: "How many passengers?",
+			},
+		}),
+		Buttons: buttons,
+	}
+}
+
+func questionFinal(app *HailingApp, record *ReservationRecord, localizer *i18n.Localizer) Question {
+	return Question{
+		Text: localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "Confirm",
+				Other: "Confirm",
+			},
+		}),
+		YesInput: true,
 	}
-	return rec, nil
 }
@@ -70,11 +70,13 @@ func TestCheckingServiceArea(t *testing.T) {
 	if err != nil {
 		t.Error("Good location, why doesn't it work [good1]: ", err)
 	}
-	good2 := [2]float64{100.5691311, 13.7298491}
-	reply.Coords = good2
+	// inside the bounding box of the area, which is all it used to check,
+	// but south of its edge along Sukhumvit
+	bad5 := [2]float64{100.5691311, 13.7298491}
+	reply.Coords = bad5
 	_, err = IsLocation(reply)
-	if err != nil {
-		t.Error("Good location, why doesn't it work [good2]: ", err)
+	if err == nil {
+		t.Error("Wrong location, but why does it works [bad5]: ", err)
 	}
 	good3 := [2]float64{100.561785, 13.736299}
 	reply.Coords = good3
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// RouteCache keeps routes so repeated quotes for popular pairs don't hit
+// the network
+type RouteCache interface {
+	Get(key string) (*Route, bool)
+	Put(key string, route *Route)
+}
+
+// LRURouteCache keeps the most recently used routes in memory, each for
+// TTL since it's found
+type LRURouteCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type lruRoute struct {
+	key     string
+	route   Route
+	expires time.Time
+}
+
+// NewLRURouteCache returns LRURouteCache of size routes
+func NewLRURouteCache(size int, ttl time.Duration) *LRURouteCache {
+	return &LRURouteCache{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// Get returns a copy of the route of key unless it's expired
+func (c *LRURouteCache) Get(key string) (*Route, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruRoute)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	route := entry.route
+	return &route, true
+}
+
+// Put keeps route, dropping the least recently used one if it's full
+func (c *LRURouteCache) Put(key string, route *Route) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruRoute{key: key, route: *route, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruRoute).key)
+	}
+}
+
+// SessionRouteCache keeps routes in SessionStore, i.e. redis, which is
+// shared by every instance of the bot
+type SessionRouteCache struct {
+	Sessions SessionStore
+	TTL      time.Duration
+}
+
+// Get returns the route of key if it's there
+func (c *SessionRouteCache) Get(key string) (*Route, bool) {
+	b, err := c.Sessions.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	var route Route
+	if err := json.Unmarshal(b, &route); err != nil {
+		return nil, false
+	}
+	return &route, true
+}
+
+// Put keeps route for TTL
+func (c *SessionRouteCache) Put(key string, route *Route) {
+	b, _ := json.Marshal(route)
+	if err := c.Sessions.Put(key, b, c.TTL); err != nil {
+		log.Printf("[RouteCache] %s: %v", key, err)
+	}
+}
+
+// NewRouteCache returns the cache of ROUTE_CACHE: "memory" (default) keeps
+// ROUTE_CACHE_SIZE routes (default 1000), "redis" keeps them in sessions
+// and "none" is no cache. Routes are kept for ROUTE_CACHE_TTL (default 10m).
+func NewRouteCache(sessions SessionStore) RouteCache {
+	ttl := envDuration("ROUTE_CACHE_TTL", 10*time.Minute)
+	switch os.Getenv("ROUTE_CACHE") {
+	case "none":
+		return nil
+	case "redis":
+		if sessions != nil {
+			return &SessionRouteCache{Sessions: sessions, TTL: ttl}
+		}
+		log.Printf("[RouteCache] no session store, routes are cached in memory")
+	}
+	return NewLRURouteCache(int(envFloat("ROUTE_CACHE_SIZE", 1000)), ttl)
+}
+
+// CachedRouting is Provider with Cache. Routes are cached by origin &
+// destination rounded to about 10 m, and departure time in Bucket, e.g.
+// every pickup from 8:00 to 8:15 gets the same route.
+type CachedRouting struct {
+	Provider RoutingProvider
+	Cache    RouteCache
+	Bucket   time.Duration
+}
+
+// Name is the one of Provider
+func (c *CachedRouting) Name() string {
+	return c.Provider.Name()
+}
+
+// Route returns the cached route, or asks Provider and caches it
+func (c *CachedRouting) Route(ctx context.Context, req RouteRequest) (*Route, error) {
+	key := routeCacheKey(req, c.Bucket)
+	if route, ok := c.Cache.Get(key); ok {
+		return route, nil
+	}
+	route, err := c.Provider.Route(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	c.Cache.Put(key, route)
+	return route, nil
+}
+
+// routeCacheKey is like "route:car:100.5623,13.7349;100.5749,13.7355:1591000200"
+// where the last part is the start of departure bucket, 0 if there's none
+func routeCacheKey(req RouteRequest, bucket time.Duration) string {
+	var departure int64
+	if !req.Departure.IsZero() {
+		departure = req.Departure.Unix()
+		if bucket > 0 {
+			departure = req.Departure.Truncate(bucket).Unix()
+		}
+	}
+	return fmt.Sprintf("route:%s:%.4f,%.4f;%.4f,%.4f:%d",
+		req.Mode, req.From[0], req.From[1], req.To[0], req.To[1], departure)
+}
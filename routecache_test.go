@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingProvider returns a route of Distance and counts requests
+type countingProvider struct {
+	Distance float64
+	Requests int
+}
+
+func (p *countingProvider) Name() string {
+	return "counting"
+}
+
+func (p *countingProvider) Route(ctx context.Context, req RouteRequest) (*Route, error) {
+	p.Requests++
+	return &Route{Distance: p.Distance, Source: p.Name()}, nil
+}
+
+func TestLRURouteCache(t *testing.T) {
+	cache := NewLRURouteCache(2, time.Hour)
+	cache.Put("a", &Route{Distance: 1})
+	cache.Put("b", &Route{Distance: 2})
+	cache.Get("a")
+	cache.Put("c", &Route{Distance: 3})
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b is the least recently used, it must be dropped")
+	}
+	if route, ok := cache.Get("a"); !ok || route.Distance != 1 {
+		t.Errorf("expect a, got %v %v", route, ok)
+	}
+	route, _ := cache.Get("c")
+	route.Distance = 30
+	if route, _ := cache.Get("c"); route.Distance != 3 {
+		t.Error("cached route must not be changed by the caller")
+	}
+
+	expiring := NewLRURouteCache(2, -time.Second)
+	expiring.Put("a", &Route{Distance: 1})
+	if _, ok := expiring.Get("a"); ok {
+		t.Error("expired route must be gone")
+	}
+}
+
+func TestCachedRouting(t *testing.T) {
+	eight := time.Date(2020, 6, 1, 8, 0, 0, 0, bangkok)
+	caches := map[string]RouteCache{
+		"memory": NewLRURouteCache(10, time.Hour),
+		"redis":  &SessionRouteCache{Sessions: NewMemorySessionStore("test:"), TTL: time.Hour},
+	}
+	for name, cache := range caches {
+		provider := &countingProvider{Distance: 1200}
+		routing := &CachedRouting{Provider: provider, Cache: cache, Bucket: 15 * time.Minute}
+		req := RouteRequest{Mode: "car", From: [2]float64{100.56231, 13.73491}, To: [2]float64{100.57491, 13.73548}, Departure: eight}
+		routing.Route(context.Background(), req)
+
+		// a few meters away, a few minutes later
+		req.From = [2]float64{100.56232, 13.73489}
+		req.Departure = eight.Add(10 * time.Minute)
+		route, err := routing.Route(context.Background(), req)
+		if err != nil || route.Distance != 1200 || provider.Requests != 1 {
+			t.Errorf("%s: expect cached route, got %v %v after %d requests", name, route, err, provider.Requests)
+		}
+
+		req.Departure = eight.Add(20 * time.Minute)
+		routing.Route(context.Background(), req)
+		req.Mode = "walk"
+		routing.Route(context.Background(), req)
+		if provider.Requests != 3 {
+			t.Errorf("%s: next bucket & other mode aren't cached, got %d requests", name, provider.Requests)
+		}
+	}
+}
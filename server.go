@@ -1,14 +1,45 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	diagram := flag.String("fsm", "", "print reservation state diagram (dot or mermaid) and exit")
+	fakeRouting := flag.String("fake-routing", "", "serve fake OSRM, Google Directions & OTP on this address, e.g. :5001, and route through it")
+	flag.Parse()
+	switch *diagram {
+	case "dot":
+		fmt.Print(reservationFSM.Dot())
+		return
+	case "mermaid":
+		fmt.Print(reservationFSM.Mermaid())
+		return
+	}
+	if *fakeRouting != "" {
+		// offline development, see FakeRouting
+		go func() {
+			log.Fatal(ServeFakeRouting(*fakeRouting, os.Getenv("FAKE_ROUTING_FIXTURES")))
+		}()
+		baseURL := "http://" + *fakeRouting
+		if strings.HasPrefix(*fakeRouting, ":") {
+			baseURL = "http://localhost" + *fakeRouting
+		}
+		os.Setenv("OSRM_BASE_URL", baseURL+"/route/v1")
+		os.Setenv("GOOGLE_DIRECTIONS_URL", baseURL+"/maps/api/directions/json")
+		os.Setenv("OTP_BASE_URL", baseURL+"/otp/routers/default")
+		if os.Getenv("GOOGLE_API_KEY") == "" {
+			os.Setenv("GOOGLE_API_KEY", "fake")
+		}
+	}
 
 	app, err := NewHailingApp(
 		os.Getenv("CHANNEL_SECRET"),
@@ -18,6 +49,16 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// remind riders of upcoming trips until the server stops
+	go app.RunReminders(nil)
+	// book trips of recurring rides ahead of time
+	go app.RunRecurringRides(nil)
+	// keep location popularity in line with trips
+	go app.RunPopularity(nil)
+	// route maps of old trips aren't needed anymore
+	go app.RunRouteMapExpiry(nil)
+	// pick up changes of words file without restart
+	go Vocabulary().Watch(envDuration("WORDS_RELOAD_INTERVAL", 30*time.Second), nil)
 
 	// serve /static/** files
 	staticFileServer := http.FileServer(http.Dir("static"))
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// Zone is a named part of the service area. Zones without Open hours are
+// always open.
+type Zone struct {
+	Name string
+	Area orb.MultiPolygon
+	// Days are when the zone is open, every day if empty
+	Days []time.Weekday
+	// Open & Close are "15:04" in Bangkok; Close before Open means the
+	// zone closes after midnight
+	Open  string
+	Close string
+}
+
+// HoursText describes operating hours, e.g. "06:00-22:00 (weekdays)"
+func (z *Zone) HoursText() string {
+	if z.Open == "" {
+		return "24 hours"
+	}
+	hours := z.Open + "-" + z.Close
+	if len(z.Days) > 0 && len(z.Days) < 7 {
+		hours += " (" + weekdaysText(z.Days) + ")"
+	}
+	return hours
+}
+
+// OpenAt reports whether the zone takes pickups at t
+func (z *Zone) OpenAt(t time.Time) bool {
+	t = t.In(bangkok)
+	clock := t.Format("15:04")
+	day := t.Weekday()
+	if z.Open != "" && z.Close < z.Open && clock < z.Close {
+		// early hours belong to the night before
+		day = t.AddDate(0, 0, -1).Weekday()
+	}
+	if len(z.Days) > 0 && WeekdayMask(z.Days)&WeekdayMask([]time.Weekday{day}) == 0 {
+		return false
+	}
+	switch {
+	case z.Open == "":
+		return true
+	case z.Open <= z.Close:
+		return clock >= z.Open && clock < z.Close
+	}
+	return clock >= z.Open || clock < z.Close
+}
+
+// Contains reports whether p is inside the zone, holes excluded
+func (z *Zone) Contains(p [2]float64) bool {
+	return planar.MultiPolygonContains(z.Area, orb.Point(p))
+}
+
+// Distance is how far (m) p is from the zone, 0 if it's inside
+func (z *Zone) Distance(p [2]float64) float64 {
+	if z.Contains(p) {
+		return 0
+	}
+	nearest := math.Inf(1)
+	for _, polygon := range z.Area {
+		for _, ring := range polygon {
+			for i := 0; i+1 < len(ring); i++ {
+				d := segmentDistance(p, ring[i], ring[i+1])
+				if d < nearest {
+					nearest = d
+				}
+			}
+		}
+	}
+	return nearest
+}
+
+// segmentDistance is the distance (m) from p to segment a-b on a local
+// flat projection which is good enough within a city
+func segmentDistance(p [2]float64, a orb.Point, b orb.Point) float64 {
+	const metersPerDegree = 111320.0
+	scale := math.Cos(p[1] * math.Pi / 180)
+	ax, ay := (a[0]-p[0])*scale*metersPerDegree, (a[1]-p[1])*metersPerDegree
+	bx, by := (b[0]-p[0])*scale*metersPerDegree, (b[1]-p[1])*metersPerDegree
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// OutsideServiceAreaError is returned for a place outside every zone
+type OutsideServiceAreaError struct {
+	// Nearest zone & how far (m) it is
+	Nearest  string
+	Distance float64
+}
+
+func (e *OutsideServiceAreaError) Error() string {
+	if e.Nearest == "" {
+		return "Outside service area"
+	}
+	return fmt.Sprintf("Outside service area, %s away from %s", DistanceText(e.Distance), e.Nearest)
+}
+
+// ZoneClosedError is returned for a pickup outside operating hours
+type ZoneClosedError struct {
+	Zone  string
+	Hours string
+}
+
+func (e *ZoneClosedError) Error() string {
+	return fmt.Sprintf("%s is only open %s", e.Zone, e.Hours)
+}
+
+// DistanceText shows meters, or kilometers if it's far
+func DistanceText(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%.0f m", meters)
+	}
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
+
+// ServiceArea is where the service picks up & drops off riders
+type ServiceArea struct {
+	Zones []Zone
+}
+
+// LoadServiceArea reads zones from a GeoJSON FeatureCollection (or a single
+// Feature) of polygons & multipolygons. Properties of each feature:
+//
+//	name   zone name shown to riders
+//	hours  operating hours, e.g. "06:00-22:00"; always open if missing
+//	days   days it's open, e.g. "weekdays" or "sat,sun"; every day if missing
+func LoadServiceArea(path string) (*ServiceArea, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc, err := geojson.UnmarshalFeatureCollection(b)
+	if err != nil || len(fc.Features) == 0 {
+		feature, ferr := geojson.UnmarshalFeature(b)
+		if ferr != nil {
+			return nil, fmt.Errorf("%s: neither FeatureCollection nor Feature: %v", path, ferr)
+		}
+		fc = geojson.NewFeatureCollection().Append(feature)
+	}
+	area := &ServiceArea{}
+	for i, feature := range fc.Features {
+		zone := Zone{Name: feature.Properties.MustString("name", "")}
+		if zone.Name == "" {
+			zone.Name = fmt.Sprintf("zone %d", i+1)
+		}
+		switch g := feature.Geometry.(type) {
+		case orb.Polygon:
+			zone.Area = orb.MultiPolygon{g}
+		case orb.MultiPolygon:
+			zone.Area = g
+		default:
+			return nil, fmt.Errorf("%s: %s must be Polygon or MultiPolygon", path, zone.Name)
+		}
+		if hours := feature.Properties.MustString("hours", ""); hours != "" {
+			zone.Open, zone.Close, err = parseHours(hours)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s %v", path, zone.Name, err)
+			}
+		}
+		if days := feature.Properties.MustString("days", ""); days != "" {
+			zone.Days, err = ParseWeekdays(days)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s days: %v", path, zone.Name, err)
+			}
+		}
+		area.Zones = append(area.Zones, zone)
+	}
+	return area, nil
+}
+
+// parseHours reads hours like "06:00-22:00" into open & close
+func parseHours(hours string) (string, string, error) {
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("hours %q must be like 06:00-22:00", hours)
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if _, err := time.Parse("15:04", parts[i]); err != nil {
+			return "", "", fmt.Errorf("hours %q: %v", hours, err)
+		}
+	}
+	return parts[0], parts[1], nil
+}
+
+// HasZone reports whether there is a zone of name
+func (a *ServiceArea) HasZone(name string) bool {
+	for i := range a.Zones {
+		if a.Zones[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ZoneAt returns the zone p is in, or nil
+func (a *ServiceArea) ZoneAt(p [2]float64) *Zone {
+	for i := range a.Zones {
+		if a.Zones[i].Contains(p) {
+			return &a.Zones[i]
+		}
+	}
+	return nil
+}
+
+// Nearest returns the zone closest to p and how far (m) it is
+func (a *ServiceArea) Nearest(p [2]float64) (*Zone, float64) {
+	var nearest *Zone
+	distance := math.Inf(1)
+	for i := range a.Zones {
+		if d := a.Zones[i].Distance(p); d < distance {
+			nearest, distance = &a.Zones[i], d
+		}
+	}
+	return nearest, distance
+}
+
+// Locate returns the zone p is in, or OutsideServiceAreaError telling
+// how far the nearest one is
+func (a *ServiceArea) Locate(p [2]float64) (*Zone, error) {
+	if zone := a.ZoneAt(p); zone != nil {
+		return zone, nil
+	}
+	nearest, distance := a.Nearest(p)
+	if nearest == nil {
+		return nil, &OutsideServiceAreaError{}
+	}
+	return nil, &OutsideServiceAreaError{Nearest: nearest.Name, Distance: distance}
+}
+
+// CheckOpen returns ZoneClosedError if the zone of p doesn't take pickups
+// at t. Places outside the service area are left to Locate.
+func (a *ServiceArea) CheckOpen(p [2]float64, t time.Time) error {
+	zone := a.ZoneAt(p)
+	if zone == nil || zone.OpenAt(t) {
+		return nil
+	}
+	return &ZoneClosedError{Zone: zone.Name, Hours: zone.HoursText()}
+}
+
+var (
+	serviceAreaOnce sync.Once
+	serviceArea     *ServiceArea
+)
+
+// CurrentServiceArea returns zones of SERVICE_AREA_FILE
+// (static/service_area.json by default), loaded on first use
+func CurrentServiceArea() *ServiceArea {
+	serviceAreaOnce.Do(func() {
+		path := os.Getenv("SERVICE_AREA_FILE")
+		if path == "" {
+			path = "static/service_area.json"
+		}
+		area, err := LoadServiceArea(path)
+		if err != nil {
+			log.Fatalf("[ServiceArea] %v", err)
+		}
+		serviceArea = area
+	})
+	return serviceArea
+}
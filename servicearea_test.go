@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServiceArea(t *testing.T) {
+	dir, err := ioutil.TempDir("", "area")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "area.json")
+	// Downtown is a square with a park in the middle; Night Market is two
+	// blocks open late on Friday & Saturday
+	ioutil.WriteFile(path, []byte(`{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "properties": {"name": "Downtown"},
+      "geometry": {"type": "Polygon", "coordinates": [
+        [[100.50, 13.70], [100.52, 13.70], [100.52, 13.72], [100.50, 13.72], [100.50, 13.70]],
+        [[100.505, 13.705], [100.515, 13.705], [100.515, 13.715], [100.505, 13.715], [100.505, 13.705]]
+      ]}
+    },
+    {
+      "type": "Feature",
+      "properties": {"name": "Night Market", "hours": "22:00-02:00", "days": "fri,sat"},
+      "geometry": {"type": "MultiPolygon", "coordinates": [
+        [[[100.60, 13.70], [100.61, 13.70], [100.61, 13.71], [100.60, 13.71], [100.60, 13.70]]],
+        [[[100.62, 13.70], [100.63, 13.70], [100.63, 13.71], [100.62, 13.71], [100.62, 13.70]]]
+      ]}
+    }
+  ]
+}`), 0644)
+	area, err := LoadServiceArea(path)
+	if err != nil {
+		t.Fatal("LoadServiceArea failed: ", err)
+	}
+
+	tests := []struct {
+		name  string
+		point [2]float64
+		zone  string
+	}{
+		{"downtown", [2]float64{100.502, 13.702}, "Downtown"},
+		{"park is a hole", [2]float64{100.51, 13.71}, ""},
+		{"first block", [2]float64{100.605, 13.705}, "Night Market"},
+		{"second block", [2]float64{100.625, 13.705}, "Night Market"},
+		{"between blocks", [2]float64{100.615, 13.705}, ""},
+	}
+	for _, tc := range tests {
+		zone := area.ZoneAt(tc.point)
+		if (zone == nil && tc.zone != "") || (zone != nil && zone.Name != tc.zone) {
+			t.Errorf("%s: expect %q, got %v", tc.name, tc.zone, zone)
+		}
+	}
+
+	// 0.01 degree east of Downtown is about 1.08 km at this latitude
+	_, err = area.Locate([2]float64{100.53, 13.71})
+	outside, ok := err.(*OutsideServiceAreaError)
+	if !ok || outside.Nearest != "Downtown" || math.Abs(outside.Distance-1081) > 10 {
+		t.Errorf("expect 1.08 km from Downtown: %v", err)
+	}
+
+	market := area.ZoneAt([2]float64{100.605, 13.705})
+	hours := []struct {
+		at   time.Time
+		open bool
+	}{
+		{time.Date(2020, 6, 5, 23, 0, 0, 0, bangkok), true},  // Friday night
+		{time.Date(2020, 6, 6, 1, 30, 0, 0, bangkok), true},  // still Friday night
+		{time.Date(2020, 6, 6, 2, 0, 0, 0, bangkok), false},  // closed
+		{time.Date(2020, 6, 7, 23, 0, 0, 0, bangkok), false}, // Sunday
+		{time.Date(2020, 6, 8, 1, 0, 0, 0, bangkok), false},  // Sunday night
+	}
+	for _, h := range hours {
+		if market.OpenAt(h.at) != h.open {
+			t.Errorf("Night Market at %v: expect open %v", h.at, h.open)
+		}
+	}
+	err = area.CheckOpen([2]float64{100.605, 13.705}, time.Date(2020, 6, 7, 23, 0, 0, 0, bangkok))
+	if closed, ok := err.(*ZoneClosedError); !ok || closed.Hours != "22:00-02:00 (Fri, Sat)" {
+		t.Errorf("expect ZoneClosedError: %v", err)
+	}
+	if area.CheckOpen([2]float64{100.502, 13.702}, time.Date(2020, 6, 7, 3, 0, 0, 0, bangkok)) != nil {
+		t.Error("Downtown is always open")
+	}
+
+	ioutil.WriteFile(path, []byte(`{"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [100.5, 13.7]}}`), 0644)
+	if _, err := LoadServiceArea(path); err == nil {
+		t.Error("a point isn't an area")
+	}
+}
+
+func TestServiceAreaFile(t *testing.T) {
+	area, err := LoadServiceArea("static/service_area.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// riders at condo a are told how far the zone is, see TestMain
+	outside := map[string]bool{"condo a": true}
+	for _, place := range Vocabulary().Words().Places {
+		if inside := area.ZoneAt(place.Coords) != nil; inside == outside[place.Name] {
+			t.Errorf("%s: expect inside service area %v", place.Name, !outside[place.Name])
+		}
+	}
+	// inside the bounding box but not the polygon
+	if zone := area.ZoneAt([2]float64{100.584, 13.728}); zone != nil {
+		t.Errorf("expect outside, got %v", zone.Name)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ErrSessionNotFound is returned when the key doesn't exist or is expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps short-lived conversation state i.e. ReservationRecord
+// while the rider is still talking to the bot
+type SessionStore interface {
+	Get(key string) ([]byte, error)
+	// Put saves value which expires after ttl (0 means no expiration)
+	Put(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// CompareAndSwap puts new only if the current value is still old.
+	// nil old means the key must not exist. It returns false if the value
+	// was changed by someone else.
+	CompareAndSwap(key string, old []byte, new []byte, ttl time.Duration) (bool, error)
+}
+
+// RedisSessionStore is SessionStore on redis. All keys are prefixed so
+// several apps can share the same redis db.
+type RedisSessionStore struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+// NewRedisSessionStore returns SessionStore on rdb with namespace prefix
+func NewRedisSessionStore(rdb *redis.Client, prefix string) *RedisSessionStore {
+	return &RedisSessionStore{rdb: rdb, prefix: prefix}
+}
+
+// casScript: KEYS[1] key, ARGV: must-not-exist flag, old, new, ttl in ms
+var casScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+	if cur then return 0 end
+elseif cur ~= ARGV[2] then
+	return 0
+end
+if ARGV[4] == '0' then
+	redis.call('SET', KEYS[1], ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+end
+return 1
+`)
+
+// Get returns value of key
+func (s *RedisSessionStore) Get(key string) ([]byte, error) {
+	result, err := s.rdb.Get(s.prefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Put saves value to key
+func (s *RedisSessionStore) Put(key string, value []byte, ttl time.Duration) error {
+	return s.rdb.Set(s.prefix+key, value, ttl).Err()
+}
+
+// Delete removes key
+func (s *RedisSessionStore) Delete(key string) error {
+	return s.rdb.Del(s.prefix + key).Err()
+}
+
+// CompareAndSwap puts new to key if its value is still old
+func (s *RedisSessionStore) CompareAndSwap(key string, old []byte, new []byte, ttl time.Duration) (bool, error) {
+	mustNotExist := "0"
+	if old == nil {
+		mustNotExist = "1"
+	}
+	ok, err := casScript.Run(
+		s.rdb, []string{s.prefix + key},
+		mustNotExist, old, new, int64(ttl/time.Millisecond),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+// MemorySessionStore is SessionStore in memory for tests and local demos
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	prefix  string
+	entries map[string]memorySession
+	now     func() time.Time
+}
+
+type memorySession struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore
+func NewMemorySessionStore(prefix string) *MemorySessionStore {
+	return &MemorySessionStore{
+		prefix:  prefix,
+		entries: map[string]memorySession{},
+		now:     time.Now,
+	}
+}
+
+// get must be called with s.mu held
+func (s *MemorySessionStore) get(key string) ([]byte, bool) {
+	entry, ok := s.entries[s.prefix+key]
+	if !ok {
+		return nil, false
+	}
+	if !entry.expiresAt.IsZero() && !s.now().Before(entry.expiresAt) {
+		delete(s.entries, s.prefix+key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// put must be called with s.mu held
+func (s *MemorySessionStore) put(key string, value []byte, ttl time.Duration) {
+	entry := memorySession{value: append([]byte{}, value...)}
+	if ttl > 0 {
+		entry.expiresAt = s.now().Add(ttl)
+	}
+	s.entries[s.prefix+key] = entry
+}
+
+// Get returns value of key
+func (s *MemorySessionStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.get(key)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Put saves value to key
+func (s *MemorySessionStore) Put(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, ttl)
+	return nil
+}
+
+// Delete removes key
+func (s *MemorySessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, s.prefix+key)
+	return nil
+}
+
+// CompareAndSwap puts new to key if its value is still old
+func (s *MemorySessionStore) CompareAndSwap(key string, old []byte, new []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.get(key)
+	if old == nil && ok {
+		return false, nil
+	}
+	if old != nil && (!ok || !bytes.Equal(cur, old)) {
+		return false, nil
+	}
+	s.put(key, new, ttl)
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemorySessionStore("test:")
+	store.now = func() time.Time { return now }
+
+	if _, err := store.Get("a"); err != ErrSessionNotFound {
+		t.Errorf("expect ErrSessionNotFound: %v", err)
+	}
+	store.Put("a", []byte("1"), time.Minute)
+	if v, _ := store.Get("a"); string(v) != "1" {
+		t.Errorf("Get after Put: %s", v)
+	}
+
+	// only swap when value is still the same
+	if ok, _ := store.CompareAndSwap("a", []byte("0"), []byte("2"), time.Minute); ok {
+		t.Error("swap must fail if old value mismatch")
+	}
+	if ok, _ := store.CompareAndSwap("a", []byte("1"), []byte("2"), time.Minute); !ok {
+		t.Error("swap must succeed if old value match")
+	}
+	if ok, _ := store.CompareAndSwap("a", nil, []byte("3"), time.Minute); ok {
+		t.Error("swap with nil old must fail if key exists")
+	}
+	if ok, _ := store.CompareAndSwap("b", nil, []byte("1"), time.Minute); !ok {
+		t.Error("swap with nil old must succeed if key doesn't exist")
+	}
+
+	// expiration
+	now = now.Add(2 * time.Minute)
+	if _, err := store.Get("a"); err != ErrSessionNotFound {
+		t.Errorf("key must be expired: %v", err)
+	}
+	store.Put("c", []byte("1"), 0)
+	now = now.Add(24 * time.Hour)
+	if _, err := store.Get("c"); err != nil {
+		t.Errorf("key without ttl must not expire: %v", err)
+	}
+	store.Delete("c")
+	if _, err := store.Get("c"); err != ErrSessionNotFound {
+		t.Errorf("key must be deleted: %v", err)
+	}
+}
+
+func TestUpdateRecordDetectsConcurrentChange(t *testing.T) {
+	app := &HailingApp{sessions: NewMemorySessionStore("test:"), sessionTTL: time.Minute}
+	rec := &ReservationRecord{LineUserID: "Urider", State: "init", Waiting: "to", TripID: -1}
+	app.SaveRecord(rec)
+	_, old, err := app.loadRecord("Urider")
+	if err != nil {
+		t.Fatal("loadRecord failed: ", err)
+	}
+
+	// someone else updates the record meanwhile
+	other := *rec
+	other.To = "citi resort"
+	app.SaveRecord(&other)
+
+	rec.To = "condo a"
+	if err := app.UpdateRecord(old, rec); err != ErrRecordChanged {
+		t.Errorf("expect ErrRecordChanged: %v", err)
+	}
+	saved, _ := app.FindRecord("Urider")
+	if saved.To != "citi resort" {
+		t.Errorf("record must not be overwritten: %v", saved.To)
+	}
+}
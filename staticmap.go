@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // tiles of some servers
+	"image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// tileSize (px) of slippy map tiles
+const tileSize = 256
+
+// TileSource gives basemap tiles by zoom & slippy map x, y
+type TileSource interface {
+	Tile(ctx context.Context, z int, x int, y int) (image.Image, error)
+}
+
+// tilePath fills {z}, {x} & {y} of template
+func tilePath(template string, z int, x int, y int) string {
+	return strings.NewReplacer(
+		"{z}", fmt.Sprint(z),
+		"{x}", fmt.Sprint(x),
+		"{y}", fmt.Sprint(y),
+	).Replace(template)
+}
+
+// HTTPTileSource fetches tiles of Template, e.g.
+// https://tile.openstreetmap.org/{z}/{x}/{y}.png, and keeps them in
+// CacheDir if it's given
+type HTTPTileSource struct {
+	Template  string
+	UserAgent string
+	CacheDir  string
+	Client    *http.Client
+	Timeout   time.Duration
+}
+
+// Tile returns the cached tile, or fetches it
+func (s *HTTPTileSource) Tile(ctx context.Context, z int, x int, y int) (image.Image, error) {
+	cached := ""
+	if s.CacheDir != "" {
+		cached = filepath.Join(s.CacheDir, fmt.Sprint(z), fmt.Sprint(x), fmt.Sprintf("%d.png", y))
+		if img, err := readTile(cached); err == nil {
+			return img, nil
+		}
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", tilePath(s.Template, z, x, y), nil)
+	if err != nil {
+		return nil, err
+	}
+	// tile servers like OSM's refuse requests without it
+	req.Header.Set("User-Agent", s.UserAgent)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile %d/%d/%d: %s", z, x, y, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("tile %d/%d/%d: %v", z, x, y, err)
+	}
+	if cached != "" {
+		if err := os.MkdirAll(filepath.Dir(cached), 0777); err == nil {
+			ioutil.WriteFile(cached, b, 0644)
+		}
+	}
+	return img, nil
+}
+
+// DirTileSource reads tiles of a local Template, e.g. tiles/{z}/{x}/{y}.png
+// exported for offline use
+type DirTileSource struct {
+	Template string
+}
+
+// Tile reads the tile file
+func (s *DirTileSource) Tile(ctx context.Context, z int, x int, y int) (image.Image, error) {
+	return readTile(tilePath(s.Template, z, x, y))
+}
+
+func readTile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// BasemapTileSource is the bundled offline basemap: zones of Area on a
+// plain background, with a grid to tell distance. It's used in tests and
+// when there is no tile server.
+type BasemapTileSource struct {
+	Area *ServiceArea
+}
+
+var (
+	basemapLand = color.RGBA{0xF2, 0xEF, 0xE9, 0xFF}
+	basemapZone = color.RGBA{0xDD, 0xEB, 0xF7, 0xFF}
+	basemapGrid = color.RGBA{0xE0, 0xDC, 0xD4, 0xFF}
+)
+
+// Tile draws the tile
+func (s *BasemapTileSource) Tile(ctx context.Context, z int, x int, y int) (image.Image, error) {
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for py := 0; py < tileSize; py++ {
+		for px := 0; px < tileSize; px++ {
+			c := basemapLand
+			if px%64 == 0 || py%64 == 0 {
+				c = basemapGrid
+			}
+			if s.Area != nil {
+				p := orb.Point(worldToLonLat(float64(x*tileSize+px)+0.5, float64(y*tileSize+py)+0.5, z))
+				for _, zone := range s.Area.Zones {
+					if planar.MultiPolygonContains(zone.Area, p) {
+						c = basemapZone
+						break
+					}
+				}
+			}
+			img.SetRGBA(px, py, c)
+		}
+	}
+	return img, nil
+}
+
+// NewTileSource returns tiles of TILE_URL: an http(s) template is fetched
+// (with MAP_USER_AGENT) and cached in cacheDir, other templates are local
+// files. Without it, it's the bundled basemap of the service area.
+func NewTileSource(cacheDir string) TileSource {
+	template := os.Getenv("TILE_URL")
+	switch {
+	case template == "":
+		return &BasemapTileSource{Area: CurrentServiceArea()}
+	case strings.HasPrefix(template, "http://") || strings.HasPrefix(template, "https://"):
+		userAgent := os.Getenv("MAP_USER_AGENT")
+		if userAgent == "" {
+			userAgent = "hailing-bot"
+		}
+		return &HTTPTileSource{
+			Template:  template,
+			UserAgent: userAgent,
+			CacheDir:  cacheDir,
+			Timeout:   envDuration("TILE_TIMEOUT", 3*time.Second),
+		}
+	}
+	return &DirTileSource{Template: template}
+}
+
+// lonLatToWorld returns web mercator pixel coordinates of p at zoom z
+func lonLatToWorld(p [2]float64, z int) (float64, float64) {
+	scale := tileSize * math.Exp2(float64(z))
+	lat := p[1] * math.Pi / 180
+	x := (p[0] + 180) / 360 * scale
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * scale
+	return x, y
+}
+
+// worldToLonLat is the inverse of lonLatToWorld
+func worldToLonLat(x float64, y float64, z int) [2]float64 {
+	scale := tileSize * math.Exp2(float64(z))
+	lon := x/scale*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*y/scale))) * 180 / math.Pi
+	return [2]float64{lon, lat}
+}
+
+// StaticMap draws routes on tiles of Tiles into Width x Height images
+type StaticMap struct {
+	Tiles   TileSource
+	Width   int
+	Height  int
+	MinZoom int
+	MaxZoom int
+	// Padding (px) kept clear around the route
+	Padding int
+	// Timeout is how long drawing a map may take, fetching tiles included
+	Timeout time.Duration
+
+	// rendering are names of maps being drawn, pending waits for them
+	rendering sync.Map
+	pending   sync.WaitGroup
+}
+
+var (
+	mapRouteColor   = color.RGBA{0x67, 0x9A, 0xF0, 0xFF}
+	mapPickupColor  = color.RGBA{0x2E, 0xB8, 0x5C, 0xFF}
+	mapDropoffColor = color.RGBA{0xE5, 0x48, 0x48, 0xFF}
+)
+
+// ErrNoTiles is returned when not a single tile of the map is found
+var ErrNoTiles = errors.New("no basemap tiles")
+
+// Render draws the route of points from pickup to dropoff, zoomed in as
+// far as it fits
+func (m *StaticMap) Render(ctx context.Context, points [][2]float64, pickup [2]float64, dropoff [2]float64) (*image.RGBA, error) {
+	all := append([][2]float64{pickup, dropoff}, points...)
+	z := m.MinZoom
+	for zoom := m.MaxZoom; zoom >= m.MinZoom; zoom-- {
+		minX, minY, maxX, maxY := worldBounds(all, zoom)
+		if maxX-minX <= float64(m.Width-2*m.Padding) && maxY-minY <= float64(m.Height-2*m.Padding) {
+			z = zoom
+			break
+		}
+	}
+	minX, minY, maxX, maxY := worldBounds(all, z)
+	left := math.Floor((minX+maxX)/2 - float64(m.Width)/2)
+	top := math.Floor((minY+maxY)/2 - float64(m.Height)/2)
+
+	img := image.NewRGBA(image.Rect(0, 0, m.Width, m.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{basemapLand}, image.Point{}, draw.Src)
+	tiles, found := 1<<uint(z), 0
+	var lastErr error
+	for ty := int(math.Floor(top / tileSize)); ty*tileSize < int(top)+m.Height; ty++ {
+		for tx := int(math.Floor(left / tileSize)); tx*tileSize < int(left)+m.Width; tx++ {
+			if ty < 0 || ty >= tiles {
+				continue
+			}
+			tile, err := m.Tiles.Tile(ctx, z, (tx%tiles+tiles)%tiles, ty)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			found++
+			at := image.Pt(tx*tileSize-int(left), ty*tileSize-int(top))
+			draw.Draw(img, tile.Bounds().Sub(tile.Bounds().Min).Add(at), tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// better no map than one with holes
+		return nil, err
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoTiles, lastErr)
+	}
+
+	pixel := func(p [2]float64) (float64, float64) {
+		x, y := lonLatToWorld(p, z)
+		return x - left, y - top
+	}
+	for i := 1; i < len(points); i++ {
+		ax, ay := pixel(points[i-1])
+		bx, by := pixel(points[i])
+		drawSegment(img, ax, ay, bx, by, 5, mapRouteColor)
+	}
+	for _, pin := range []struct {
+		at [2]float64
+		c  color.RGBA
+	}{{pickup, mapPickupColor}, {dropoff, mapDropoffColor}} {
+		x, y := pixel(pin.at)
+		drawSegment(img, x, y, x, y, 22, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+		drawSegment(img, x, y, x, y, 16, pin.c)
+		drawSegment(img, x, y, x, y, 5, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+	}
+	return img, nil
+}
+
+// worldBounds returns the pixel bounding box of points at zoom z
+func worldBounds(points [][2]float64, z int) (float64, float64, float64, float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		x, y := lonLatToWorld(p, z)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return minX, minY, maxX, maxY
+}
+
+// drawSegment paints the segment a-b of width with round ends, a dot if
+// a is b, antialiased at the edge
+func drawSegment(img *image.RGBA, ax, ay, bx, by, width float64, c color.RGBA) {
+	r := width / 2
+	bounds := image.Rect(
+		int(math.Floor(math.Min(ax, bx)-r-1)), int(math.Floor(math.Min(ay, by)-r-1)),
+		int(math.Ceil(math.Max(ax, bx)+r+1)), int(math.Ceil(math.Max(ay, by)+r+1)),
+	).Intersect(img.Bounds())
+	dx, dy := bx-ax, by-ay
+	length2 := dx*dx + dy*dy
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			t := 0.0
+			if length2 > 0 {
+				t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/length2))
+			}
+			d := math.Hypot(ax+t*dx-px, ay+t*dy-py)
+			alpha := math.Max(0, math.Min(1, r+0.5-d))
+			if alpha == 0 {
+				continue
+			}
+			old := img.RGBAAt(x, y)
+			blend := func(a uint8, b uint8) uint8 {
+				return uint8(float64(a)*(1-alpha) + float64(b)*alpha + 0.5)
+			}
+			img.SetRGBA(x, y, color.RGBA{blend(old.R, c.R), blend(old.G, c.G), blend(old.B, c.B), 0xFF})
+		}
+	}
+}
+
+// NewStaticMap returns 800 x 520 maps (the 20:13 of card hero images) on
+// tiles of NewTileSource. Tiles are cached in TILE_CACHE_DIR, which must
+// not be served since tiles aren't ours to share.
+func NewStaticMap() *StaticMap {
+	cacheDir := os.Getenv("TILE_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "hailing-bot-tiles")
+	}
+	return &StaticMap{
+		Tiles:   NewTileSource(cacheDir),
+		Width:   800,
+		Height:  520,
+		MinZoom: 10,
+		MaxZoom: 17,
+		Padding: 40,
+		Timeout: envDuration("MAP_TIMEOUT", 15*time.Second),
+	}
+}
+
+// routeMapName is the file name of the map of rec's route, empty if there
+// is nothing to draw
+func (app *HailingApp) routeMapName(rec *ReservationRecord) string {
+	if app.staticMap == nil || rec.FromCoords == [2]float64{0, 0} || rec.ToCoords == [2]float64{0, 0} {
+		return ""
+	}
+	key := fmt.Sprintf("%s|%.6f,%.6f|%.6f,%.6f|%dx%d", rec.Polyline,
+		rec.FromCoords[0], rec.FromCoords[1], rec.ToCoords[0], rec.ToCoords[1],
+		app.staticMap.Width, app.staticMap.Height)
+	return fmt.Sprintf("map-%x.png", sha1.Sum([]byte(key)))
+}
+
+// PrepareRouteMap draws the map of rec's route into downloadDir in the
+// background, unless it's there or being drawn already
+func (app *HailingApp) PrepareRouteMap(rec *ReservationRecord) {
+	name := app.routeMapName(rec)
+	if name == "" {
+		return
+	}
+	path := filepath.Join(app.downloadDir, name)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	m := app.staticMap
+	if _, busy := m.rendering.LoadOrStore(name, true); busy {
+		return
+	}
+	polyline, pickup, dropoff := rec.Polyline, rec.FromCoords, rec.ToCoords
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+		defer m.rendering.Delete(name)
+		points, err := decodePolyline(polyline)
+		if err != nil {
+			log.Printf("[RouteMap] polyline %q: %v", polyline, err)
+		}
+		if len(points) < 2 {
+			points = [][2]float64{pickup, dropoff}
+		}
+		ctx := context.Background()
+		if m.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+			defer cancel()
+		}
+		img, err := m.Render(ctx, points, pickup, dropoff)
+		if err != nil {
+			log.Printf("[RouteMap] %s: %v", name, err)
+			return
+		}
+		if err := writePNG(path, img); err != nil {
+			log.Printf("[RouteMap] %v", err)
+		}
+	}()
+}
+
+// RouteMapURL returns the URL of the map of rec's route served at
+// /downloaded/. Replies never wait for a map: if it isn't drawn yet, it's
+// drawn for next time and the URL is empty.
+func (app *HailingApp) RouteMapURL(rec *ReservationRecord) string {
+	name := app.routeMapName(rec)
+	if name == "" {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(app.downloadDir, name)); err != nil {
+		app.PrepareRouteMap(rec)
+		return ""
+	}
+	return app.appBaseURL + "/downloaded/" + name
+}
+
+// ExpireRouteMaps removes maps in downloadDir older than ttl, with
+// temporary files left by writePNG. Maps still needed are drawn again.
+// It returns how many files are removed.
+func (app *HailingApp) ExpireRouteMaps(now time.Time, ttl time.Duration) (int, error) {
+	files, err := ioutil.ReadDir(app.downloadDir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range files {
+		name := f.Name()
+		isMap := strings.HasPrefix(name, "map-") || strings.HasPrefix(name, ".map-")
+		if f.IsDir() || !isMap || !strings.HasSuffix(name, ".png") || now.Sub(f.ModTime()) < ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(app.downloadDir, name)); err != nil {
+			log.Printf("[RouteMap] %v", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// RunRouteMapExpiry removes old maps every hour until stop is closed
+func (app *HailingApp) RunRouteMapExpiry(stop <-chan struct{}) {
+	runEvery(time.Hour, stop, func(now time.Time) {
+		removed, err := app.ExpireRouteMaps(now, app.routeMapTTL)
+		if err != nil {
+			log.Printf("[RouteMap] %v", err)
+		} else if removed > 0 {
+			log.Printf("[RouteMap] %d old map(s) removed", removed)
+		}
+	})
+}
+
+// writePNG writes img to path through a temporary file, so a map being
+// written is never served
+func writePNG(path string, img image.Image) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".map-*.png")
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStaticMap(t *testing.T) {
+	condo, citi := placeCoords("condo a"), placeCoords("citi resort")
+	x, y := lonLatToWorld(condo, 16)
+	if p := worldToLonLat(x, y, 16); math.Abs(p[0]-condo[0]) > 1e-9 || math.Abs(p[1]-condo[1]) > 1e-9 {
+		t.Errorf("expect %v back, got %v", condo, p)
+	}
+
+	m := &StaticMap{Tiles: &BasemapTileSource{Area: CurrentServiceArea()}, Width: 400, Height: 260, MinZoom: 10, MaxZoom: 17, Padding: 20}
+	img, err := m.Render(context.Background(), [][2]float64{condo, {100.5690, 13.7330}, citi}, condo, citi)
+	if err != nil {
+		t.Fatal("Render failed: ", err)
+	}
+	if img.Bounds().Dx() != 400 || img.Bounds().Dy() != 260 {
+		t.Fatalf("expect 400x260, got %v", img.Bounds())
+	}
+	counts := map[color.RGBA]int{}
+	for y := 0; y < 260; y++ {
+		for x := 0; x < 400; x++ {
+			counts[img.RGBAAt(x, y)]++
+		}
+	}
+	for name, c := range map[string]color.RGBA{
+		"route": mapRouteColor, "pickup": mapPickupColor, "drop-off": mapDropoffColor, "service area": basemapZone,
+	} {
+		if counts[c] == 0 {
+			t.Errorf("expect %s on the map", name)
+		}
+	}
+
+	m.Tiles = &DirTileSource{Template: filepath.Join(os.TempDir(), "no-tiles", "{z}/{x}/{y}.png")}
+	if _, err := m.Render(context.Background(), nil, condo, citi); !errors.Is(err, ErrNoTiles) {
+		t.Errorf("expect ErrNoTiles, got %v", err)
+	}
+}
+
+func TestHTTPTileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+" "+r.UserAgent())
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, tileSize, tileSize)))
+	}))
+	defer server.Close()
+
+	tiles := &HTTPTileSource{Template: server.URL + "/{z}/{x}/{y}.png", UserAgent: "hailing-test", CacheDir: dir}
+	for i := 0; i < 2; i++ {
+		tile, err := tiles.Tile(context.Background(), 16, 52609, 30502)
+		if err != nil || tile.Bounds().Dx() != tileSize {
+			t.Fatalf("expect a tile, got %v %v", tile, err)
+		}
+	}
+	if len(requests) != 1 || requests[0] != "/16/52609/30502.png hailing-test" {
+		t.Errorf("expect one request, the other from cache, got %v", requests)
+	}
+}
+
+func TestRouteMapOnConfirmation(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+		{Name: "from", Text: "condo a", Expect: "When?"},
+		{Name: "when", Text: "now", Expect: "How many passengers?"},
+		{Name: "passengers", Text: "1", Expect: "EstTravelTime"},
+	})
+	// drawn in the background once the route is known
+	app.staticMap.pending.Wait()
+	hero := regexp.MustCompile(`"hero":\{[^}]*"url":"https://hailing.example.com/downloaded/(map-[0-9a-f]+\.png)"`)
+	var name string
+	replies := fakeLineStep{Postback: "confirm"}.Run(fl, app, rider)
+	for _, msg := range replies {
+		if m := hero.FindStringSubmatch(string(msg.Contents)); m != nil {
+			name = m[1]
+		}
+	}
+	if name == "" {
+		t.Fatal("expect the route map on the confirmation card")
+	}
+	f, err := os.Open(filepath.Join(app.downloadDir, name))
+	if err != nil {
+		t.Fatal("map isn't in download dir: ", err)
+	}
+	defer f.Close()
+	if img, err := png.Decode(f); err != nil || img.Bounds().Dx() != 800 || img.Bounds().Dy() != 520 {
+		t.Errorf("expect 800x520 PNG, got %v", err)
+	}
+
+	// the status card shows the same map
+	replies = fakeLineStep{Text: "status"}.Run(fl, app, rider)
+	for _, msg := range replies {
+		if m := hero.FindStringSubmatch(string(msg.Contents)); m != nil && m[1] != name {
+			t.Errorf("expect %s again, got %s", name, m[1])
+		}
+	}
+
+	// old maps are removed, other downloads aren't
+	other := filepath.Join(app.downloadDir, "photo-"+rider+".png")
+	if err := ioutil.WriteFile(other, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(other)
+	if removed, err := app.ExpireRouteMaps(time.Now(), time.Hour); err != nil || removed != 0 {
+		t.Errorf("new maps must be kept: %d %v", removed, err)
+	}
+	if removed, err := app.ExpireRouteMaps(time.Now().Add(2*time.Hour), time.Hour); err != nil || removed == 0 {
+		t.Errorf("expect old maps to be removed: %d %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(app.downloadDir, name)); err == nil {
+		t.Error("old map must be removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("other downloads must be kept: ", err)
+	}
+}
+
+func TestTileCacheNotServed(t *testing.T) {
+	os.Setenv("TILE_URL", "https://tile.openstreetmap.org/{z}/{x}/{y}.png")
+	defer os.Unsetenv("TILE_URL")
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	tiles, ok := NewStaticMap().Tiles.(*HTTPTileSource)
+	if !ok {
+		t.Fatalf("expect tiles over http, got %T", NewStaticMap().Tiles)
+	}
+	if rel, err := filepath.Rel(app.downloadDir, tiles.CacheDir); err == nil && !strings.HasPrefix(rel, "..") {
+		t.Errorf("tile cache %s must be outside %s", tiles.CacheDir, app.downloadDir)
+	}
+}
+
+// slowTiles is a tile server which takes delay for each tile
+type slowTiles struct {
+	delay time.Duration
+}
+
+func (s slowTiles) Tile(ctx context.Context, z int, x int, y int) (image.Image, error) {
+	select {
+	case <-time.After(s.delay):
+		return image.NewRGBA(image.Rect(0, 0, tileSize, tileSize)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestRouteMapInBackground(t *testing.T) {
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	app.staticMap.Tiles = slowTiles{delay: time.Second}
+	app.staticMap.Timeout = 200 * time.Millisecond
+
+	rec := &ReservationRecord{FromCoords: placeCoords("condo a"), ToCoords: placeCoords("bts phromphong")}
+	start := time.Now()
+	if url := app.RouteMapURL(rec); url != "" {
+		t.Errorf("map can't be ready yet, got %s", url)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("reply must not wait for tiles, took %v", elapsed)
+	}
+	app.staticMap.pending.Wait()
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("drawing must give up after the timeout, took %v", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(app.downloadDir, app.routeMapName(rec))); err == nil {
+		t.Error("map without its tiles must not be saved")
+	}
+}
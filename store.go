@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned by stores when the record doesn't exist
+var ErrNotFound = errors.New("no rows in result set")
+
+// ErrTripCancelled is returned when cancelling a trip that's cancelled already
+var ErrTripCancelled = errors.New("Trip is already cancelled")
+
+// UserStore keeps our users
+type UserStore interface {
+	FindUserByLineID(lineUserID string) (*User, error)
+	FindUserByID(ID uuid.UUID) (*User, error)
+	// CreateUser picks a new username if the given one is taken
+	CreateUser(username string, lineUserID string, profileURL string) (*User, error)
+	SetLanguage(userID uuid.UUID, lang string) error
+}
+
+// TripStore keeps trips, the permanent record of reservations
+type TripStore interface {
+	// SaveReservation inserts a new trip if rec.TripID is -1, otherwise
+	// updates places, time, polyline & passengers of the existing one.
+	// It returns trip ID.
+	SaveReservation(rec *ReservationRecord) (int, error)
+	// FindActiveReservation returns the trip which is neither dropped off
+	// nor cancelled as a ReservationRecord in "done" state
+	FindActiveReservation(lineUserID string) (*ReservationRecord, error)
+	GetTrip(tripID int) (*Trip, error)
+	// GetTripPlaces returns pickup & drop-off coordinates of the trip
+	GetTripPlaces(tripID int) ([2]float64, [2]float64, error)
+	// TripHistory returns the user's trips which aren't cancelled,
+	// latest first
+	TripHistory(userID uuid.UUID, limit int) ([]PastTrip, error)
+	// UpcomingTrips returns trips to pick up in (from, to], which are
+	// neither cancelled, picked up nor dropped off
+	UpcomingTrips(from time.Time, to time.Time) ([]Trip, error)
+	SaveTripFeedback(tripID int, rating int) error
+	CancelTrip(tripID int, note string, cancelledAt time.Time) error
+	SetTripNote(tripID int, note string) error
+	// SaveTravelChoice logs the option the rider took on the options carousel
+	SaveTravelChoice(choice *TravelChoice) error
+}
+
+// SubscriptionStore keeps recurring rides
+type SubscriptionStore interface {
+	// CreateSubscription returns ID of the new subscription
+	CreateSubscription(sub *Subscription) (int, error)
+	GetSubscription(ID int) (*Subscription, error)
+	// FindSubscriptions returns subscriptions of the user which aren't stopped
+	FindSubscriptions(userID uuid.UUID) ([]Subscription, error)
+	// ActiveSubscriptions returns subscriptions neither paused nor stopped
+	ActiveSubscriptions() ([]Subscription, error)
+	// UpdateSubscription saves pause, skip dates & stop
+	UpdateSubscription(sub *Subscription) error
+	// ClaimPickup sets last scheduled date to date unless the subscription
+	// is paused, stopped, skips date or is scheduled up to date already,
+	// in which case it returns false
+	ClaimPickup(ID int, date string) (bool, error)
+}
+
+// PlaceStore keeps places riders save for themselves
+type PlaceStore interface {
+	// SavePlace adds a place or moves the one with the same name
+	SavePlace(userID uuid.UUID, name string, coords [2]float64) (*SavedPlace, error)
+	// SavedPlaces returns places of the user, oldest first
+	SavedPlaces(userID uuid.UUID) ([]SavedPlace, error)
+	GetSavedPlace(ID int) (*SavedPlace, error)
+	// RemovePlace deletes the user's place by name, case-insensitive.
+	// It returns ErrNotFound if there is no such place.
+	RemovePlace(userID uuid.UUID, name string) error
+}
+
+// LocationStore keeps designated locations riders can pick from
+type LocationStore interface {
+	GetLocationByID(ID int) (*Location, error)
+	// GetLocations returns the most popular locations named in lang
+	GetLocations(lang string, total int) ([]Location, error)
+	// ListLocations returns all locations named in every language,
+	// most popular first
+	ListLocations() ([]NamedLocation, error)
+	// MatchTripLocations links both ends of the trip to the nearest
+	// location within radius (m), or none if there isn't any
+	MatchTripLocations(tripID int, radius float64) error
+	// NearestLocation returns the location nearest to coords within
+	// radius (m) and how far (m) it is, or ErrNotFound if there isn't any
+	NearestLocation(coords [2]float64, radius float64) (*Location, float64, error)
+	// RecomputePopularity scores every location by the trips from & to it
+	// which aren't cancelled; a trip counts half as much every halfLife
+	RecomputePopularity(now time.Time, halfLife time.Duration) error
+}
+
+// GetLocationByID returns a location in Location struct
+func (app *HailingApp) GetLocationByID(ID int) (*Location, error) {
+	result, err := app.locations.GetLocationByID(ID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[GetLocationByID] location ID: %v -- %v", ID, result)
+	return result, nil
+}
+
+// PickupPoint is the designated location a pin is snapped to, and how far
+// (m) the rider walks there from the pin
+type PickupPoint struct {
+	Location
+	Walk float64
+}
+
+// SnapPin returns the pickup point nearest to a shared pin within
+// pickupSnapRadius, or nil if there isn't any
+func (app *HailingApp) SnapPin(coords [2]float64) *PickupPoint {
+	loc, walk, err := app.locations.NearestLocation(coords, app.pickupSnapRadius)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Printf("[SnapPin] %v: %v", coords, err)
+		}
+		return nil
+	}
+	return &PickupPoint{Location: *loc, Walk: walk}
+}
+
+// GetLocations return most popular locations
+func (app *HailingApp) GetLocations(lang string, total int) ([]Location, error) {
+	return app.locations.GetLocations(lang, total)
+}
+
+// FindOrCreateUser handles user query from line user id
+func (app *HailingApp) FindOrCreateUser(lineUserID string) (*User, error) {
+	user, err := app.users.FindUserByLineID(lineUserID)
+	if err == ErrNotFound {
+		// we have to create a new record
+		profile, botErr := app.messenger.Profile(lineUserID)
+		if botErr != nil {
+			return nil, botErr
+		}
+		username := profile.DisplayName
+		profileURL := profile.PictureURL
+		uC, errC := app.CreateUser(username, lineUserID, profileURL)
+		if errC != nil {
+			return nil, errC
+		}
+		return uC, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// FindUserByID is to find LineUserID from the system ID
+func (app *HailingApp) FindUserByID(ID uuid.UUID) (*User, error) {
+	return app.users.FindUserByID(ID)
+}
+
+// CreateUser handles user creation
+func (app *HailingApp) CreateUser(username string, lineUserID string, profileURL string) (*User, error) {
+	return app.users.CreateUser(username, lineUserID, profileURL)
+}
+
+// SaveReservationToPostgres is to record this completed reservation to a permanent medium (postgresl)
+func (app *HailingApp) SaveReservationToPostgres(rec *ReservationRecord) (int, error) {
+	tripID, err := app.trips.SaveReservation(rec)
+	if err != nil {
+		return tripID, err
+	}
+	// popularity follows real demand; the trip is saved regardless
+	if err := app.locations.MatchTripLocations(tripID, app.locationRadius); err != nil {
+		log.Printf("[SaveReservationToPostgres] match locations of trip#%d: %v", tripID, err)
+	}
+	return tripID, nil
+}
+
+// FindActiveReservation query from postgresql and put in redis
+func (app *HailingApp) FindActiveReservation(lineUserID string) (*ReservationRecord, error) {
+	return app.trips.FindActiveReservation(lineUserID)
+}
+
+// SaveTripFeedback update feedback from user
+func (app *HailingApp) SaveTripFeedback(tripID int, rating int) (string, error) {
+	if err := app.trips.SaveTripFeedback(tripID, rating); err != nil {
+		return "failed", err
+	}
+	return strconv.Itoa(tripID), nil
+}
+
+// SetLanguage save preferred lanague to "user" table
+func (app *HailingApp) SetLanguage(userID uuid.UUID, lang string) (string, error) {
+	if err := app.users.SetLanguage(userID, lang); err != nil {
+		return "failed", err
+	}
+	return "ok", nil
+}
+
+// GetTripRecordByID returns trip record
+func (app *HailingApp) GetTripRecordByID(tripID int) (*Trip, error) {
+	trip, err := app.trips.GetTrip(tripID)
+	if err != nil {
+		log.Printf("[GetTripRecord] %v", err)
+		return nil, err
+	}
+	return trip, nil
+}
+
+// GetTripRecord returns trip record
+func (app *HailingApp) GetTripRecord(rec *ReservationRecord) (*Trip, error) {
+	return app.GetTripRecordByID(rec.TripID)
+}
+
+// CancelReservation will handle whether it's okay to cancel or not too
+func (app *HailingApp) CancelReservation(rec *ReservationRecord) (string, error) {
+	trip, err := app.GetTripRecord(rec)
+	if err != nil {
+		return "failed", err
+	}
+	if trip.CancelledAt != nil {
+		return "failed", ErrTripCancelled
+	}
+	if trip.IsDriverAssigned() {
+		return "failed", errors.New("Contact assigned driver for cancellation")
+	}
+	fmt.Print("[PSQL-CANCEL] ", trip)
+	if trip.IsPickedUp() {
+		// cancel isn't possible now
+		return "failed", errors.New("Cancellation is not allowed at this point")
+	}
+	note := "User cancelled via line-bot"
+	err = app.trips.CancelTrip(rec.TripID, note, time.Now())
+	if err != nil {
+		log.Printf("[save2psql-cancel] [1] %v", err)
+		return "failed", err
+	}
+	return "success", nil
+}
+
+// CheckTripChangeable applies the same guards as CancelReservation before
+// the rider changes a confirmed trip
+func (app *HailingApp) CheckTripChangeable(rec *ReservationRecord) error {
+	trip, err := app.GetTripRecord(rec)
+	if err != nil {
+		return err
+	}
+	if trip.IsDriverAssigned() {
+		return errors.New("Contact assigned driver for any change")
+	}
+	if trip.IsPickedUp() {
+		return errors.New("Change is not allowed at this point")
+	}
+	return nil
+}
+
+// UpdateCancellationReason appends the reason to the cancelled trip's note
+func (app *HailingApp) UpdateCancellationReason(tripID string, reason string) (string, error) {
+	note := fmt.Sprintf("User cancelled via line-bot\nreason: %s", reason)
+	ID, err := strconv.Atoi(tripID)
+	if err != nil {
+		return "failed", err
+	}
+	err = app.trips.SetTripNote(ID, note)
+	if err != nil {
+		log.Printf("[save2psql-cancel] [2] %v", err)
+		return "failed", err
+	}
+	return "success", nil
+
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// Subscription is a recurring ride, e.g. condo to BTS every weekday morning
+type Subscription struct {
+	ID              int
+	UserID          uuid.UUID
+	From            string
+	FromCoords      [2]float64
+	To              string
+	ToCoords        [2]float64
+	Weekdays        []time.Weekday
+	PickupTime      string // 15:04 in Asia/Bangkok
+	NumOfPassengers int
+	Paused          bool
+	SkipDates       []string // 2006-01-02
+	// LastScheduled is the date of the latest trip created from this
+	LastScheduled string
+	StoppedAt     *time.Time
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseWeekdays takes "weekdays", "daily" or a list like "mon,wed,fri"
+func ParseWeekdays(text string) ([]time.Weekday, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "weekdays", "weekday":
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+	case "daily", "everyday":
+		return []time.Weekday{
+			time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday, time.Saturday,
+		}, nil
+	}
+	seen := map[time.Weekday]bool{}
+	for _, one := range strings.Split(strings.ToLower(text), ",") {
+		one = strings.TrimSpace(one)
+		if len(one) > 3 {
+			one = one[:3]
+		}
+		idx := indexOf(one, weekdayNames)
+		if idx == -1 {
+			return nil, fmt.Errorf("Unknown day: %q", one)
+		}
+		seen[time.Weekday(idx)] = true
+	}
+	results := []time.Weekday{}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if seen[day] {
+			results = append(results, day)
+		}
+	}
+	return results, nil
+}
+
+// WeekdayMask packs weekdays into bits, Sunday is the 1st bit
+func WeekdayMask(days []time.Weekday) int {
+	mask := 0
+	for _, day := range days {
+		mask |= 1 << uint(day)
+	}
+	return mask
+}
+
+// WeekdaysFromMask unpacks WeekdayMask
+func WeekdaysFromMask(mask int) []time.Weekday {
+	results := []time.Weekday{}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if mask&(1<<uint(day)) != 0 {
+			results = append(results, day)
+		}
+	}
+	return results
+}
+
+// DaysText returns weekdays in short form, e.g. Mon, Wed, Fri
+func (sub *Subscription) DaysText() string {
+	return weekdaysText(sub.Weekdays)
+}
+
+func weekdaysText(days []time.Weekday) string {
+	names := []string{}
+	for _, day := range days {
+		names = append(names, day.String()[:3])
+	}
+	return strings.Join(names, ", ")
+}
+
+func (sub *Subscription) isSkipped(date string) bool {
+	for _, skip := range sub.SkipDates {
+		if skip == date {
+			return true
+		}
+	}
+	return false
+}
+
+// Pickups returns pickup times of this subscription in (from, to]
+// which are neither skipped nor scheduled yet
+func (sub *Subscription) Pickups(from time.Time, to time.Time) []time.Time {
+	hhmm, err := time.Parse("15:04", sub.PickupTime)
+	if err != nil {
+		log.Printf("[Subscription] #%d pickup time: %v", sub.ID, err)
+		return nil
+	}
+	results := []time.Time{}
+	start := from.In(bangkok)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, bangkok)
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if date <= sub.LastScheduled || sub.isSkipped(date) {
+			continue
+		}
+		matched := false
+		for _, weekday := range sub.Weekdays {
+			if weekday == day.Weekday() {
+				matched = true
+			}
+		}
+		pickup := time.Date(day.Year(), day.Month(), day.Day(), hhmm.Hour(), hhmm.Minute(), 0, 0, bangkok)
+		if matched && pickup.After(from) && !pickup.After(to) {
+			results = append(results, pickup)
+		}
+	}
+	return results
+}
+
+// Record returns the reservation of this subscription at pickup time
+func (sub *Subscription) Record(user *User, pickup time.Time) *ReservationRecord {
+	return &ReservationRecord{
+		State:           StateDone,
+		Waiting:         StatePickup,
+		IsConfirmed:     true,
+		TripID:          -1,
+		UserID:          user.ID,
+		LineUserID:      user.LineUserID,
+		From:            sub.From,
+		FromCoords:      sub.FromCoords,
+		To:              sub.To,
+		ToCoords:        sub.ToCoords,
+		ReservedAt:      pickup,
+		NumOfPassengers: sub.NumOfPassengers,
+	}
+}
+
+// ScheduleRecurringRides creates trips of subscriptions whose pickup is
+// within subscription lead time and tells the riders. A pickup is claimed
+// before its trip is created so it's booked at most once, even by several
+// instances. Pickups of riders who still have a trip aren't claimed; they're
+// tried again until pickup time. It returns how many trips are created.
+func (app *HailingApp) ScheduleRecurringRides(now time.Time) (int, error) {
+	subs, err := app.subscriptions.ActiveSubscriptions()
+	if err != nil {
+		return 0, err
+	}
+	created := 0
+	for i := range subs {
+		sub := subs[i]
+		pickups := sub.Pickups(now, now.Add(app.subscriptionLeadTime))
+		if len(pickups) == 0 {
+			continue
+		}
+		user, err := app.FindUserByID(sub.UserID)
+		if err != nil {
+			log.Printf("[Subscription] #%d user: %v", sub.ID, err)
+			continue
+		}
+		localizer := i18n.NewLocalizer(app.i18nBundle, user.Language)
+		for _, pickup := range pickups {
+			date := pickup.Format("2006-01-02")
+			busy, err := app.HasRecord(user.LineUserID)
+			if err != nil {
+				log.Printf("[Subscription] #%d %s: %v", sub.ID, date, err)
+				break
+			}
+			if busy {
+				log.Printf("[Subscription] #%d %s: %v", sub.ID, date, ErrActiveTrip)
+				if app.claimBusyNotice(sub.ID, date) {
+					app.PushNotification(user.LineUserID, TextMessage{Text: RecurringRideSkippedText(date, ErrActiveTrip, localizer)})
+				}
+				continue
+			}
+			ok, err := app.subscriptions.ClaimPickup(sub.ID, date)
+			if err != nil {
+				log.Printf("[Subscription] #%d claim %s: %v", sub.ID, date, err)
+				break
+			}
+			if !ok {
+				// paused, skipped or stopped meanwhile, or booked elsewhere
+				continue
+			}
+			rec := sub.Record(user, pickup)
+			if err := app.checkRecurringRide(rec); err != nil {
+				log.Printf("[Subscription] #%d %s: %v", sub.ID, date, err)
+				app.PushNotification(user.LineUserID, TextMessage{Text: RecurringRideSkippedText(date, err, localizer)})
+				continue
+			}
+			tripID, err := app.SaveReservationToPostgres(rec)
+			if err != nil {
+				log.Printf("[Subscription] #%d trip: %v", sub.ID, err)
+				app.PushNotification(user.LineUserID, TextMessage{Text: RecurringRideSkippedText(date, err, localizer)})
+				continue
+			}
+			created++
+
+			trip, err := app.GetTripRecordByID(tripID)
+			if err != nil {
+				continue
+			}
+			app.PushNotification(user.LineUserID, app.RecurringRideFlex(trip, localizer))
+		}
+	}
+	return created, nil
+}
+
+// ErrActiveTrip is returned for a recurring ride of a rider who is
+// still on, or booking, another trip
+var ErrActiveTrip = errors.New("Rider already has a trip")
+
+// claimBusyNotice makes sure the rider is told only once that the pickup
+// on date waits for their other trip
+func (app *HailingApp) claimBusyNotice(subID int, date string) bool {
+	key := fmt.Sprintf("subscription:%d:%s:busy", subID, date)
+	ok, err := app.sessions.CompareAndSwap(key, nil, []byte("sent"), 24*time.Hour)
+	return err == nil && ok
+}
+
+// checkRecurringRide validates rec the same way riders' own bookings are
+func (app *HailingApp) checkRecurringRide(rec *ReservationRecord) error {
+	if err := CurrentServiceArea().CheckOpen(rec.FromCoords, rec.ReservedAt); err != nil {
+		return err
+	}
+	_, err := app.ValidateTrip(rec)
+	return err
+}
+
+// RecurringRideSkippedText tells the rider why the ride on date isn't booked
+func RecurringRideSkippedText(date string, err error, localizer *i18n.Localizer) string {
+	if errors.Is(err, ErrActiveTrip) {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "RecurringRideBusy",
+				Other: "Your recurring ride on {{.Date}} isn't booked yet as you already have a ride. It will be once that ride is over.",
+			},
+			TemplateData: map[string]string{"Date": date},
+		})
+	}
+	return localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "RecurringRideSkipped",
+			Other: "Your recurring ride on {{.Date}} isn't booked. {{.Reason}}",
+		},
+		TemplateData: map[string]string{"Date": date, "Reason": ErrorText(err, localizer)},
+	})
+}
+
+// RunRecurringRides schedules recurring rides every reminder interval
+// until stop is closed
+func (app *HailingApp) RunRecurringRides(stop <-chan struct{}) {
+	runEvery(app.reminderInterval, stop, func(now time.Time) {
+		created, err := app.ScheduleRecurringRides(now)
+		if err != nil {
+			log.Printf("[Subscription] %v", err)
+		} else if created > 0 {
+			log.Printf("[Subscription] %d trip(s) created", created)
+		}
+	})
+}
+
+// RecurringRideFlex tells the rider the trip of their subscription is booked
+func (app *HailingApp) RecurringRideFlex(trip *Trip, localizer *i18n.Localizer) Message {
+	title := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "RecurringRideBooked",
+			Other: "Your recurring ride is booked",
+		},
+	})
+	ok := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "OK",
+			Other: "OK",
+		},
+	})
+	cancel := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "CancelThisRide",
+			Other: "Cancel this ride",
+		},
+	})
+	return app.TripAnswerFlex(trip, title, ok, cancel, localizer)
+}
+
+// CreateSubscription repeats the rider's trip of tripID, which may be a past
+// one, on weekdays. Without tripID, it's the confirmed trip.
+func (app *HailingApp) CreateSubscription(lineUserID string, weekdays []time.Weekday, tripID string) (*Subscription, error) {
+	var sub Subscription
+	var reservedAt time.Time
+	if tripID == "" {
+		rec, err := app.FindRecord(lineUserID)
+		if err != nil || !rec.IsConfirmed || rec.TripID == -1 {
+			return nil, errors.New("Book a ride first, then repeat it")
+		}
+		sub = Subscription{
+			UserID:          rec.UserID,
+			From:            rec.From,
+			FromCoords:      rec.FromCoords,
+			To:              rec.To,
+			ToCoords:        rec.ToCoords,
+			NumOfPassengers: rec.NumOfPassengers,
+		}
+		reservedAt = rec.ReservedAt
+	} else {
+		user, err := app.FindOrCreateUser(lineUserID)
+		if err != nil {
+			return nil, err
+		}
+		ID, err := strconv.Atoi(tripID)
+		if err != nil {
+			return nil, errors.New("Trip not found")
+		}
+		trip, err := app.GetTripRecordByID(ID)
+		if err != nil || trip.UserID != user.ID || trip.CancelledAt != nil || trip.ReservedAt == nil {
+			return nil, errors.New("Trip not found")
+		}
+		from, to, err := app.trips.GetTripPlaces(ID)
+		if err != nil {
+			return nil, err
+		}
+		sub = Subscription{
+			UserID:          trip.UserID,
+			From:            trip.From,
+			FromCoords:      from,
+			To:              trip.To,
+			ToCoords:        to,
+			NumOfPassengers: trip.NumOfPassengers,
+		}
+		reservedAt = *trip.ReservedAt
+	}
+	sub.Weekdays = weekdays
+	sub.PickupTime = reservedAt.In(bangkok).Format("15:04")
+	// the day of the trip has its ride already
+	sub.LastScheduled = reservedAt.In(bangkok).Format("2006-01-02")
+	ID, err := app.subscriptions.CreateSubscription(&sub)
+	if err != nil {
+		return nil, err
+	}
+	sub.ID = ID
+	return &sub, nil
+}
+
+// findSubscription returns the rider's subscription by ID text
+func (app *HailingApp) findSubscription(user *User, subID string) (*Subscription, error) {
+	ID, err := strconv.Atoi(subID)
+	if err != nil {
+		return nil, errors.New("Recurring ride not found")
+	}
+	sub, err := app.subscriptions.GetSubscription(ID)
+	if err != nil || sub.UserID != user.ID || sub.StoppedAt != nil {
+		return nil, errors.New("Recurring ride not found")
+	}
+	return sub, nil
+}
+
+// RecurringRideHandler handles /repeat commands:
+//
+//	/repeat                        list recurring rides
+//	/repeat:weekdays               repeat the confirmed trip (or daily, mon,wed,fri)
+//	/repeat:weekdays:<trip id>     repeat the past trip
+//	/repeat:pause:<id>             pause, /repeat:resume:<id> to resume
+//	/repeat:skip:<id>:<2006-01-02> skip a date
+//	/repeat:stop:<id>              stop the series
+func (app *HailingApp) RecurringRideHandler(replyToken string, lineUserID string, args []string) error {
+	user, localizer, err := app.Localizer(lineUserID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	if len(args) == 0 || args[0] == "" {
+		return app.replyText(replyToken, app.subscriptionList(user, localizer))
+	}
+
+	action := strings.ToLower(args[0])
+	switch action {
+	case "pause", "resume", "skip", "stop":
+		if len(args) < 2 {
+			return app.replyText(replyToken, "missing arguments")
+		}
+		sub, err := app.findSubscription(user, args[1])
+		if err != nil {
+			return app.replyText(replyToken, err.Error())
+		}
+		switch action {
+		case "pause":
+			sub.Paused = true
+		case "resume":
+			sub.Paused = false
+		case "stop":
+			now := time.Now()
+			sub.StoppedAt = &now
+		case "skip":
+			if len(args) < 3 {
+				return app.replyText(replyToken, "missing arguments")
+			}
+			date, err := time.Parse("2006-01-02", args[2])
+			if err != nil {
+				return app.replyText(replyToken, "Date must be like 2006-01-02")
+			}
+			if args[2] <= sub.LastScheduled {
+				alreadyBooked := localizer.MustLocalize(&i18n.LocalizeConfig{
+					DefaultMessage: &i18n.Message{
+						ID:    "RecurringRideAlreadyBooked",
+						Other: "The ride on {{.Date}} is already booked, please cancel it instead.",
+					},
+					TemplateData: map[string]string{
+						"Date": date.Format("Mon 2 Jan"),
+					},
+				})
+				return app.replyText(replyToken, alreadyBooked)
+			}
+			if !sub.isSkipped(args[2]) {
+				sub.SkipDates = append(sub.SkipDates, args[2])
+			}
+		}
+		if err := app.subscriptions.UpdateSubscription(sub); err != nil {
+			return app.replyText(replyToken, err.Error())
+		}
+		return app.replyText(replyToken, app.subscriptionList(user, localizer))
+	}
+
+	weekdays, err := ParseWeekdays(args[0])
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	tripID := ""
+	if len(args) > 1 {
+		tripID = args[1]
+	}
+	sub, err := app.CreateSubscription(lineUserID, weekdays, tripID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	created := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "RecurringRideCreated",
+			Other: "Your ride from {{.From}} to {{.To}} repeats every {{.Days}} at {{.Time}}.",
+		},
+		TemplateData: map[string]string{
+			"From": sub.From,
+			"To":   sub.To,
+			"Days": sub.DaysText(),
+			"Time": sub.PickupTime,
+		},
+	})
+	return app.replyText(replyToken, created)
+}
+
+// subscriptionList describes all recurring rides of the user & commands
+func (app *HailingApp) subscriptionList(user *User, localizer *i18n.Localizer) string {
+	subs, err := app.subscriptions.FindSubscriptions(user.ID)
+	if err != nil {
+		return err.Error()
+	}
+	if len(subs) == 0 {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "NoRecurringRide",
+				Other: "You have no recurring ride. Book a ride, then send /repeat:weekdays to repeat it.",
+			},
+		})
+	}
+	paused := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Paused",
+			Other: "paused",
+		},
+	})
+	lines := []string{}
+	for _, sub := range subs {
+		line := fmt.Sprintf("#%d %s %s: %s → %s (%d 👤)",
+			sub.ID, sub.DaysText(), sub.PickupTime, sub.From, sub.To, sub.NumOfPassengers)
+		if sub.Paused {
+			line += fmt.Sprintf(" [%s]", paused)
+		}
+		if len(sub.SkipDates) > 0 {
+			line += fmt.Sprintf("\n   skip: %s", strings.Join(sub.SkipDates, ", "))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", "/repeat:pause:<id>  /repeat:resume:<id>", "/repeat:skip:<id>:<yyyy-mm-dd>  /repeat:stop:<id>")
+	return strings.Join(lines, "\n")
+}
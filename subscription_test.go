@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSubscriptionPickups(t *testing.T) {
+	weekdays, err := ParseWeekdays("weekdays")
+	if err != nil || WeekdayMask(weekdays) != 0x3e {
+		t.Fatalf("unexpected weekdays: %v %v", weekdays, err)
+	}
+	if days, _ := ParseWeekdays("Fri,mon"); len(days) != 2 || days[0] != time.Monday {
+		t.Errorf("days must be sorted: %v", days)
+	}
+	if _, err := ParseWeekdays("someday"); err == nil {
+		t.Error("unknown day must be rejected")
+	}
+
+	sub := Subscription{
+		Weekdays:      WeekdaysFromMask(WeekdayMask(weekdays)),
+		PickupTime:    "08:30",
+		SkipDates:     []string{"2020-06-03"},
+		LastScheduled: "2020-06-01",
+	}
+	// Mon 1 Jun 2020 to Mon 8 Jun 2020
+	from := time.Date(2020, 6, 1, 9, 0, 0, 0, bangkok)
+	pickups := sub.Pickups(from, from.AddDate(0, 0, 7))
+	got := []string{}
+	for _, pickup := range pickups {
+		got = append(got, pickup.Format("Mon 2 15:04"))
+	}
+	want := []string{"Tue 2 08:30", "Thu 4 08:30", "Fri 5 08:30", "Mon 8 08:30"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expect %v, got %v", want, got)
+	}
+}
+
+func TestRecurringRide(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "nothing-yet", Text: "/repeat", Expect: "You have no recurring ride."},
+		{Name: "no-trip", Text: "/repeat:daily", Expect: "Book a ride first"},
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+		{Name: "passengers", Text: "2", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+		{Name: "repeat", Postback: "/repeat:daily", Expect: "repeats every Sun, Mon, Tue, Wed, Thu, Fri, Sat"},
+	})
+	user, _ := fl.Store.FindUserByLineID(rider)
+	subs, _ := fl.Store.FindSubscriptions(user.ID)
+	if len(subs) != 1 || subs[0].To != "BTS Phromphong" || subs[0].NumOfPassengers != 2 {
+		t.Fatalf("unexpected subscriptions: %v", subs)
+	}
+	sub := subs[0]
+
+	// today's trip is the one just booked
+	now := time.Now()
+	if created, err := app.ScheduleRecurringRides(now); err != nil || created != 0 {
+		t.Errorf("nothing to schedule yet: %d %v", created, err)
+	}
+	today, _ := fl.Store.FindActiveReservation(rider)
+	fl.Store.UpdateTrip(today.TripID, func(trip *MemoryTrip) {
+		trip.DroppedOffAt = &now
+	})
+	app.Cleanup(rider)
+	tomorrow := now.Add(22 * time.Hour)
+	if created, err := app.ScheduleRecurringRides(tomorrow); err != nil || created != 1 {
+		t.Fatalf("expect 1 trip: %d %v", created, err)
+	}
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(time.Minute)); created != 0 {
+		t.Errorf("trip must be created only once: %d", created)
+	}
+	pushes := fl.Pushes(rider)
+	if len(pushes) != 1 || pushes[0].Summary() != "Your recurring ride is booked" {
+		t.Fatalf("unexpected pushes: %v", pushes)
+	}
+	trips, _ := fl.Store.UpcomingTrips(tomorrow, tomorrow.Add(app.subscriptionLeadTime))
+	if len(trips) != 1 || trips[0].UserID != user.ID || trips[0].To != "BTS Phromphong" {
+		t.Fatalf("unexpected trips: %v", trips)
+	}
+
+	booked := trips[0].ReservedAt.In(bangkok).Format("2006-01-02")
+	skipped := trips[0].ReservedAt.In(bangkok).AddDate(0, 0, 1).Format("2006-01-02")
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "skip-booked", Text: fmt.Sprintf("/repeat:skip:%d:%s", sub.ID, booked), Expect: "already booked"},
+		{Name: "skip", Text: fmt.Sprintf("/repeat:skip:%d:%s", sub.ID, skipped), Expect: "skip: " + skipped},
+		{Name: "not-yours", Text: fmt.Sprintf("/repeat:pause:%d", sub.ID+1), Expect: "Recurring ride not found"},
+	})
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(24 * time.Hour)); created != 0 {
+		t.Errorf("skipped date must not be scheduled: %d", created)
+	}
+
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "pause", Text: fmt.Sprintf("/repeat:pause:%d", sub.ID), Expect: "[paused]"},
+	})
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(48 * time.Hour)); created != 0 {
+		t.Errorf("paused subscription must not be scheduled: %d", created)
+	}
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "resume", Text: fmt.Sprintf("/repeat:resume:%d", sub.ID), Expect: "BTS Phromphong"},
+	})
+	// the trip booked before isn't done yet
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(72 * time.Hour)); created != 0 {
+		t.Errorf("rider with a trip must not get another one: %d", created)
+	}
+	pushes = fl.Pushes(rider)
+	if last := pushes[len(pushes)-1].Summary(); !strings.Contains(last, "you already have a ride") {
+		t.Errorf("rider must be told, got %q", last)
+	}
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(72*time.Hour + time.Minute)); created != 0 {
+		t.Errorf("rider with a trip must not get another one: %d", created)
+	}
+	if told := len(fl.Pushes(rider)) - len(pushes); told != 0 {
+		t.Errorf("rider must be told only once, got %d more", told)
+	}
+	if _, err := app.sessions.Get(recordKey(rider)); err == nil {
+		t.Error("scheduler must not load the rider's trip into session")
+	}
+	// the pickup is booked once the other trip is over
+	fl.Store.UpdateTrip(trips[0].ID, func(trip *MemoryTrip) {
+		trip.DroppedOffAt = &now
+	})
+	if created, err := app.ScheduleRecurringRides(tomorrow.Add(72*time.Hour + 2*time.Minute)); err != nil || created != 1 {
+		t.Fatalf("pickup must be booked after the other trip: %d %v", created, err)
+	}
+	active, _ := fl.Store.FindActiveReservation(rider)
+	fl.Store.UpdateTrip(active.TripID, func(trip *MemoryTrip) {
+		trip.DroppedOffAt = &now
+	})
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "stop", Text: fmt.Sprintf("/repeat:stop:%d", sub.ID), Expect: "You have no recurring ride."},
+	})
+	if created, _ := app.ScheduleRecurringRides(tomorrow.Add(96 * time.Hour)); created != 0 {
+		t.Errorf("stopped subscription must not be scheduled: %d", created)
+	}
+}
+
+func TestRepeatPastTrip(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "BTS Phromphong", Expect: "Pickup location?"},
+		{Name: "from", Text: "Citi Resort", Expect: "When?"},
+		{Name: "when", Text: "+15min", Expect: "How many passengers?"},
+		{Name: "passengers", Text: "3", Expect: "EstTravelTime"},
+		{Name: "confirm", Postback: "confirm", Expect: "Your ride reservation is done."},
+	})
+	trip, _ := fl.Store.FindActiveReservation(rider)
+	now := time.Now()
+	fl.Store.UpdateTrip(trip.TripID, func(one *MemoryTrip) {
+		one.DroppedOffAt = &now
+	})
+	app.Cleanup(rider)
+
+	other := "U" + uuid.New().String()
+	runConversation(t, fl, app, other, []fakeLineStep{
+		{Name: "not-yours", Text: fmt.Sprintf("/repeat:daily:%d", trip.TripID), Expect: "Trip not found"},
+	})
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "no-trip", Text: "/repeat:daily", Expect: "Book a ride first"},
+		{Name: "unknown", Text: "/repeat:daily:abc", Expect: "Trip not found"},
+		{Name: "repeat", Text: fmt.Sprintf("/repeat:daily:%d", trip.TripID), Expect: "Your ride from Citi Resort to BTS Phromphong repeats every"},
+	})
+	user, _ := fl.Store.FindUserByLineID(rider)
+	subs, _ := fl.Store.FindSubscriptions(user.ID)
+	if len(subs) != 1 || subs[0].FromCoords != trip.FromCoords || subs[0].ToCoords != trip.ToCoords ||
+		subs[0].NumOfPassengers != 3 || subs[0].PickupTime != trip.ReservedAt.In(bangkok).Format("15:04") {
+		t.Fatalf("unexpected subscriptions: %v", subs)
+	}
+	if created, err := app.ScheduleRecurringRides(now.Add(22 * time.Hour)); err != nil || created != 1 {
+		t.Fatalf("expect 1 trip: %d %v", created, err)
+	}
+}
+
+func TestClaimPickup(t *testing.T) {
+	store := NewMemoryStore()
+	ID, _ := store.CreateSubscription(&Subscription{PickupTime: "08:30", LastScheduled: "2020-06-01", SkipDates: []string{"2020-06-03"}})
+	for _, tc := range []struct {
+		date   string
+		expect bool
+	}{
+		{"2020-06-01", false}, {"2020-06-02", true}, {"2020-06-02", false}, {"2020-06-03", false}, {"2020-06-04", true},
+	} {
+		if ok, err := store.ClaimPickup(ID, tc.date); err != nil || ok != tc.expect {
+			t.Errorf("%s: expect %v, got %v %v", tc.date, tc.expect, ok, err)
+		}
+	}
+	// a pause made while the scheduler holds an old copy still counts
+	sub, _ := store.GetSubscription(ID)
+	sub.Paused = true
+	store.UpdateSubscription(sub)
+	if ok, _ := store.ClaimPickup(ID, "2020-06-05"); ok {
+		t.Error("paused subscription must not be claimed")
+	}
+	if sub, _ := store.GetSubscription(ID); sub.LastScheduled != "2020-06-04" {
+		t.Errorf("pause must keep last scheduled date, got %q", sub.LastScheduled)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// PastTrip is a trip of the rider which suggestions learn from
+type PastTrip struct {
+	ID         int
+	From       string
+	FromCoords [2]float64
+	To         string
+	ToCoords   [2]float64
+	ReservedAt time.Time
+	// FromLocation & ToLocation are location IDs matched to the ends,
+	// 0 if none
+	FromLocation int
+	ToLocation   int
+}
+
+// Suggestion is a candidate place with its score and why it's ranked so
+type Suggestion struct {
+	Location
+	Score   float64
+	Reasons []string
+}
+
+func (s Suggestion) String() string {
+	return fmt.Sprintf("%s (%.2f: %s)", s.Name, s.Score, strings.Join(s.Reasons, ", "))
+}
+
+// SuggestionEngine ranks candidate places for "to" and "from" steps by the
+// rider's own trips. Each past trip counts less as it gets older.
+type SuggestionEngine struct {
+	// Frequency is added for each past trip which ends (or starts) here
+	Frequency float64
+	// TimeOfDay is added when that trip was around the same time of day
+	TimeOfDay float64
+	// Weekday is added when that trip was on the same kind of day,
+	// i.e. weekday or weekend
+	Weekday float64
+	// Pairing is added when the other end of that trip is the place
+	// already chosen
+	Pairing float64
+	// Popularity is the most a place gets from global popularity;
+	// it only breaks ties & ranks places the rider has never been to
+	Popularity float64
+	// HalfLife is how long until a past trip counts half as much
+	HalfLife time.Duration
+	// Window is how far apart time of day still counts as the same
+	Window time.Duration
+	// Radius is how close (m) a trip end must be to be at a place
+	Radius float64
+}
+
+// NewSuggestionEngine returns SuggestionEngine with default weights
+func NewSuggestionEngine() *SuggestionEngine {
+	return &SuggestionEngine{
+		Frequency:  1,
+		TimeOfDay:  1,
+		Weekday:    0.5,
+		Pairing:    2,
+		Popularity: 0.5,
+		HalfLife:   30 * 24 * time.Hour,
+		Window:     90 * time.Minute,
+		Radius:     100,
+	}
+}
+
+// near reports whether a & b are within the engine radius
+func (e *SuggestionEngine) near(a [2]float64, b [2]float64) bool {
+	if a == [2]float64{0, 0} || b == [2]float64{0, 0} {
+		return false
+	}
+	return geo.Distance(orb.Point(a), orb.Point(b)) <= e.Radius
+}
+
+// Rank scores candidates (in order of global popularity) as the answer of
+// state ("to" or "from") of rec at the given time. The place already chosen
+// for the other end isn't a candidate.
+func (e *SuggestionEngine) Rank(rec *ReservationRecord, state ReservationState, candidates []Location, history []PastTrip, at time.Time) []Suggestion {
+	at = at.In(bangkok)
+	chosen := rec.FromCoords
+	if state == StateFrom {
+		chosen = rec.ToCoords
+	}
+
+	results := []Suggestion{}
+	for rank, loc := range candidates {
+		here := loc.Place.Coordinates
+		if e.near(here, chosen) {
+			continue
+		}
+		s := Suggestion{Location: loc}
+		trips, usualTime, sameDay, paired := 0, 0, 0, 0
+		for _, trip := range history {
+			end, other := trip.ToCoords, trip.FromCoords
+			if state == StateFrom {
+				end, other = trip.FromCoords, trip.ToCoords
+			}
+			if !e.near(end, here) {
+				continue
+			}
+			weight := decay(at.Sub(trip.ReservedAt), e.HalfLife)
+			trips++
+			s.Score += e.Frequency * weight
+			pickup := trip.ReservedAt.In(bangkok)
+			if clockDistance(pickup, at) <= e.Window {
+				usualTime++
+				s.Score += e.TimeOfDay * weight
+			}
+			if isWeekend(pickup) == isWeekend(at) {
+				sameDay++
+				s.Score += e.Weekday * weight
+			}
+			if e.near(other, chosen) {
+				paired++
+				s.Score += e.Pairing * weight
+			}
+		}
+		if trips > 0 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%d trip(s)", trips))
+		}
+		if usualTime > 0 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("usual time x%d", usualTime))
+		}
+		if sameDay > 0 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("same kind of day x%d", sameDay))
+		}
+		if paired > 0 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("paired with chosen place x%d", paired))
+		}
+		s.Score += e.Popularity * float64(len(candidates)-rank) / float64(len(candidates))
+		s.Reasons = append(s.Reasons, fmt.Sprintf("popular #%d", rank+1))
+		results = append(results, s)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+// Candidates picks the places to rank from locations (most popular
+// first): the top popular ones, plus any the rider's past trips start or
+// end at, so a place the rider often goes to is there however unpopular
+// it is. Names are in lang.
+func (e *SuggestionEngine) Candidates(locations []NamedLocation, popular int, history []PastTrip, lang string) []Location {
+	visited := map[int]bool{}
+	for _, trip := range history {
+		visited[trip.FromLocation] = true
+		visited[trip.ToLocation] = true
+	}
+	results := []Location{}
+	for i, loc := range locations {
+		own := visited[loc.ID]
+		for _, trip := range history {
+			if own {
+				break
+			}
+			own = e.near(trip.FromCoords, loc.Place.Coordinates) || e.near(trip.ToCoords, loc.Place.Coordinates)
+		}
+		if i >= popular && !own {
+			continue
+		}
+		one := loc.Location
+		one.Name = loc.NameIn(lang)
+		results = append(results, one)
+	}
+	return results
+}
+
+// decay is the weight of something age old which halves every halfLife
+func decay(age time.Duration, halfLife time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// popularityScore turns sum of trip weights into popularity column;
+// it must match PostgresStore.RecomputePopularity
+func popularityScore(weights float64) int {
+	return int(math.Round(weights * 100))
+}
+
+// clockDistance is how far apart a & b are on the clock, ignoring dates
+func clockDistance(a time.Time, b time.Time) time.Duration {
+	minutes := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	diff := minutes(a) - minutes(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > 12*60 {
+		diff = 24*60 - diff
+	}
+	return time.Duration(diff) * time.Minute
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// tripHistory returns recent trips of the rider of rec; nil if anything
+// goes wrong, better popular places than nothing
+func (app *HailingApp) tripHistory(rec *ReservationRecord) []PastTrip {
+	history, err := app.trips.TripHistory(rec.UserID, 50)
+	if err != nil {
+		log.Printf("[Suggest] history of %s: %v", rec.LineUserID, err)
+		return nil
+	}
+	return history
+}
+
+// suggestAt is the time suggestions for rec are for
+func suggestAt(rec *ReservationRecord) time.Time {
+	if !rec.ReservedAt.IsZero() {
+		return rec.ReservedAt
+	}
+	return time.Now()
+}
+
+func (app *HailingApp) suggestionEngine() *SuggestionEngine {
+	if app.suggestions == nil {
+		return NewSuggestionEngine()
+	}
+	return app.suggestions
+}
+
+// SuggestLocations returns places to offer while rec is waiting for "to" or
+// "from", best first. Candidates are the popular places and the ones the
+// rider has been to; it falls back to global popularity for new riders.
+func (app *HailingApp) SuggestLocations(rec *ReservationRecord, lang string, total int) ([]Location, error) {
+	locations, err := app.locations.ListLocations()
+	if err != nil {
+		return nil, err
+	}
+	history := app.tripHistory(rec)
+	engine := app.suggestionEngine()
+	candidates := engine.Candidates(locations, 10, history, lang)
+	ranked := engine.Rank(rec, rec.Waiting, candidates, history, suggestAt(rec))
+	if len(ranked) > total {
+		ranked = ranked[:total]
+	}
+	results := []Location{}
+	logs := []string{}
+	for i, s := range ranked {
+		results = append(results, s.Location)
+		logs = append(logs, fmt.Sprintf("%d. %v", i+1, s))
+	}
+	log.Printf("[Suggest] %s for %s (%d past trip(s), %d candidate(s)): %s",
+		rec.Waiting, rec.LineUserID, len(history), len(candidates), strings.Join(logs, " | "))
+	return results, nil
+}
+
+// SuggestSavedPlaces orders the rider's saved places by their trips the
+// same way as SuggestLocations; places stay in saved order otherwise
+func (app *HailingApp) SuggestSavedPlaces(rec *ReservationRecord, places []SavedPlace) []SavedPlace {
+	if len(places) < 2 {
+		return places
+	}
+	candidates := []Location{}
+	byID := map[int]SavedPlace{}
+	for _, place := range places {
+		candidates = append(candidates, Location{ID: place.ID, Name: place.Name, Place: place.Place})
+		byID[place.ID] = place
+	}
+	history := app.tripHistory(rec)
+	ranked := app.suggestionEngine().Rank(rec, rec.Waiting, candidates, history, suggestAt(rec))
+	results := []SavedPlace{}
+	for _, s := range ranked {
+		results = append(results, byID[s.ID])
+	}
+	return results
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSuggestionEngineRank(t *testing.T) {
+	condo := Location{ID: 1, Name: "condo a", Place: Coords{Coordinates: placeCoords("condo a")}}
+	citi := Location{ID: 2, Name: "citi resort", Place: Coords{Coordinates: placeCoords("citi resort")}}
+	bts := Location{ID: 3, Name: "bts phromphong", Place: Coords{Coordinates: placeCoords("bts phromphong")}}
+	// in order of global popularity
+	candidates := []Location{citi, condo, bts}
+
+	// weekday mornings condo -> BTS, evenings BTS -> condo
+	history := []PastTrip{}
+	monday := time.Date(2020, 6, 1, 0, 0, 0, 0, bangkok)
+	for day := 0; day < 5; day++ {
+		date := monday.AddDate(0, 0, -7+day)
+		history = append(history,
+			PastTrip{From: "condo a", FromCoords: condo.Place.Coordinates, To: "bts", ToCoords: bts.Place.Coordinates, ReservedAt: date.Add(8 * time.Hour)},
+			PastTrip{From: "bts", FromCoords: bts.Place.Coordinates, To: "condo a", ToCoords: condo.Place.Coordinates, ReservedAt: date.Add(18 * time.Hour)},
+		)
+	}
+	engine := NewSuggestionEngine()
+
+	tests := []struct {
+		name    string
+		rec     ReservationRecord
+		state   ReservationState
+		history []PastTrip
+		at      time.Time
+		expect  []string
+	}{
+		{"new rider gets popular places", ReservationRecord{}, StateTo, nil, monday.Add(8 * time.Hour), []string{"citi resort", "condo a", "bts phromphong"}},
+		{"to in the morning", ReservationRecord{}, StateTo, history, monday.Add(8 * time.Hour), []string{"bts phromphong", "condo a", "citi resort"}},
+		{"to in the evening", ReservationRecord{}, StateTo, history, monday.Add(18 * time.Hour), []string{"condo a", "bts phromphong", "citi resort"}},
+		{"from goes with chosen to", ReservationRecord{ToCoords: bts.Place.Coordinates}, StateFrom, history, monday.Add(18 * time.Hour), []string{"condo a", "citi resort"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ranked := engine.Rank(&tc.rec, tc.state, candidates, tc.history, tc.at)
+			got := []string{}
+			for _, s := range ranked {
+				got = append(got, s.Name)
+				if len(s.Reasons) == 0 {
+					t.Errorf("%s has no reason", s.Name)
+				}
+			}
+			if len(got) != len(tc.expect) {
+				t.Fatalf("expect %v, got %v", tc.expect, got)
+			}
+			for i := range got {
+				if got[i] != tc.expect[i] {
+					t.Fatalf("expect %v, got %v (%v)", tc.expect, got, ranked)
+				}
+			}
+		})
+	}
+
+	// old habits fade
+	old := []PastTrip{{To: "citi", ToCoords: citi.Place.Coordinates, ReservedAt: monday.AddDate(-1, 0, 0)}}
+	recent := append(old, PastTrip{To: "bts", ToCoords: bts.Place.Coordinates, ReservedAt: monday.AddDate(0, 0, -1)})
+	ranked := engine.Rank(&ReservationRecord{}, StateTo, candidates, recent, monday)
+	if ranked[0].Name != "bts phromphong" {
+		t.Errorf("recent trip must win: %v", ranked)
+	}
+}
+
+func TestSuggestOwnPlaceOutsidePopular(t *testing.T) {
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	condo := placeCoords("condo a")
+	for i := 0; i < 10; i++ {
+		fl.Store.AddLocation(MemoryLocation{
+			Location:   Location{ID: 100 + i, Name: fmt.Sprintf("popular %d", i), Place: Coords{Coordinates: [2]float64{condo[0] + 0.002*float64(i+1), condo[1]}}},
+			Popularity: 1000 - i,
+		})
+	}
+	market := [2]float64{condo[0], condo[1] - 0.01}
+	fl.Store.AddLocation(MemoryLocation{
+		Location: Location{ID: 200, Name: "soi market", Place: Coords{Coordinates: market}},
+		Names:    map[string]string{"th": "ตลาดซอย"},
+	})
+
+	user, _ := fl.Store.CreateUser("rider", "U"+uuid.New().String(), "")
+	rec := &ReservationRecord{TripID: -1, UserID: user.ID, LineUserID: user.LineUserID, From: "condo a", FromCoords: condo}
+	for day := 1; day <= 3; day++ {
+		trip := *rec
+		trip.To, trip.ToCoords, trip.ReservedAt = "market", market, time.Now().AddDate(0, 0, -day)
+		if _, err := app.SaveReservationToPostgres(&trip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec.Waiting = StateTo
+	locations, err := app.SuggestLocations(rec, "th", 4)
+	if err != nil || len(locations) == 0 || locations[0].ID != 200 || locations[0].Name != "ตลาดซอย" {
+		t.Fatalf("expect the rider's market first, got %v %v", locations, err)
+	}
+}
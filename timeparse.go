@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// bangkok is the time zone of riders, pickup times and zone hours. Thailand
+// has no daylight saving time, so it doesn't need tzdata, which the runtime
+// image doesn't have.
+var bangkok = time.FixedZone("ICT", 7*60*60)
+
+// Pickup time in words, e.g. "tomorrow 7am", "ครึ่งชั่วโมง" or "30分後".
+// Patterns are matched on the whole message in any language; a relative
+// time wins over a clock time, and a day alone isn't a time.
+
+// relativePatterns are "in N minutes" and the like; unit is taken from
+// the second group when it's empty
+var relativePatterns = []struct {
+	pattern *regexp.Regexp
+	unit    time.Duration
+}{
+	{regexp.MustCompile(`(?i)\bright now\b|\bnow\b|\basap\b|ตอนนี้|เดี๋ยวนี้|今すぐ|^\s*(?:今|いま)\s*$`), 0},
+	{regexp.MustCompile(`(?i)\+\s*(\d+)\s*(min|hour)`), 0},
+	{regexp.MustCompile(`(?i)\bin\s+(\d+)\s*(minutes?|mins?|hours?|hrs?|h)\b`), 0},
+	{regexp.MustCompile(`(?i)\bin\s+half\s+an\s+hour\b|(?:อีก\s*)?ครึ่งชั่วโมง`), 30 * time.Minute},
+	{regexp.MustCompile(`(?i)\bin\s+an\s+hour\b|อีก\s*(?:หนึ่ง|1\s*)?ชั่วโมง(?:นึง)?`), time.Hour},
+	{regexp.MustCompile(`อีก\s*(\d+)\s*(นาที|ชั่วโมง|ชม)`), 0},
+	{regexp.MustCompile(`(\d+)\s*(分|時間)後`), 0},
+}
+
+var relativeUnits = map[string]time.Duration{
+	"min": time.Minute, "minute": time.Minute, "minutes": time.Minute, "mins": time.Minute,
+	"hour": time.Hour, "hours": time.Hour, "hr": time.Hour, "hrs": time.Hour, "h": time.Hour,
+	"นาที": time.Minute, "ชั่วโมง": time.Hour, "ชม": time.Hour,
+	"分": time.Minute, "時間": time.Hour,
+}
+
+// dayPatterns are days from today; pm means a bare hour is in the evening
+var dayPatterns = []struct {
+	pattern *regexp.Regexp
+	days    int
+	pm      bool
+}{
+	{regexp.MustCompile(`(?i)\bday after tomorrow\b|มะรืน|明後日`), 2, false},
+	{regexp.MustCompile(`(?i)\btomorrow\b|\btmr\b|พรุ่งนี้|明日|あした`), 1, false},
+	{regexp.MustCompile(`(?i)\btonight\b|คืนนี้|今夜|今晩`), 0, true},
+	{regexp.MustCompile(`(?i)\btoday\b|วันนี้|今日`), 0, false},
+}
+
+// clockPatterns return hour & minute of a match; fixed is false when the
+// hour may be either morning or evening, e.g. "at 8"
+var clockPatterns = []struct {
+	pattern *regexp.Regexp
+	clock   func(m []string) (hour int, minute int, fixed bool)
+}{
+	{regexp.MustCompile(`(?i)\bnoon\b|\bmidday\b|เที่ยงวัน|正午`), func(m []string) (int, int, bool) { return 12, 0, true }},
+	{regexp.MustCompile(`(?i)\bmidnight\b|เที่ยงคืน`), func(m []string) (int, int, bool) { return 0, 0, true }},
+	// English, e.g. 7am & 8:30 p.m.
+	{regexp.MustCompile(`(?i)\b(\d{1,2})(?:[:.](\d{2}))?\s*([ap])\.?m\b\.?`), func(m []string) (int, int, bool) {
+		hour := atoi(m[1]) % 12
+		if strings.ToLower(m[3]) == "p" {
+			hour += 12
+		}
+		return hour, atoi(m[2]), true
+	}},
+	// Thai, e.g. ตี 5, บ่าย 2 โมง, 5 โมงเย็น, 2 ทุ่ม & 8 โมงเช้า
+	{regexp.MustCompile(`ตี\s*(\d{1,2})`), func(m []string) (int, int, bool) { return atoi(m[1]), 0, true }},
+	{regexp.MustCompile(`บ่าย\s*(\d{1,2})?\s*โมง`), func(m []string) (int, int, bool) { return 12 + maxInt(atoi(m[1]), 1), 0, true }},
+	{regexp.MustCompile(`(\d{1,2})\s*โมงเย็น`), func(m []string) (int, int, bool) { return atoi(m[1]) + 12, 0, true }},
+	{regexp.MustCompile(`(\d{1,2})\s*ทุ่ม`), func(m []string) (int, int, bool) { return atoi(m[1]) + 18, 0, true }},
+	{regexp.MustCompile(`(\d{1,2})\s*โมงเช้า`), func(m []string) (int, int, bool) { return atoi(m[1]), 0, true }},
+	{regexp.MustCompile(`เที่ยง`), func(m []string) (int, int, bool) { return 12, 0, true }},
+	// Japanese, e.g. 午前8時 & 午後3時
+	{regexp.MustCompile(`午前\s*(\d{1,2})\s*時`), func(m []string) (int, int, bool) { return atoi(m[1]) % 12, 0, true }},
+	{regexp.MustCompile(`午後\s*(\d{1,2})\s*時`), func(m []string) (int, int, bool) { return atoi(m[1])%12 + 12, 0, true }},
+	// 24-hour clock; 08:30 is in the morning but 8:30 may be either
+	{regexp.MustCompile(`\b(\d{1,2})[:.](\d{2})\b`), func(m []string) (int, int, bool) {
+		hour, _, fixed := bareHour(m)
+		return hour, atoi(m[2]), fixed || strings.HasPrefix(m[1], "0")
+	}},
+	// a bare hour in any language
+	{regexp.MustCompile(`(\d{1,2})\s*โมง`), bareHour},
+	{regexp.MustCompile(`(\d{1,2})\s*時`), bareHour},
+	{regexp.MustCompile(`(?i)\bat\s+(\d{1,2})\b`), bareHour},
+}
+
+// minutePattern is what may follow an hour, e.g. "8 โมงครึ่ง" or "8時30分"
+var minutePattern = regexp.MustCompile(`^\s*(?:(ครึ่ง|半)|(\d{1,2})\s*(?:分|นาที))`)
+
+func bareHour(m []string) (int, int, bool) {
+	hour := atoi(m[1])
+	return hour, 0, hour == 0 || hour > 12
+}
+
+// atoi is strconv.Atoi which takes a missing number as 0
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// findTime returns pickup time in text relative to now, with where it is
+// in text
+func findTime(text string, now time.Time) (time.Time, [][]int, bool) {
+	now = now.In(bangkok)
+
+	for _, rp := range relativePatterns {
+		m := rp.pattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		d := rp.unit
+		if len(m) > 2 && m[2] != -1 {
+			d = time.Duration(atoi(text[m[2]:m[3]])) * relativeUnits[strings.ToLower(text[m[4]:m[5]])]
+		}
+		return now.Add(d), [][]int{m[:2]}, true
+	}
+
+	var spans [][]int
+	hour, minute, fixed := -1, 0, false
+	for _, cp := range clockPatterns {
+		m := cp.pattern.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		groups := make([]string, len(m)/2)
+		for i := range groups {
+			if m[2*i] != -1 {
+				groups[i] = text[m[2*i]:m[2*i+1]]
+			}
+		}
+		hour, minute, fixed = cp.clock(groups)
+		end := m[1]
+		if mm := minutePattern.FindStringSubmatchIndex(text[end:]); mm != nil && minute == 0 {
+			minute = 30
+			if mm[4] != -1 {
+				minute = atoi(text[end+mm[4] : end+mm[5]])
+			}
+			end += mm[1]
+		}
+		spans = append(spans, []int{m[0], end})
+		break
+	}
+	if hour < 0 || hour > 23 || minute > 59 {
+		return time.Time{}, nil, false
+	}
+
+	days, pm, dayGiven := 0, false, false
+	for _, dp := range dayPatterns {
+		if m := dp.pattern.FindStringIndex(text); m != nil {
+			days, pm, dayGiven = dp.days, dp.pm, true
+			spans = append(spans, m)
+			break
+		}
+	}
+
+	date := now.AddDate(0, 0, days)
+	candidates := []time.Time{time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, bangkok)}
+	if !fixed && hour < 12 {
+		evening := candidates[0].Add(12 * time.Hour)
+		if pm {
+			candidates = []time.Time{evening}
+		} else {
+			candidates = append(candidates, evening)
+		}
+	}
+	if dayGiven {
+		// "tomorrow 7" is in the morning unless it's "tonight"
+		return candidates[0], sortSpans(spans), true
+	}
+	// the next one from now
+	var at time.Time
+	for _, c := range candidates {
+		if c.Before(now.Add(-time.Minute)) {
+			c = c.AddDate(0, 0, 1)
+		}
+		if at.IsZero() || c.Before(at) {
+			at = c
+		}
+	}
+	return at, sortSpans(spans), true
+}
+
+func sortSpans(spans [][]int) [][]int {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	return spans
+}
+
+// ParseTime reads pickup time in text relative to now, in Asia/Bangkok
+func ParseTime(text string, now time.Time) (time.Time, bool) {
+	at, _, ok := findTime(text, now)
+	return at, ok
+}
+
+// TimeConfirmationError is returned when pickup time is taken from words;
+// the rider has to confirm it before it's saved
+type TimeConfirmationError struct {
+	At time.Time
+}
+
+func (e *TimeConfirmationError) Error() string {
+	return fmt.Sprintf("Pick up at %s, right?", PickupTimeText(e.At))
+}
+
+// PickupTimeConfirmDialog echoes pickup time back to the rider; the button
+// posts action with the time, i.e. "when" for the when step or
+// "when-change" for a confirmed trip
+func (app *HailingApp) PickupTimeConfirmDialog(at time.Time, action string, localizer *i18n.Localizer) Message {
+	question := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "PickupTimeRight",
+			Other: "Pick up at {{.Time}}, right?",
+		},
+		TemplateData: map[string]string{
+			"Time": PickupTimeText(at),
+		},
+	})
+	yes := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Yes",
+			Other: "Yes",
+		},
+	})
+	return ConfirmCard{
+		AltText: question,
+		Text:    question,
+		Button: CardButton{
+			Label:  yes,
+			Action: ActionPostback,
+			Data:   fmt.Sprintf("%s:%d", action, at.Unix()),
+			Style:  ButtonPrimary,
+		},
+	}
+}
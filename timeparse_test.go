@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseTime(t *testing.T) {
+	// Monday 9 am
+	now := time.Date(2020, 6, 1, 9, 0, 0, 0, bangkok)
+	today := func(hour int, minute int) time.Time {
+		return time.Date(2020, 6, 1, hour, minute, 0, 0, bangkok)
+	}
+	tomorrow := func(hour int, minute int) time.Time {
+		return today(hour, minute).AddDate(0, 0, 1)
+	}
+	tests := []struct {
+		text   string
+		expect time.Time
+	}{
+		{"now", now},
+		{"+15min", today(9, 15)},
+		{"in 20 minutes", today(9, 20)},
+		{"in half an hour", today(9, 30)},
+		{"at 10", today(10, 0)},
+		{"at 8:30", today(20, 30)},
+		{"08:30", tomorrow(8, 30)},
+		{"7pm", today(19, 0)},
+		{"noon", today(12, 0)},
+		{"tomorrow 7am", tomorrow(7, 0)},
+		{"tomorrow at 7:15", tomorrow(7, 15)},
+		{"tonight at 8", today(20, 0)},
+		{"ตอนนี้", now},
+		{"ครึ่งชั่วโมง", today(9, 30)},
+		{"อีก 10 นาที", today(9, 10)},
+		{"อีกชั่วโมง", today(10, 0)},
+		{"บ่าย 2 โมง", today(14, 0)},
+		{"5 โมงเย็น", today(17, 0)},
+		{"2 ทุ่มครึ่ง", today(20, 30)},
+		{"ตี 5 พรุ่งนี้", tomorrow(5, 0)},
+		{"พรุ่งนี้ 8 โมงเช้า", tomorrow(8, 0)},
+		{"10.30 น.", today(10, 30)},
+		{"今", now},
+		{"30分後", today(9, 30)},
+		{"2時間後", today(11, 0)},
+		{"明日の午前8時", tomorrow(8, 0)},
+		{"午後3時半", today(15, 30)},
+		{"8時30分", today(20, 30)},
+	}
+	for _, tc := range tests {
+		got, ok := ParseTime(tc.text, now)
+		if !ok || !got.Equal(tc.expect) {
+			t.Errorf("%q: expect %v, got %v %v", tc.text, tc.expect, got, ok)
+		}
+	}
+	for _, text := range []string{"tomorrow", "citi resort", "2", "3 people", "25:00"} {
+		if got, ok := ParseTime(text, now); ok {
+			t.Errorf("%q isn't time: %v", text, got)
+		}
+	}
+}
+
+func TestPickupTimeConversation(t *testing.T) {
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+		{Name: "from", Text: "condo a", Expect: "When?"},
+	})
+	replies := fakeLineStep{Text: "in 20 minutes"}.Run(fl, app, rider)
+	when := confirmedTime(t, replies)
+	if rec, _ := app.FindRecord(rider); !rec.ReservedAt.IsZero() || rec.Waiting != StateWhen {
+		t.Fatalf("pickup time must wait for confirmation: %v", rec)
+	}
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "confirm", Postback: fmt.Sprintf("when:%d", when.Unix()), Expect: "How many passengers?"},
+	})
+	if rec, _ := app.FindRecord(rider); !rec.ReservedAt.Equal(when) {
+		t.Errorf("expect pickup at %v, got %v", when, rec.ReservedAt)
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
 type body struct {
 	Code      string     `json:"code"`
+	Message   string     `json:"message"`
 	Routes    []Route    `json:"routes"`
 	Waypoints []waypoint `json:"waypoints"`
 }
@@ -34,29 +38,123 @@ type waypoint struct {
 	Point    [2]float64 `json:"location"`
 }
 
-// GetTravelTime return travel time in minute
-func GetTravelTime(mode string, rec ReservationRecord) (*Route, error) {
-	// check if we have enough data
-	if rec.FromCoords == [2]float64{0, 0} || rec.ToCoords == [2]float64{0, 0} {
-		return nil, errors.New("Not enough data to get travel time")
+// TravelTime returns travel time in second, with traffic if we know it
+func (route *Route) TravelTime() float64 {
+	if route.DurationInTraffic > 0 {
+		return route.DurationInTraffic
+	}
+	return route.Duration
+}
+
+// RouteRequest asks for a route of Mode ("car", "walk", ...) between two
+// points, with traffic at Departure if it isn't zero
+type RouteRequest struct {
+	Mode      string
+	From      [2]float64
+	To        [2]float64
+	Departure time.Time
+}
+
+// RoutingProvider finds routes, e.g. OSRM or Google Directions
+type RoutingProvider interface {
+	Name() string
+	Route(ctx context.Context, req RouteRequest) (*Route, error)
+}
+
+// Routing errors, see RoutingError
+var (
+	ErrNoRoute = errors.New("no route between the points")
+	// ErrNotApplicable is when the provider doesn't serve the request,
+	// e.g. the mode, so the next one is asked
+	ErrNotApplicable = errors.New("not served by this provider")
+	ErrNotEnoughData = errors.New("Not enough data to get travel time")
+)
+
+// RoutingError is returned by RoutingProvider; Err is one of Err* above,
+// a timeout (context.DeadlineExceeded) or whatever went wrong on the way
+type RoutingError struct {
+	Provider string
+	// Status is what the provider said, e.g. "ZERO_RESULTS" or "503"
+	Status string
+	Err    error
+}
+
+func (e *RoutingError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("%s: %s: %v", e.Provider, e.Status, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Provider, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RoutingError) Unwrap() error {
+	return e.Err
+}
+
+// getJSON fetches rawURL within timeout and decodes it into v. Errors
+// leave out the query, which may have an API key, since they're logged.
+func getJSON(ctx context.Context, client *http.Client, timeout time.Duration, rawURL string, v interface{}) (int, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return 0, err
 	}
-	osrmBaseURL := os.Getenv("OSRM_BASE_URL")
-	if osrmBaseURL == "" {
-		osrmBaseURL = "https://nishi.10z.dev/route/v1"
+	if client == nil {
+		client = http.DefaultClient
 	}
-	od := fmt.Sprintf("%.8f,%.8f;%.8f,%.8f", rec.FromCoords[0], rec.FromCoords[1], rec.ToCoords[0], rec.ToCoords[1])
-	reqURL := fmt.Sprintf("%s/%s/%s", osrmBaseURL, mode, od)
-	log.Printf("[GetTravelTime] URL: %v", reqURL)
-	httpResp, err := http.Get(reqURL)
+	httpResp, err := client.Do(req)
 	if err != nil {
-		log.Fatal("Err: ", err)
-		return nil, err
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			bare := *req.URL
+			bare.RawQuery, bare.User = "", nil
+			return 0, &url.Error{Op: urlErr.Op, URL: bare.String(), Err: urlErr.Err}
+		}
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	if err := json.NewDecoder(httpResp.Body).Decode(v); err != nil {
+		return httpResp.StatusCode, fmt.Errorf("bad response (%s): %v", httpResp.Status, err)
 	}
-	byteValue, _ := ioutil.ReadAll(httpResp.Body)
-	// log.Printf("[GetTravelTime] result: %v", byteValue)
+	return httpResp.StatusCode, nil
+}
+
+// OSRMProvider asks our own OSRM server, BaseURL is like
+// https://nishi.10z.dev/route/v1 and modes are its profiles
+type OSRMProvider struct {
+	BaseURL string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Name is "osrm" which is also Source of its routes
+func (p *OSRMProvider) Name() string {
+	return "osrm"
+}
+
+// Route returns the first route OSRM suggests
+func (p *OSRMProvider) Route(ctx context.Context, req RouteRequest) (*Route, error) {
+	od := fmt.Sprintf("%.8f,%.8f;%.8f,%.8f", req.From[0], req.From[1], req.To[0], req.To[1])
+	reqURL := fmt.Sprintf("%s/%s/%s", strings.TrimRight(p.BaseURL, "/"), req.Mode, od)
+	log.Printf("[OSRM] URL: %v", reqURL)
 	var resp body
-	json.Unmarshal(byteValue, &resp)
-	return &resp.Routes[0], nil
+	status, err := getJSON(ctx, p.Client, p.Timeout, reqURL, &resp)
+	if err != nil {
+		return nil, &RoutingError{Provider: p.Name(), Err: err}
+	}
+	switch {
+	case resp.Code == "NoRoute" || (resp.Code == "Ok" && len(resp.Routes) == 0):
+		return nil, &RoutingError{Provider: p.Name(), Status: resp.Code, Err: ErrNoRoute}
+	case resp.Code != "Ok":
+		return nil, &RoutingError{Provider: p.Name(), Status: fmt.Sprintf("%d %s", status, resp.Code), Err: errors.New(resp.Message)}
+	}
+	route := resp.Routes[0]
+	route.Source = p.Name()
+	return &route, nil
 }
 
 type tV struct {
@@ -65,8 +163,8 @@ type tV struct {
 }
 
 type latlon struct {
-	Lat string `json:"lat"`
-	Lon string `json:"lng"`
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lng"`
 }
 
 type ggLeg struct {
@@ -84,52 +182,212 @@ type polylineDict struct {
 }
 
 type ggRouteResp struct {
-	Bounds           string       `json:"bounds"`
-	Legs             []ggLeg      `json:"legs"`
-	OverviewPolyline polylineDict `json:"overview_polyline"`
-	Summary          string       `json:"summary"`
+	Bounds           json.RawMessage `json:"bounds"`
+	Legs             []ggLeg         `json:"legs"`
+	OverviewPolyline polylineDict    `json:"overview_polyline"`
+	Summary          string          `json:"summary"`
 }
 
 type ggDirectionResp struct {
-	GeoCodedWaypoint string        `json:"geocoded_waypoints"`
-	Routes           []ggRouteResp `json:"routes"`
-	Status           string        `json:"status"`
+	GeoCodedWaypoints []json.RawMessage `json:"geocoded_waypoints"`
+	Routes            []ggRouteResp     `json:"routes"`
+	Status            string            `json:"status"`
+	ErrorMessage      string            `json:"error_message"`
 }
 
-// GetGoogleTravelTime returns travel time from Google which has traffic info
-func GetGoogleTravelTime(rec ReservationRecord) (*Route, error) {
-	if rec.ReservedAt.Format("2006-01-02") == "0001-01-01" {
-		// if there is no time, then we don't need to use Google API
-		return GetTravelTime("car", rec)
+// googleModes are Google Directions modes of ours
+var googleModes = map[string]string{
+	"car":     "driving",
+	"walk":    "walking",
+	"bicycle": "bicycling",
+	"transit": "transit",
+}
+
+// GoogleProvider asks Google Directions which knows traffic. It's paid
+// by request, so with TrafficOnly it only takes car routes with departure
+// time and leaves the rest to the next provider.
+type GoogleProvider struct {
+	APIKey string
+	// BaseURL is Google Directions API unless it's set
+	BaseURL     string
+	TrafficOnly bool
+	Client      *http.Client
+	Timeout     time.Duration
+}
+
+// Name is "google" which is also Source of its routes
+func (p *GoogleProvider) Name() string {
+	return "google"
+}
+
+// Route returns the first route of Google; car routes have traffic with
+// pessimistic model
+func (p *GoogleProvider) Route(ctx context.Context, req RouteRequest) (*Route, error) {
+	mode, ok := googleModes[req.Mode]
+	if !ok || (p.TrafficOnly && (mode != "driving" || req.Departure.IsZero())) {
+		return nil, &RoutingError{Provider: p.Name(), Err: ErrNotApplicable}
 	}
-	googleAPIKey := os.Getenv("GOOGLE_API_KEY")
-	if googleAPIKey == "" {
-		return nil, errors.New("GOOGLE_API_KEY env: missing")
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://maps.googleapis.com/maps/api/directions/json"
 	}
-
-	baseURL := "https://maps.googleapis.com/maps/api/directions/json?departure_time=%d&traffic_model=pessimistic&origin=%s&destination=%s&key=%s"
-	departureTime := rec.ReservedAt.Unix()
-	origin := fmt.Sprintf("%.8f,%.8f", rec.FromCoords[1], rec.FromCoords[0])
-	dest := fmt.Sprintf("%.8f,%.8f", rec.ToCoords[1], rec.ToCoords[0])
-	url := fmt.Sprintf(baseURL, departureTime, origin, dest, googleAPIKey)
-	// log.Printf("[GetGoogleTravelTime] URL=%s\n", url)
-	httpResp, err := http.Get(url)
+	params := url.Values{}
+	params.Set("origin", fmt.Sprintf("%.8f,%.8f", req.From[1], req.From[0]))
+	params.Set("destination", fmt.Sprintf("%.8f,%.8f", req.To[1], req.To[0]))
+	params.Set("mode", mode)
+	params.Set("key", p.APIKey)
+	if !req.Departure.IsZero() {
+		departure := req.Departure
+		if departure.Before(time.Now()) {
+			// Google doesn't take the past
+			departure = time.Now()
+		}
+		params.Set("departure_time", fmt.Sprintf("%d", departure.Unix()))
+		if mode == "driving" {
+			params.Set("traffic_model", "pessimistic")
+		}
+	}
+	var resp ggDirectionResp
+	status, err := getJSON(ctx, p.Client, p.Timeout, baseURL+"?"+params.Encode(), &resp)
 	if err != nil {
-		log.Fatal("Err: ", err)
-		return nil, err
-	}
-	ggResp := ggDirectionResp{}
-	byteValue, _ := ioutil.ReadAll(httpResp.Body)
-	json.Unmarshal(byteValue, &ggResp)
-	if ggResp.Status != "OK" {
-		errMsg := fmt.Sprintf("Google API: %v", ggResp.Status)
-		return nil, errors.New(errMsg)
-	}
-	result := Route{Source: "Google"}
-	leg := ggResp.Routes[0].Legs[0]
-	result.Distance = leg.Distance.Value
-	result.Duration = leg.Duration.Value
-	result.DurationInTraffic = leg.DurationInTraffic.Value
-	result.Geometry = ggResp.Routes[0].OverviewPolyline.Points
-	return &result, nil
+		return nil, &RoutingError{Provider: p.Name(), Err: err}
+	}
+	switch {
+	case resp.Status == "ZERO_RESULTS" || resp.Status == "NOT_FOUND" ||
+		(resp.Status == "OK" && (len(resp.Routes) == 0 || len(resp.Routes[0].Legs) == 0)):
+		return nil, &RoutingError{Provider: p.Name(), Status: resp.Status, Err: ErrNoRoute}
+	case resp.Status != "OK":
+		return nil, &RoutingError{Provider: p.Name(), Status: fmt.Sprintf("%d %s", status, resp.Status), Err: errors.New(resp.ErrorMessage)}
+	}
+	leg := resp.Routes[0].Legs[0]
+	return &Route{
+		Source:            p.Name(),
+		Distance:          leg.Distance.Value,
+		Duration:          leg.Duration.Value,
+		DurationInTraffic: leg.DurationInTraffic.Value,
+		Geometry:          resp.Routes[0].OverviewPolyline.Points,
+	}, nil
+}
+
+// RoutingChain asks each provider in turn until one has the route
+type RoutingChain []RoutingProvider
+
+// Name lists the providers, e.g. "google,osrm"
+func (chain RoutingChain) Name() string {
+	names := make([]string, len(chain))
+	for i, p := range chain {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Route returns the error of the last provider if none has the route
+func (chain RoutingChain) Route(ctx context.Context, req RouteRequest) (*Route, error) {
+	var err error = &RoutingError{Provider: chain.Name(), Err: ErrNotApplicable}
+	for _, p := range chain {
+		var route *Route
+		route, err = p.Route(ctx, req)
+		if err == nil {
+			return route, nil
+		}
+		if !errors.Is(err, ErrNotApplicable) {
+			log.Printf("[Routing] %s %s: %v", req.Mode, p.Name(), err)
+		}
+	}
+	return nil, err
+}
+
+// osrmFromEnv returns OSRMProvider of OSRM_BASE_URL
+func osrmFromEnv(timeout time.Duration) *OSRMProvider {
+	baseURL := os.Getenv("OSRM_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://nishi.10z.dev/route/v1"
+	}
+	return &OSRMProvider{BaseURL: baseURL, Timeout: timeout}
+}
+
+// NewRoutingProvider returns providers of ROUTING_PROVIDERS in order
+// (default "google,osrm"), each given ROUTING_TIMEOUT (default 5s), and
+// cached as ROUTE_CACHE says, see NewRouteCache. Google is left out
+// without GOOGLE_API_KEY; GOOGLE_DIRECTIONS_URL replaces its API URL.
+func NewRoutingProvider(sessions SessionStore) RoutingProvider {
+	names := os.Getenv("ROUTING_PROVIDERS")
+	if names == "" {
+		names = "google,osrm"
+	}
+	timeout := envDuration("ROUTING_TIMEOUT", 5*time.Second)
+	var chain RoutingChain
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "osrm":
+			chain = append(chain, osrmFromEnv(timeout))
+		case "google":
+			apiKey := os.Getenv("GOOGLE_API_KEY")
+			if apiKey == "" {
+				log.Printf("[Routing] GOOGLE_API_KEY env: missing, google is skipped")
+				continue
+			}
+			chain = append(chain, &GoogleProvider{
+				APIKey:      apiKey,
+				BaseURL:     os.Getenv("GOOGLE_DIRECTIONS_URL"),
+				TrafficOnly: true,
+				Timeout:     timeout,
+			})
+		default:
+			log.Printf("[Routing] unknown provider %q is skipped", name)
+		}
+	}
+	cache := NewRouteCache(sessions)
+	if cache == nil {
+		return chain
+	}
+	return &CachedRouting{
+		Provider: chain,
+		Cache:    cache,
+		Bucket:   envDuration("ROUTE_DEPARTURE_BUCKET", 15*time.Minute),
+	}
+}
+
+// Route returns route of mode between both ends of rec; car routes are
+// at pickup time if rec has one
+func (app *HailingApp) Route(mode string, rec ReservationRecord) (*Route, error) {
+	if rec.FromCoords == [2]float64{0, 0} || rec.ToCoords == [2]float64{0, 0} {
+		return nil, ErrNotEnoughData
+	}
+	req := RouteRequest{Mode: mode, From: rec.FromCoords, To: rec.ToCoords}
+	if mode == "car" {
+		req.Departure = rec.ReservedAt
+	}
+	return app.routing.Route(context.Background(), req)
+}
+
+// CarRoute returns driving route of rec, with traffic if a provider
+// knows it
+func (app *HailingApp) CarRoute(rec ReservationRecord) (*Route, error) {
+	return app.Route("car", rec)
+}
+
+// GetTravelTime returns route of mode from OSRM of OSRM_BASE_URL, uncached
+func GetTravelTime(mode string, rec ReservationRecord) (*Route, error) {
+	if rec.FromCoords == [2]float64{0, 0} || rec.ToCoords == [2]float64{0, 0} {
+		return nil, ErrNotEnoughData
+	}
+	return osrmFromEnv(envDuration("ROUTING_TIMEOUT", 5*time.Second)).Route(context.Background(),
+		RouteRequest{Mode: mode, From: rec.FromCoords, To: rec.ToCoords})
+}
+
+// GetGoogleTravelTime returns driving route at rec's pickup time from
+// Google with GOOGLE_API_KEY, uncached
+func GetGoogleTravelTime(rec ReservationRecord) (*Route, error) {
+	apiKey := os.Getenv("GOOGLE_API_KEY")
+	if apiKey == "" {
+		return nil, errors.New("GOOGLE_API_KEY env: missing")
+	}
+	google := &GoogleProvider{
+		APIKey:  apiKey,
+		BaseURL: os.Getenv("GOOGLE_DIRECTIONS_URL"),
+		Timeout: envDuration("ROUTING_TIMEOUT", 5*time.Second),
+	}
+	return google.Route(context.Background(),
+		RouteRequest{Mode: "car", From: rec.FromCoords, To: rec.ToCoords, Departure: rec.ReservedAt})
 }
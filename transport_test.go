@@ -1,26 +1,51 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
+// fakeRoutingEnv points OSRM_BASE_URL & Google Directions to a new
+// FakeRouting until the returned func is called
+func fakeRoutingEnv() (*FakeRouting, func()) {
+	fake := NewFakeRouting()
+	server := httptest.NewServer(fake)
+	os.Setenv("OSRM_BASE_URL", server.URL+"/route/v1")
+	os.Setenv("GOOGLE_DIRECTIONS_URL", server.URL+"/maps/api/directions/json")
+	os.Setenv("GOOGLE_API_KEY", "fake")
+	return fake, func() {
+		server.Close()
+		os.Unsetenv("OSRM_BASE_URL")
+		os.Unsetenv("GOOGLE_DIRECTIONS_URL")
+		os.Unsetenv("GOOGLE_API_KEY")
+	}
+}
+
 func TestTravelTimeService(t *testing.T) {
+	_, done := fakeRoutingEnv()
+	defer done()
 	rec := ReservationRecord{
 		FromCoords: [2]float64{100.5685933, 13.7319484},
 		ToCoords:   [2]float64{100.5695537, 13.7430816},
 	}
 	walkRoute, err := GetTravelTime("walk", rec)
 	if err != nil {
-		t.Errorf("walk error: %v", err)
+		t.Fatalf("walk error: %v", err)
 	}
 	log.Printf("Walk Time: %4.0f m /walkRoute%4.0f s", walkRoute.Distance, walkRoute.Duration)
 	log.Printf(" > Polyline: %s\n", walkRoute.Geometry)
 
 	carRoute, err := GetTravelTime("car", rec)
 	if err != nil {
-		t.Errorf("walk error: %v", err)
+		t.Fatalf("walk error: %v", err)
 	}
 	log.Printf(" Car Time: %4.0f m / %4.0f s", carRoute.Distance, carRoute.Duration)
 	log.Printf(" > Polyline: %s\n", carRoute.Geometry)
@@ -31,6 +56,8 @@ func TestTravelTimeService(t *testing.T) {
 }
 
 func TestGoogleTravelTimeService(t *testing.T) {
+	_, done := fakeRoutingEnv()
+	defer done()
 	rec := ReservationRecord{
 		ReservedAt: time.Now(),
 		FromCoords: [2]float64{100.5685933, 13.7319484},
@@ -38,7 +65,7 @@ func TestGoogleTravelTimeService(t *testing.T) {
 	}
 	route, err := GetGoogleTravelTime(rec)
 	if err != nil {
-		t.Errorf("GetGoogleTravelTime error: %v", err)
+		t.Fatalf("GetGoogleTravelTime error: %v", err)
 	}
 	log.Printf("Google (car as default)\n")
 	log.Printf("  Distance: %6.0f m\n", route.Distance)
@@ -46,4 +73,79 @@ func TestGoogleTravelTimeService(t *testing.T) {
 	log.Printf("  Duration: %6.0f s in traffic\n", route.DurationInTraffic)
 	log.Printf("  Polyline: %s\n", route.Geometry)
 
+	if route.DurationInTraffic <= route.Duration {
+		t.Errorf("expect traffic: %v", route)
+	}
+}
+
+func TestRoutingProviders(t *testing.T) {
+	fake, done := fakeRoutingEnv()
+	defer done()
+	// generous, -race and a busy machine mustn't time these out
+	osrm := &OSRMProvider{BaseURL: os.Getenv("OSRM_BASE_URL"), Timeout: 10 * time.Second}
+	google := &GoogleProvider{APIKey: "fake", BaseURL: os.Getenv("GOOGLE_DIRECTIONS_URL"), TrafficOnly: true, Timeout: 10 * time.Second}
+	req := RouteRequest{
+		Mode:      "car",
+		From:      [2]float64{100.5623, 13.7349},
+		To:        [2]float64{100.5749, 13.7355},
+		Departure: time.Now().Add(time.Hour),
+	}
+
+	route, err := google.Route(context.Background(), req)
+	if err != nil || route.Source != "google" || route.TravelTime() <= route.Duration {
+		t.Errorf("unexpected google route: %v %v", route, err)
+	}
+	if points, _ := decodePolyline(route.Geometry); len(points) != 2 || points[0] != req.From {
+		t.Errorf("expect straight route from %v, got %v", req.From, points)
+	}
+	walk := req
+	walk.Mode = "walk"
+	if _, err := google.Route(context.Background(), walk); !errors.Is(err, ErrNotApplicable) {
+		t.Errorf("google is only for traffic: %v", err)
+	}
+
+	chain := RoutingChain{google, osrm}
+	fake.Inject("google", Fault{HTTPStatus: http.StatusServiceUnavailable})
+	if route, err := chain.Route(context.Background(), req); err != nil || route.Source != "osrm" {
+		t.Errorf("expect osrm when google fails, got %v %v", route, err)
+	}
+
+	fake.Inject("google", Fault{NoRoute: true})
+	fake.Inject("osrm", Fault{NoRoute: true})
+	_, err = chain.Route(context.Background(), req)
+	var routingErr *RoutingError
+	if !errors.Is(err, ErrNoRoute) || !errors.As(err, &routingErr) || routingErr.Provider != "osrm" {
+		t.Errorf("expect no route from osrm, got %v", err)
+	}
+	if _, err := google.Route(context.Background(), req); !errors.As(err, &routingErr) || routingErr.Status != "ZERO_RESULTS" {
+		t.Errorf("expect ZERO_RESULTS from google, got %v", err)
+	}
+
+	// the fake answers long after the timeout, or when the request is given up
+	slowOSRM, slowGoogle := *osrm, *google
+	slowOSRM.Timeout, slowGoogle.Timeout = 50*time.Millisecond, 50*time.Millisecond
+	fake.Inject("osrm", Fault{Latency: time.Hour})
+	if _, err := slowOSRM.Route(context.Background(), req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect timeout, got %v", err)
+	}
+	// timeouts are logged, so the API key mustn't be in them
+	fake.Inject("google", Fault{Latency: time.Hour})
+	if _, err := slowGoogle.Route(context.Background(), req); !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "key=") {
+		t.Errorf("expect timeout without the key, got %v", err)
+	}
+	fake.Inject("google", Fault{})
+	fake.Inject("osrm", Fault{})
+	if _, err := osrm.Route(context.Background(), req); err != nil {
+		t.Errorf("fault must be cleared: %v", err)
+	}
+
+	// the whole response of Google, recorded
+	recorded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"geocoded_waypoints":[{"geocoder_status":"OK","place_id":"ChIJ","types":["premise"]}],"routes":[{"bounds":{"northeast":{"lat":13.7355,"lng":100.5749},"southwest":{"lat":13.7349,"lng":100.5623}},"legs":[{"distance":{"text":"1.6 km","value":1603},"duration":{"text":"6 mins","value":372},"duration_in_traffic":{"text":"9 mins","value":540},"start_location":{"lat":13.7349,"lng":100.5623},"end_location":{"lat":13.7355,"lng":100.5749}}],"overview_polyline":{"points":"def"},"summary":"Sukhumvit Rd"}],"status":"OK"}`)
+	}))
+	defer recorded.Close()
+	google.BaseURL = recorded.URL
+	if route, err := google.Route(context.Background(), req); err != nil || route.Distance != 1603 || route.TravelTime() != 540 {
+		t.Errorf("unexpected recorded route: %v %v", route, err)
+	}
 }
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// TravelOption is one way to make the trip, shown side by side with the
+// others so the rider can compare
+type TravelOption struct {
+	Mode     string  `json:"mode"`
+	Distance float64 `json:"distance"` // m
+	Duration float64 `json:"duration"` // second, in traffic by car
+	// Cost is in Currency, negative if we don't know it
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency"`
+	CO2      float64 `json:"co2"` // g
+}
+
+// TravelPlanner works out the option of one mode for a reservation
+type TravelPlanner interface {
+	Mode() string
+	Plan(ctx context.Context, rec *ReservationRecord) (*TravelOption, error)
+}
+
+// co2PerKm (g) of each mode, and of OTP leg modes in lower case; a car is
+// the whole vehicle, transit is per rider
+var co2PerKm = map[string]float64{
+	"walk":    0,
+	"bicycle": 0,
+	"car":     170,
+	"transit": 70,
+	"bus":     100,
+	"rail":    35,
+	"subway":  35,
+	"tram":    35,
+	"ferry":   120,
+}
+
+// RoutePlanner is the option of a mode routing providers know, e.g. walk
+type RoutePlanner struct {
+	TravelMode string
+	// Route is like HailingApp.Route
+	Route func(mode string, rec ReservationRecord) (*Route, error)
+	// Fare prices the route, nil if the mode is free
+	Fare func(route *Route, rec *ReservationRecord) FareEstimate
+}
+
+// Mode is TravelMode
+func (p *RoutePlanner) Mode() string {
+	return p.TravelMode
+}
+
+// Plan returns the route of the mode with its cost & emission
+func (p *RoutePlanner) Plan(ctx context.Context, rec *ReservationRecord) (*TravelOption, error) {
+	route, err := p.Route(p.TravelMode, *rec)
+	if err != nil {
+		return nil, err
+	}
+	option := &TravelOption{
+		Mode:     p.TravelMode,
+		Distance: route.Distance,
+		Duration: route.TravelTime(),
+		Currency: CurrentFares().Currency,
+		CO2:      co2PerKm[p.TravelMode] * route.Distance / 1000,
+	}
+	if p.Fare != nil {
+		fare := p.Fare(route, rec)
+		option.Cost, option.Currency = fare.Amount, fare.Currency
+	}
+	return option, nil
+}
+
+// OTPPlanner is the transit option from an OpenTripPlanner compatible
+// planner; BaseURL is the router, e.g. http://localhost:8080/otp/routers/default
+type OTPPlanner struct {
+	BaseURL string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+type otpResponse struct {
+	Plan *struct {
+		Itineraries []otpItinerary `json:"itineraries"`
+	} `json:"plan"`
+	Error *struct {
+		ID      int    `json:"id"`
+		Msg     string `json:"msg"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type otpItinerary struct {
+	Duration float64 `json:"duration"`
+	Fare     *struct {
+		Fare map[string]otpMoney `json:"fare"`
+	} `json:"fare"`
+	Legs []struct {
+		Mode        string  `json:"mode"`
+		Distance    float64 `json:"distance"`
+		LegGeometry struct {
+			Points string `json:"points"`
+		} `json:"legGeometry"`
+	} `json:"legs"`
+}
+
+type otpMoney struct {
+	Cents    int `json:"cents"`
+	Currency struct {
+		CurrencyCode string `json:"currencyCode"`
+	} `json:"currency"`
+}
+
+// Mode is "transit"
+func (p *OTPPlanner) Mode() string {
+	return "transit"
+}
+
+// Plan returns the first itinerary leaving at pickup time, or now. One
+// without any transit leg is no transit option.
+func (p *OTPPlanner) Plan(ctx context.Context, rec *ReservationRecord) (*TravelOption, error) {
+	if rec.FromCoords == [2]float64{0, 0} || rec.ToCoords == [2]float64{0, 0} {
+		return nil, ErrNotEnoughData
+	}
+	departure := rec.ReservedAt
+	if departure.IsZero() {
+		departure = time.Now()
+	}
+	departure = departure.In(bangkok)
+	q := url.Values{}
+	q.Set("fromPlace", fmt.Sprintf("%.8f,%.8f", rec.FromCoords[1], rec.FromCoords[0]))
+	q.Set("toPlace", fmt.Sprintf("%.8f,%.8f", rec.ToCoords[1], rec.ToCoords[0]))
+	q.Set("mode", "TRANSIT,WALK")
+	q.Set("date", departure.Format("2006-01-02"))
+	q.Set("time", departure.Format("15:04"))
+	q.Set("numItineraries", "1")
+	reqURL := strings.TrimRight(p.BaseURL, "/") + "/plan?" + q.Encode()
+	log.Printf("[OTP] URL: %v", reqURL)
+
+	var resp otpResponse
+	status, err := getJSON(ctx, p.Client, p.Timeout, reqURL, &resp)
+	if err != nil {
+		return nil, &RoutingError{Provider: "otp", Err: err}
+	}
+	if resp.Error != nil {
+		return nil, &RoutingError{Provider: "otp", Status: resp.Error.Message, Err: ErrNoRoute}
+	}
+	if status != http.StatusOK || resp.Plan == nil || len(resp.Plan.Itineraries) == 0 {
+		return nil, &RoutingError{Provider: "otp", Status: fmt.Sprint(status), Err: ErrNoRoute}
+	}
+
+	itinerary := resp.Plan.Itineraries[0]
+	option := &TravelOption{Mode: p.Mode(), Duration: itinerary.Duration, Cost: -1}
+	transit := false
+	for _, leg := range itinerary.Legs {
+		mode := strings.ToLower(leg.Mode)
+		perKm, ok := co2PerKm[mode]
+		if !ok {
+			perKm = co2PerKm["transit"]
+		}
+		transit = transit || (mode != "walk" && mode != "bicycle")
+		option.Distance += leg.Distance
+		option.CO2 += perKm * leg.Distance / 1000
+	}
+	if !transit {
+		return nil, &RoutingError{Provider: "otp", Status: "WALK_ONLY", Err: ErrNoRoute}
+	}
+	if itinerary.Fare != nil {
+		if regular, ok := itinerary.Fare.Fare["regular"]; ok {
+			option.Cost = float64(regular.Cents) / 100
+			option.Currency = regular.Currency.CurrencyCode
+		}
+	}
+	return option, nil
+}
+
+// NewTravelPlanners returns planners of TRAVEL_OPTIONS (default
+// walk,car,bicycle,transit) in that order. Transit needs OTP_BASE_URL.
+func NewTravelPlanners(app *HailingApp) []TravelPlanner {
+	modes := os.Getenv("TRAVEL_OPTIONS")
+	if modes == "" {
+		modes = "walk,car,bicycle,transit"
+	}
+	planners := []TravelPlanner{}
+	for _, mode := range strings.Split(modes, ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case "walk", "bicycle":
+			planners = append(planners, &RoutePlanner{TravelMode: mode, Route: app.Route})
+		case "car":
+			planners = append(planners, &RoutePlanner{TravelMode: mode, Route: app.Route, Fare: app.EstimateFare})
+		case "transit":
+			baseURL := os.Getenv("OTP_BASE_URL")
+			if baseURL == "" {
+				log.Printf("[TravelOptions] no OTP_BASE_URL, transit is skipped")
+				continue
+			}
+			planners = append(planners, &OTPPlanner{
+				BaseURL: baseURL,
+				Timeout: envDuration("ROUTING_TIMEOUT", 5*time.Second),
+			})
+		default:
+			log.Printf("[TravelOptions] unknown option %q is skipped", mode)
+		}
+	}
+	return planners
+}
+
+// TravelOptions plans every option of rec at once; the ones which fail
+// are left out
+func (app *HailingApp) TravelOptions(rec *ReservationRecord) []TravelOption {
+	options := make([]*TravelOption, len(app.travelPlanners))
+	var wg sync.WaitGroup
+	for i, planner := range app.travelPlanners {
+		wg.Add(1)
+		go func(i int, planner TravelPlanner) {
+			defer wg.Done()
+			option, err := planner.Plan(context.Background(), rec)
+			if err != nil {
+				log.Printf("[TravelOptions] %s: %v", planner.Mode(), err)
+				return
+			}
+			options[i] = option
+		}(i, planner)
+	}
+	wg.Wait()
+	results := []TravelOption{}
+	for _, option := range options {
+		if option != nil {
+			results = append(results, *option)
+		}
+	}
+	return results
+}
+
+// PlanTravel returns the option of mode for rec
+func (app *HailingApp) PlanTravel(rec *ReservationRecord, mode string) (*TravelOption, error) {
+	for _, planner := range app.travelPlanners {
+		if planner.Mode() == mode {
+			return planner.Plan(context.Background(), rec)
+		}
+	}
+	return nil, ErrNotApplicable
+}
+
+// TravelChoice is the option a rider took on the options carousel
+type TravelChoice struct {
+	UserID uuid.UUID
+	// TripID is the trip if the rider took a ride, 0 otherwise
+	TripID   int
+	From     [2]float64
+	To       [2]float64
+	Option   TravelOption
+	ChosenAt time.Time
+}
+
+// CO2Text is like "350 g" or "1.2 kg"
+func CO2Text(grams float64) string {
+	if grams < 1000 {
+		return fmt.Sprintf("%.0f g", grams)
+	}
+	return fmt.Sprintf("%.1f kg", grams/1000)
+}
+
+// TravelOptionsCarousel compares options of the reservation, one card
+// each; the rider takes one with its button. It's nil without options.
+func (app *HailingApp) TravelOptionsCarousel(record *ReservationRecord, localizer *i18n.Localizer) Message {
+	options := app.TravelOptions(record)
+	if len(options) == 0 {
+		return nil
+	}
+	costLabel := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "TravelCost",
+			Other: "Cost",
+		},
+	})
+	co2Label := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "TravelCO2",
+			Other: "CO2",
+		},
+	})
+	free := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "Free",
+			Other: "Free",
+		},
+	})
+	cards := []RichCard{}
+	for _, option := range options {
+		title, button := travelModeTexts(option.Mode, localizer)
+		icon := option.Mode
+		if icon == "car" {
+			icon = "taxi"
+		}
+		travelLength := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "TravelMeter",
+				Other: "{{.Meter}} m",
+			},
+			TemplateData: map[string]string{
+				"Meter": fmt.Sprintf("%.0f", option.Distance),
+			},
+		})
+		travelMin := localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{
+				ID:    "TravelMinute",
+				Other: "{{.Min}} min",
+			},
+			TemplateData: map[string]string{
+				"Min": fmt.Sprintf("%.0f", option.Duration/60),
+			},
+		})
+		cost := "-"
+		switch {
+		case option.Cost == 0:
+			cost = free
+		case option.Cost > 0:
+			cost = FareText(option.Cost, option.Currency)
+		}
+		cards = append(cards, RichCard{
+			Title: title,
+			Body: []CardBlock{
+				app.travelOption(icon, travelLength, travelMin),
+				FieldBlock(costLabel, cost),
+				FieldBlock(co2Label, CO2Text(option.CO2)),
+			},
+			Buttons: []CardButton{PostbackButton(button, "travel:"+option.Mode)},
+		})
+	}
+	return Carousel{AltText: "TravelOptions", Cards: cards}
+}
+
+// travelModeTexts returns the name of mode and the label of its button
+func travelModeTexts(mode string, localizer *i18n.Localizer) (string, string) {
+	message := func(id string, other string) string {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{ID: id, Other: other},
+		})
+	}
+	switch mode {
+	case "walk":
+		return message("TravelModeWalk", "Walk"), message("WalkInstead", "I'll walk instead")
+	case "car":
+		return message("TravelModeCar", "Taxi"), message("RideWithUs", "Ride with us")
+	case "bicycle":
+		return message("TravelModeBicycle", "Bicycle"), message("CycleInstead", "I'll cycle instead")
+	case "transit":
+		return message("TravelModeTransit", "Public transit"), message("TransitInstead", "I'll take transit instead")
+	}
+	return mode, message("Choose", "Choose")
+}
+
+// TravelOptionHandler logs the option the rider took. The taxi goes on to
+// confirmation, any other option ends the reservation.
+func (app *HailingApp) TravelOptionHandler(replyToken string, lineUserID string, mode string) error {
+	rec, err := app.FindRecord(lineUserID)
+	if err != nil || rec.Waiting != StateFinal {
+		return app.UnhandledCase(replyToken)
+	}
+	option, err := app.PlanTravel(rec, mode)
+	if err != nil {
+		log.Printf("[TravelOption] %s: %v", mode, err)
+		option = &TravelOption{Mode: mode, Cost: -1}
+	}
+	choice := &TravelChoice{
+		UserID:   rec.UserID,
+		From:     rec.FromCoords,
+		To:       rec.ToCoords,
+		Option:   *option,
+		ChosenAt: time.Now(),
+	}
+	log.Printf("[TravelOption] %s took %+v", lineUserID, *option)
+	if mode == "car" {
+		if err := app.handleNextStep(replyToken, lineUserID, Reply{Text: "last-step-confirmation"}); err != nil {
+			return err
+		}
+		if active, err := app.trips.FindActiveReservation(lineUserID); err == nil {
+			choice.TripID = active.TripID
+		}
+	}
+	if err := app.trips.SaveTravelChoice(choice); err != nil {
+		log.Printf("[TravelOption] save: %v", err)
+	}
+	if mode == "car" {
+		return nil
+	}
+	if _, err := app.Cancel(lineUserID); err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	_, localizer, err := app.Localizer(lineUserID)
+	if err != nil {
+		return app.replyText(replyToken, err.Error())
+	}
+	goodTrip := localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "HaveAGoodTrip",
+			Other: "Have a good trip! Call the cab whenever you need a ride.",
+		},
+	})
+	return app.replyText(replyToken, goodTrip)
+}
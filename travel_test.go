@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestOTPPlanner(t *testing.T) {
+	// trimmed response of OpenTripPlanner 1.x
+	itinerary := `{"plan":{"itineraries":[{"duration":1260,
+		"fare":{"fare":{"regular":{"cents":2800,"currency":{"currencyCode":"THB"}}}},
+		"legs":[
+			{"mode":"WALK","distance":350.5,"legGeometry":{"points":""}},
+			{"mode":"SUBWAY","distance":4000,"legGeometry":{"points":""}},
+			{"mode":"WALK","distance":200,"legGeometry":{"points":""}}
+		]}]}}`
+	tests := []struct {
+		name     string
+		response string
+		expect   TravelOption
+		err      error
+	}{
+		{"itinerary", itinerary, TravelOption{Mode: "transit", Distance: 4550.5, Duration: 1260, Cost: 28, Currency: "THB", CO2: 140}, nil},
+		{"no-fare", strings.Replace(itinerary, `"fare"`, `"nofare"`, 1), TravelOption{Mode: "transit", Distance: 4550.5, Duration: 1260, Cost: -1, CO2: 140}, nil},
+		{"walk-only", `{"plan":{"itineraries":[{"duration":600,"legs":[{"mode":"WALK","distance":800}]}]}}`, TravelOption{}, ErrNoRoute},
+		{"no-path", `{"error":{"id":404,"msg":"No trip found.","message":"PATH_NOT_FOUND","noPath":true}}`, TravelOption{}, ErrNoRoute},
+	}
+	rec := &ReservationRecord{FromCoords: placeCoords("condo a"), ToCoords: placeCoords("citi resort"), ReservedAt: time.Now()}
+	for _, tc := range tests {
+		var query string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query().Get("fromPlace") + ";" + r.URL.Query().Get("mode")
+			fmt.Fprint(w, tc.response)
+		}))
+		planner := &OTPPlanner{BaseURL: server.URL + "/otp/routers/default", Timeout: time.Second}
+		option, err := planner.Plan(context.Background(), rec)
+		server.Close()
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s: expect %v, got %v %v", tc.name, tc.err, option, err)
+			}
+			continue
+		}
+		if err != nil || *option != tc.expect {
+			t.Errorf("%s: expect %+v, got %+v %v", tc.name, tc.expect, option, err)
+		}
+		if query != "13.73490000,100.56230000;TRANSIT,WALK" {
+			t.Errorf("%s: unexpected query %q", tc.name, query)
+		}
+	}
+}
+
+func TestTravelOptions(t *testing.T) {
+	fake := NewFakeRouting()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	os.Setenv("OSRM_BASE_URL", server.URL+"/route/v1")
+	os.Setenv("OTP_BASE_URL", server.URL+"/otp/routers/default")
+	defer os.Unsetenv("OSRM_BASE_URL")
+	defer os.Unsetenv("OTP_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+
+	book := []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+		{Name: "from", Text: "condo a", Expect: "When?"},
+		{Name: "when", Text: "now", Expect: "How many passengers?"},
+	}
+	walker := "U" + uuid.New().String()
+	runConversation(t, fl, app, walker, book)
+	replies := fakeLineStep{Text: "1"}.Run(fl, app, walker)
+	var carousel *fakeLineMessage
+	for i := range replies {
+		if replies[i].AltText == "TravelOptions" {
+			carousel = &replies[i]
+		}
+	}
+	if carousel == nil {
+		t.Fatalf("expect travel options, got %v", replies)
+	}
+	for _, expect := range []string{"Walk", "Taxi", "Bicycle", "Public transit", "Free", "16 THB", "travel:transit"} {
+		if !strings.Contains(string(carousel.Contents), expect) {
+			t.Errorf("expect %q in travel options", expect)
+		}
+	}
+
+	runConversation(t, fl, app, walker, []fakeLineStep{
+		{Name: "walk", Postback: "travel:walk", Expect: "Have a good trip!"},
+		{Name: "walk-again", Postback: "travel:walk", Expect: "do you need a ride?"},
+	})
+	user, _ := fl.Store.FindUserByLineID(walker)
+	choices := fl.Store.TravelChoices(user.ID)
+	if len(choices) != 1 || choices[0].Option.Mode != "walk" || choices[0].Option.Distance == 0 || choices[0].TripID != 0 {
+		t.Fatalf("expect the walk to be logged, got %+v", choices)
+	}
+	if _, err := app.FindRecord(walker); err == nil {
+		t.Error("reservation must end after walking instead")
+	}
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, append(book,
+		fakeLineStep{Name: "passengers", Text: "1", Expect: "TravelOptions"},
+		fakeLineStep{Name: "taxi", Postback: "travel:car", Expect: "Your ride reservation is done."},
+	))
+	user, _ = fl.Store.FindUserByLineID(rider)
+	choices = fl.Store.TravelChoices(user.ID)
+	active, err := fl.Store.FindActiveReservation(rider)
+	if err != nil || len(choices) != 1 || choices[0].Option.Mode != "car" || choices[0].TripID != active.TripID {
+		t.Fatalf("expect the taxi to be logged with its trip, got %+v %v", choices, err)
+	}
+	if choices[0].Option.Cost != active.Fare {
+		t.Errorf("expect the fare %v as the cost, got %v", active.Fare, choices[0].Option.Cost)
+	}
+
+	// without a planner the option is left out
+	fake.Inject("otp", Fault{HTTPStatus: http.StatusServiceUnavailable})
+	if options := app.TravelOptions(&ReservationRecord{FromCoords: placeCoords("condo a"), ToCoords: placeCoords("citi resort")}); len(options) != 3 {
+		t.Errorf("expect 3 options without transit, got %+v", options)
+	}
+}
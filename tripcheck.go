@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Reasons of TripError
+const (
+	TripTooClose = "too_close"
+	TripTooLong  = "too_long"
+	RouteOutside = "route_outside"
+)
+
+// TripError is returned when the trip as a whole can't be served even
+// though each answer is fine on its own
+type TripError struct {
+	Reason string
+	// Meters is what was measured against Limit, e.g. route length
+	Meters float64
+	Limit  float64
+}
+
+func (e *TripError) Error() string {
+	switch e.Reason {
+	case TripTooClose:
+		return fmt.Sprintf("Pickup is only %s from destination", DistanceText(e.Meters))
+	case TripTooLong:
+		return fmt.Sprintf("The route is %s, longer than %s", DistanceText(e.Meters), DistanceText(e.Limit))
+	}
+	return fmt.Sprintf("The route goes %s outside service area", DistanceText(e.Meters))
+}
+
+// pointDistance is the distance (m) between a & b, see segmentDistance
+func pointDistance(a [2]float64, b [2]float64) float64 {
+	return segmentDistance(a, b, b)
+}
+
+// ValidateTrip checks the reservation before it's confirmed: both ends
+// inside the service area, pickup not right at the destination, and the
+// car route neither too long nor outside the area. It returns the state
+// whose answer is rejected. The route & its fare are kept on rec; when
+// there is no route to check, the trip is let through.
+func (app *HailingApp) ValidateTrip(rec *ReservationRecord) (ReservationState, error) {
+	area := CurrentServiceArea()
+	if _, err := area.Locate(rec.ToCoords); err != nil {
+		return StateTo, err
+	}
+	if _, err := area.Locate(rec.FromCoords); err != nil {
+		return StateFrom, err
+	}
+	if d := pointDistance(rec.FromCoords, rec.ToCoords); d < app.minTripDistance {
+		return StateFrom, &TripError{Reason: TripTooClose, Meters: d, Limit: app.minTripDistance}
+	}
+
+	route, err := app.CarRoute(*rec)
+	if err != nil {
+		log.Printf("[ValidateTrip] route: %v", err)
+		return "", nil
+	}
+	if route.Distance > app.maxRouteLength {
+		return StateTo, &TripError{Reason: TripTooLong, Meters: route.Distance, Limit: app.maxRouteLength}
+	}
+	points, err := decodePolyline(route.Geometry)
+	if err != nil {
+		log.Printf("[ValidateTrip] geometry %q: %v", route.Geometry, err)
+	}
+	for _, p := range points {
+		if _, d := area.Nearest(p); d > app.routeZoneTolerance {
+			return StateTo, &TripError{Reason: RouteOutside, Meters: d, Limit: app.routeZoneTolerance}
+		}
+	}
+	app.applyRoute(rec, route)
+	return "", nil
+}
+
+// checkTrip validates rec on its way to confirmation; the rejected answer
+// is cleared and asked again
+func (app *HailingApp) checkTrip(rec *ReservationRecord) error {
+	state, err := app.ValidateTrip(rec)
+	if err == nil {
+		return nil
+	}
+	log.Printf("[checkTrip] %s: %v", state, err)
+	if rerr := reservationFSM.Rewind(rec, state); rerr != nil {
+		log.Printf("[checkTrip] rewind: %v", rerr)
+	}
+	switch state {
+	case StateTo:
+		rec.To, rec.ToCoords = "", [2]float64{}
+	case StateFrom:
+		rec.From, rec.FromCoords = "", [2]float64{}
+	}
+	return &ValidationError{State: state, Err: err}
+}
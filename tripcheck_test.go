@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPolyline(t *testing.T) {
+	// the example of Google's polyline algorithm
+	encoded := "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+	expect := [][2]float64{{-120.2, 38.5}, {-120.95, 40.7}, {-126.453, 43.252}}
+	points, err := decodePolyline(encoded)
+	if err != nil || len(points) != len(expect) {
+		t.Fatalf("expect %v, got %v %v", expect, points, err)
+	}
+	for i, p := range points {
+		if math.Abs(p[0]-expect[i][0]) > 1e-6 || math.Abs(p[1]-expect[i][1]) > 1e-6 {
+			t.Errorf("point %d: expect %v, got %v", i, expect[i], p)
+		}
+	}
+	if got := encodePolyline(expect); got != encoded {
+		t.Errorf("expect %q, got %q", encoded, got)
+	}
+	if _, err := decodePolyline("_p~iF~ps|"); err == nil {
+		t.Error("truncated polyline must fail")
+	}
+}
+
+func TestTripValidation(t *testing.T) {
+	osrm := stubOSRM()
+	defer osrm.Close()
+	os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+	defer os.Unsetenv("OSRM_BASE_URL")
+
+	fl := newFakeLine(t)
+	defer fl.Close()
+	app := fl.NewApp()
+	fl.Store.AddLocation(MemoryLocation{Location: Location{
+		ID:    99,
+		Name:  "siam paragon",
+		Place: Coords{Coordinates: [2]float64{100.5347, 13.7462}, Type: "Point"},
+	}})
+
+	rider := "U" + uuid.New().String()
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "init", Text: "call the cab", Expect: "Where to?"},
+		{Name: "outside", Postback: "location:siam paragon:99", Expect: "outside our service area"},
+		{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+		{Name: "from", Text: "citi resort", Expect: "When?"},
+		{Name: "when", Text: "now", Expect: "How many passengers?"},
+		{Name: "too-close", Text: "2", Expect: "Pickup is only 0 m from your destination"},
+	})
+	if rec, _ := app.FindRecord(rider); rec.Waiting != StateFrom || rec.From != "" || rec.To != "citi resort" {
+		t.Fatalf("pickup must be asked again: %v", rec)
+	}
+	runConversation(t, fl, app, rider, []fakeLineStep{
+		{Name: "from-again", Text: "condo a", Expect: "EstTravelTime"},
+	})
+
+	condo, citi := placeCoords("condo a"), placeCoords("citi resort")
+	tests := []struct {
+		name   string
+		route  Route
+		expect string
+	}{
+		{"too-long", Route{Distance: 25000, Geometry: encodePolyline([][2]float64{condo, citi})}, "we only go up to 20.0 km"},
+		{"detour", Route{Distance: 1200, Geometry: encodePolyline([][2]float64{condo, {100.60, 13.73}, citi})}, "the way there leaves our service area"},
+	}
+	for _, tc := range tests {
+		fake := NewFakeRouting()
+		fake.AddFixture(RouteFixture{Mode: "car", From: condo, To: citi, Route: tc.route})
+		osrm := httptest.NewServer(fake)
+		os.Setenv("OSRM_BASE_URL", osrm.URL+"/route/v1")
+		app.routing = NewRoutingProvider(app.sessions)
+		rider := "U" + uuid.New().String()
+		runConversation(t, fl, app, rider, []fakeLineStep{
+			{Name: "init", Text: "call the cab", Expect: "Where to?"},
+			{Name: "to", Text: "citi resort", Expect: "Pickup location?"},
+			{Name: "from", Text: "condo a", Expect: "When?"},
+			{Name: "when", Text: "now", Expect: "How many passengers?"},
+			{Name: tc.name, Text: "2", Expect: tc.expect},
+		})
+		if rec, _ := app.FindRecord(rider); rec.Waiting != StateTo || rec.To != "" || rec.From != "condo a" {
+			t.Errorf("%s: destination must be asked again: %v", tc.name, rec)
+		}
+		osrm.Close()
+	}
+}
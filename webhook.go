@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/line/line-bot-sdk-go/linebot"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
@@ -82,11 +81,10 @@ func (app *HailingApp) Webhook(w http.ResponseWriter, req *http.Request) {
 	user, _ := app.FindUserByID(newData.UserID)
 	localizer := i18n.NewLocalizer(app.i18nBundle, user.Language)
 	log.Printf("[WEBHOOK] user=%v, lang=%v\n", user.Username, user.Language)
-	bkk, _ := time.LoadLocation("Asia/Bangkok")
 	// FIXME: localizer doesn't seem to work here somehow. It return English while lang is th.
 	if oldData.AcceptedAt == nil && newData.AcceptedAt != nil {
 		// notify
-		bkkReservedTime := newData.ReservedAt.In(bkk)
+		bkkReservedTime := newData.ReservedAt.In(bangkok)
 		hhmm := localizer.MustLocalize(&i18n.LocalizeConfig{
 			DefaultMessage: &i18n.Message{
 				ID:    "HHMM",
@@ -113,7 +111,7 @@ func (app *HailingApp) Webhook(w http.ResponseWriter, req *http.Request) {
 				"LocalTime": hhmm,
 			},
 		})
-		msg := linebot.NewTextMessage(txt)
+		msg := TextMessage{Text: txt}
 		app.PushNotification(user.LineUserID, msg)
 
 	} else if oldData.PickedUpAt == nil && newData.PickedUpAt != nil {
@@ -124,12 +122,12 @@ func (app *HailingApp) Webhook(w http.ResponseWriter, req *http.Request) {
 				Other: "Welcome aboard!",
 			},
 		})
-		msg := linebot.NewTextMessage(welcome)
+		msg := TextMessage{Text: welcome}
 		app.PushNotification(user.LineUserID, msg)
 
 	} else if oldData.DroppedOffAt == nil && newData.DroppedOffAt != nil {
 		// send feedback form
-		// msg := linebot.NewTextMessage("Ride is done, any feedback?")
+		// msg := TextMessage{Text: "Ride is done, any feedback?"}
 		// TODO: should get tripID and pass along too
 		tripID := newData.ID
 		msg := app.StarFeedbackFlex(tripID, localizer)
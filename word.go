@@ -1,32 +1,273 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Keyword groups in words file
+const (
+	// KeywordInit starts the reservation process
+	KeywordInit = "init"
+	// KeywordCancel cancels the reservation
+	KeywordCancel = "cancel"
+	// KeywordStatus asks for the reservation status
+	KeywordStatus = "status"
+)
+
+var (
+	requiredKeywords = []string{KeywordInit, KeywordCancel, KeywordStatus}
+	wordLanguages    = []string{"en", "th", "ja"}
 )
 
-// WordsToInit is an array of eligible words for init reservation process
-var WordsToInit = []string{"call the cab", "i need a ride", "ride now", "reserve ride", "init", "แท็กซี่"}
+// PlaceWords is a designated place and what riders call it
+type PlaceWords struct {
+	Name   string     `toml:"name"`
+	Coords [2]float64 `toml:"coords"`
+	// Aliases by language, i.e. en, th & ja
+	Aliases map[string][]string `toml:"aliases"`
+}
+
+// NameIn returns the first alias in lang, or Name
+func (p *PlaceWords) NameIn(lang string) string {
+	if aliases := p.Aliases[lang]; len(aliases) > 0 {
+		return aliases[0]
+	}
+	return p.Name
+}
+
+// Words is what riders type which the bot understands
+type Words struct {
+	// Keywords by group then language
+	Keywords map[string]map[string][]string `toml:"keywords"`
+	// Places in the order of popularity
+	Places []PlaceWords `toml:"places"`
+	// Intents are cues of free text by intent then language (see ParseUtterance)
+	Intents map[Intent]map[string][]string `toml:"intents"`
+}
+
+// Validate checks that every place has its name & coordinates and no word
+// means two things
+func (w *Words) Validate() error {
+	meaning := map[string]string{}
+	claim := func(word string, what string) error {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			return fmt.Errorf("%s: empty word", what)
+		}
+		if other, ok := meaning[word]; ok && other != what {
+			return fmt.Errorf("%q is both %s and %s", word, other, what)
+		}
+		meaning[word] = what
+		return nil
+	}
+	for _, group := range requiredKeywords {
+		total := 0
+		for _, list := range w.Keywords[group] {
+			total += len(list)
+		}
+		if total == 0 {
+			return fmt.Errorf("keywords.%s: no word", group)
+		}
+	}
+	for group, byLang := range w.Keywords {
+		for lang, list := range byLang {
+			if indexOf(lang, wordLanguages) == -1 {
+				return fmt.Errorf("keywords.%s: unknown language %q", group, lang)
+			}
+			for _, word := range list {
+				if err := claim(word, "keyword "+group); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cues := map[string]Intent{}
+	for intent, byLang := range w.Intents {
+		if indexOf(string(intent), intentNames()) == -1 {
+			return fmt.Errorf("intents.%s: unknown intent", intent)
+		}
+		for lang, list := range byLang {
+			if indexOf(lang, wordLanguages) == -1 {
+				return fmt.Errorf("intents.%s: unknown language %q", intent, lang)
+			}
+			for _, cue := range list {
+				cue = strings.ToLower(strings.TrimSpace(cue))
+				if cue == "" {
+					return fmt.Errorf("intents.%s: empty cue", intent)
+				}
+				if other, ok := cues[cue]; ok && other != intent {
+					return fmt.Errorf("%q is both intents.%s and intents.%s", cue, other, intent)
+				}
+				cues[cue] = intent
+			}
+		}
+	}
+	if len(w.Places) == 0 {
+		return fmt.Errorf("places: no place")
+	}
+	for i, place := range w.Places {
+		what := fmt.Sprintf("place %q", place.Name)
+		if strings.TrimSpace(place.Name) == "" {
+			return fmt.Errorf("places[%d]: no name", i)
+		}
+		lon, lat := place.Coords[0], place.Coords[1]
+		if place.Coords == [2]float64{0, 0} || lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+			return fmt.Errorf("%s: invalid coords %v, must be [longitude, latitude]", what, place.Coords)
+		}
+		if err := claim(place.Name, what); err != nil {
+			return err
+		}
+		for lang, aliases := range place.Aliases {
+			if indexOf(lang, wordLanguages) == -1 {
+				return fmt.Errorf("%s: unknown language %q", what, lang)
+			}
+			for _, alias := range aliases {
+				if err := claim(alias, what); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// IsKeyword reports whether text is one of the keywords of group
+// in any language
+func (w *Words) IsKeyword(text string, group string) bool {
+	for _, list := range w.Keywords[group] {
+		if IsThisIn(strings.TrimSpace(text), list) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPlace returns the place named text in any language, or nil
+func (w *Words) FindPlace(text string) *PlaceWords {
+	text = strings.TrimSpace(text)
+	for i := range w.Places {
+		place := &w.Places[i]
+		if strings.EqualFold(place.Name, text) {
+			return place
+		}
+		for _, aliases := range place.Aliases {
+			for _, alias := range aliases {
+				if strings.EqualFold(alias, text) {
+					return place
+				}
+			}
+		}
+	}
+	return nil
+}
 
-// WordsToCancel is an array of eligible words for cancel reservation
-var WordsToCancel = []string{"!reset", "reset", "cancel", "ยกเลิก", "start over"}
+// LoadWords reads & validates words file
+func LoadWords(path string) (*Words, error) {
+	var w Words
+	if _, err := toml.DecodeFile(path, &w); err != nil {
+		return nil, err
+	}
+	if err := w.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &w, nil
+}
 
-// WordsToAskForStatus is an array of eligible words for asking reservation status
-var WordsToAskForStatus = []string{"!status", "status", "/status"}
+// WordBook keeps words of a file and reloads them when the file changes
+type WordBook struct {
+	path    string
+	mu      sync.RWMutex
+	words   *Words
+	modTime time.Time
+}
 
-// TargetPlaces is an array of eligible words for places in the service
-var TargetPlaces = []string{"condo a", "citi resort", "bts phromphong"}
+// NewWordBook loads words of path
+func NewWordBook(path string) (*WordBook, error) {
+	b := &WordBook{path: path}
+	if _, err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
-// TargetPlaceCoords is a list of coords for places in the service
-var TargetPlaceCoords = [][2]float64{
-	{100.5623, 13.7349},
-	{100.5749098, 13.7354784},
-	{100.5698, 13.7304}}
+// Words returns the current words
+func (b *WordBook) Words() *Words {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.words
+}
+
+// Reload loads the file again if it has changed. Words which don't pass
+// validation are rejected and the current ones are kept.
+func (b *WordBook) Reload() (bool, error) {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false, err
+	}
+	b.mu.RLock()
+	unchanged := b.words != nil && info.ModTime().Equal(b.modTime)
+	b.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	w, err := LoadWords(b.path)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// a broken file is reported once, not at every check
+	b.modTime = info.ModTime()
+	if err != nil {
+		return false, err
+	}
+	b.words = w
+	return true, nil
+}
+
+// Watch reloads the file every interval until stop is closed
+func (b *WordBook) Watch(interval time.Duration, stop <-chan struct{}) {
+	runEvery(interval, stop, func(now time.Time) {
+		reloaded, err := b.Reload()
+		if err != nil {
+			log.Printf("[Words] reload %s: %v", b.path, err)
+		} else if reloaded {
+			log.Printf("[Words] %s reloaded", b.path)
+		}
+	})
+}
+
+var (
+	wordBookOnce sync.Once
+	wordBook     *WordBook
+)
+
+// Vocabulary returns the word book of WORDS_FILE (words.toml by default),
+// loaded on first use
+func Vocabulary() *WordBook {
+	wordBookOnce.Do(func() {
+		path := os.Getenv("WORDS_FILE")
+		if path == "" {
+			path = "words.toml"
+		}
+		b, err := NewWordBook(path)
+		if err != nil {
+			log.Fatalf("[Words] %v", err)
+		}
+		wordBook = b
+	})
+	return wordBook
+}
 
 // IsThisIn is an exactly "key in list"
 func IsThisIn(word string, groupsOfWords []string) bool {
 	lowercase := strings.ToLower(word)
 	for _, a := range groupsOfWords {
-		if a == lowercase {
+		if strings.ToLower(a) == lowercase {
 			return true
 		}
 	}
@@ -45,9 +286,9 @@ func indexOf(word string, data []string) int {
 
 // GetCoordsFromPlace return coords from place name
 func GetCoordsFromPlace(place string) [2]float64 {
-	mInd := indexOf(place, TargetPlaces)
-	if mInd == -1 {
+	found := Vocabulary().Words().FindPlace(place)
+	if found == nil {
 		return [2]float64{0, 0}
 	}
-	return TargetPlaceCoords[mInd]
+	return found.Coords
 }
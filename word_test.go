@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// placeCoords returns coordinates of a place in words.toml
+func placeCoords(name string) [2]float64 {
+	place := Vocabulary().Words().FindPlace(name)
+	if place == nil {
+		panic("no place " + name + " in words file")
+	}
+	return place.Coords
+}
+
+func TestWordsFile(t *testing.T) {
+	w := Vocabulary().Words()
+	tests := []struct {
+		text  string
+		group string
+	}{
+		{"Call the cab", KeywordInit},
+		{"แท็กซี่", KeywordInit},
+		{"タクシー", KeywordInit},
+		{"start over", KeywordCancel},
+		{"ยกเลิก", KeywordCancel},
+		{"status", KeywordStatus},
+		{"状況", KeywordStatus},
+	}
+	for _, tc := range tests {
+		if !w.IsKeyword(tc.text, tc.group) {
+			t.Errorf("%q must be %s keyword", tc.text, tc.group)
+		}
+	}
+	if w.IsKeyword("cancel", KeywordInit) {
+		t.Error("cancel isn't init keyword")
+	}
+	if place := w.FindPlace("บีทีเอส พร้อมพงษ์"); place == nil || place.Name != "bts phromphong" {
+		t.Errorf("Thai alias must find the place: %v", place)
+	}
+	if GetCoordsFromPlace("Citi Resort") != placeCoords("citi resort") {
+		t.Error("coords of place name mismatch")
+	}
+}
+
+func TestWordBookReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "words.toml")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	valid := `
+[keywords]
+init = { en = ["go"] }
+cancel = { en = ["stop"] }
+status = { en = ["where"] }
+
+[[places]]
+name = "home"
+coords = [100.56, 13.73]
+`
+	now := time.Now()
+	write(valid, now.Add(-time.Hour))
+	book, err := NewWordBook(path)
+	if err != nil {
+		t.Fatal("NewWordBook failed: ", err)
+	}
+	if reloaded, err := book.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged file must not be reloaded: %v %v", reloaded, err)
+	}
+
+	invalid := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{"swapped coords", strings.Replace(valid, "[100.56, 13.73]", "[13.73, 100.56]", 1), "invalid coords"},
+		{"missing coords", strings.Replace(valid, "coords = [100.56, 13.73]", "", 1), "invalid coords"},
+		{"same word twice", strings.Replace(valid, `["stop"]`, `["go"]`, 1), `"go" is both`},
+		{"alias is keyword", valid + `aliases = { th = ["where"] }`, `"where" is both`},
+		{"no status", strings.Replace(valid, `status = { en = ["where"] }`, "", 1), "keywords.status"},
+		{"unknown language", strings.Replace(valid, `{ en = ["go"] }`, `{ fr = ["go"] }`, 1), "unknown language"},
+	}
+	for i, tc := range invalid {
+		write(tc.content, now.Add(time.Duration(i)*time.Minute))
+		reloaded, err := book.Reload()
+		if reloaded || err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Errorf("%s: expect %q, got %v %v", tc.name, tc.expect, reloaded, err)
+		}
+		if book.Words().FindPlace("home") == nil {
+			t.Errorf("%s: invalid file must keep current words", tc.name)
+		}
+	}
+
+	write(strings.Replace(valid, `["go"]`, `["go", "ไป"]`, 1), now.Add(time.Hour))
+	if reloaded, err := book.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed file must be reloaded: %v %v", reloaded, err)
+	}
+	if !book.Words().IsKeyword("ไป", KeywordInit) {
+		t.Error("new keyword must be there after reload")
+	}
+}